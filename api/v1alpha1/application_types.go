@@ -3,8 +3,10 @@ package v1alpha1
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -15,33 +17,406 @@ func init() {
 	SchemeBuilder.Register(&Application{}, &ApplicationList{})
 }
 
+// +kubebuilder:validation:XValidation:rule="has(self.repository) != has(self.image)",message="exactly one of repository or image must be set"
 type ApplicationSpec struct {
-	ApplicationName string              `json:"appName"`
-	Namespace       string              `json:"namespace"`
-	Repository      *Repository         `json:"repository,omitempty"`
-	DockerConfig    *DockerConfig       `json:"dockerConfig,omitempty"`
-	Acr             *Acr                `json:"acr,omitempty"`
-	Resources       *ResourceDefinition `json:"resourceDefinition,omitempty"`
-	AppPort         string              `json:"appPort"`
+	ApplicationName string      `json:"appName"`
+	Namespace       string      `json:"namespace"`
+	Repository      *Repository `json:"repository,omitempty"`
+	// Image deploys an image built elsewhere instead of building Repository. It can reference a tag or a digest,
+	// e.g. myregistry.azurecr.io/app:v1 or myregistry.azurecr.io/app@sha256:...
+	Image        string              `json:"image,omitempty"`
+	DockerConfig *DockerConfig       `json:"dockerConfig,omitempty"`
+	Acr          *Acr                `json:"acr,omitempty"`
+	Build        *BuildConfig        `json:"build,omitempty"`
+	Resources    *ResourceDefinition `json:"resourceDefinition,omitempty"`
+	AppPort      string              `json:"appPort"`
+	// Env sets environment variables in the application container
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// EnvFrom sets environment variables in the application container from ConfigMaps and Secrets
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+	// Files mounts the keys of ConfigMaps and Secrets as files in the application container
+	Files []FileMount `json:"files,omitempty"`
+	// KeyVault mounts secrets from an Azure Key Vault in the application container with the secrets-store CSI driver
+	KeyVault *KeyVault `json:"keyVault,omitempty"`
+	// Expose configures how the Application is reached, defaults to a LoadBalancer Service
+	Expose *Expose `json:"expose,omitempty"`
+	// Mesh enrolls the Application in Open Service Mesh and configures its traffic policies
+	Mesh *Mesh `json:"mesh,omitempty"`
+	// Rollout shifts traffic to a new image gradually, a new image replaces the deployed one at once when not set
+	Rollout *Rollout `json:"rollout,omitempty"`
+	// RevisionHistoryLimit is the number of ApplicationRevisions kept, defaults to 10
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// RollbackTo redeploys the manifests of an ApplicationRevision of the Application without building, until it
+	// is cleared
+	RollbackTo *int64 `json:"rollbackTo,omitempty"`
+	// Delivery selects how the rendered manifests reach the cluster, they are applied directly when not set
+	Delivery *Delivery `json:"delivery,omitempty"`
 }
 
+// DeliveryMode selects how the rendered manifests of an Application reach the cluster
+// +kubebuilder:validation:Enum=apply;gitops
+type DeliveryMode string
+
+const (
+	// DeliveryModeApply server-side applies the rendered manifests
+	DeliveryModeApply DeliveryMode = "apply"
+	// DeliveryModeGitOps commits the rendered manifests to a config repository a GitOps agent like Flux or Argo CD
+	// syncs, nothing is applied to the cluster
+	DeliveryModeGitOps DeliveryMode = "gitops"
+)
+
+type Delivery struct {
+	// Mode defaults to apply
+	Mode DeliveryMode `json:"mode,omitempty"`
+	// GitOps is the config repository manifests are committed to, required for the gitops mode
+	GitOps *GitOpsDelivery `json:"gitops,omitempty"`
+}
+
+type GitOpsDelivery struct {
+	// Repository is the GitHub config repository, manifests are committed to its branchName
+	Repository Repository `json:"repository"`
+	// Path is the directory of the repository the manifests are written to, defaults to the name of the Application
+	Path string `json:"path,omitempty"`
+	// PullRequest proposes every change to the manifests in a pull request into branchName instead of committing
+	// to it
+	PullRequest bool `json:"pullRequest,omitempty"`
+}
+
+// GetDeliveryMode returns how the manifests of the Application are delivered, apply when no mode is set
+func (n *Application) GetDeliveryMode() DeliveryMode {
+	if n.Spec.Delivery == nil || n.Spec.Delivery.Mode == "" {
+		return DeliveryModeApply
+	}
+
+	return n.Spec.Delivery.Mode
+}
+
+// RolloutStrategy selects how traffic is shifted to a new image
+// +kubebuilder:validation:Enum=Canary;BlueGreen
+type RolloutStrategy string
+
+const (
+	// RolloutStrategyCanary shifts traffic to the new image in the percentages of steps
+	RolloutStrategyCanary RolloutStrategy = "Canary"
+	// RolloutStrategyBlueGreen runs the new image next to the deployed one without traffic and switches all traffic
+	// to it once it is available
+	RolloutStrategyBlueGreen RolloutStrategy = "BlueGreen"
+)
+
+// TrafficRouting selects what splits traffic between the stable and canary Deployments
+// +kubebuilder:validation:Enum=SMI;Gateway
+type TrafficRouting string
+
+const (
+	// TrafficRoutingSMI splits traffic with an SMI TrafficSplit, it requires spec.mesh
+	TrafficRoutingSMI TrafficRouting = "SMI"
+	// TrafficRoutingGateway splits traffic with weighted backends of the HTTPRoute, it requires a Gateway expose
+	TrafficRoutingGateway TrafficRouting = "Gateway"
+)
+
+type Rollout struct {
+	Strategy RolloutStrategy `json:"strategy"`
+	// TrafficRouting defaults to Gateway when the Application is exposed through a Gateway and SMI otherwise
+	TrafficRouting TrafficRouting `json:"trafficRouting,omitempty"`
+	// Steps are the increasing percentages of traffic the canary receives before it is promoted, defaults to 10
+	// and 50. BlueGreen rollouts have no steps.
+	Steps []int32 `json:"steps,omitempty"`
+	// StepInterval is how long each step is observed healthy before the next one, defaults to 1m
+	StepInterval *metav1.Duration `json:"stepInterval,omitempty"`
+	// ProgressDeadline is how long the canary has to become available before the rollout is aborted, defaults
+	// to 10m
+	ProgressDeadline *metav1.Duration `json:"progressDeadline,omitempty"`
+}
+
+// Mesh configures how the Application takes part in Open Service Mesh. An Application exposed on a LoadBalancer
+// accepts traffic from outside the mesh from any address.
+type Mesh struct {
+	// MeshName is the name of the mesh the target namespace is enrolled in, defaults to osm, the AKS add-on mesh.
+	// Applications deploying to the same namespace must use the same mesh.
+	MeshName string `json:"meshName,omitempty"`
+	// IngressSource is the ingress controller Service allowed to send traffic to the Application when it is exposed
+	// through an Ingress or Gateway, defaults to the AKS application routing nginx controller
+	IngressSource *MeshServiceReference `json:"ingressSource,omitempty"`
+	// Egress lists the external dependencies the Application is allowed to reach
+	Egress []MeshEgress `json:"egress,omitempty"`
+	// Retry retries failed requests from the Application to other mesh Services
+	Retry *MeshRetry `json:"retry,omitempty"`
+	// RateLimit limits the requests the Application accepts
+	RateLimit *MeshRateLimit `json:"rateLimit,omitempty"`
+	// CircuitBreaker limits the connections and requests to the Application
+	CircuitBreaker *MeshCircuitBreaker `json:"circuitBreaker,omitempty"`
+}
+
+type MeshServiceReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// MeshProtocol is the protocol of mesh traffic
+// +kubebuilder:validation:Enum=http;https;tcp
+type MeshProtocol string
+
+const (
+	MeshProtocolHTTP  MeshProtocol = "http"
+	MeshProtocolHTTPS MeshProtocol = "https"
+	MeshProtocolTCP   MeshProtocol = "tcp"
+)
+
+type MeshEgress struct {
+	// Name identifies the dependency, it is part of the name of the generated Egress policy
+	Name string `json:"name"`
+	// Hosts are matched against the Host header of http traffic and the SNI of https traffic
+	Hosts []string `json:"hosts,omitempty"`
+	// IPAddresses are CIDR ranges matched against the destination of tcp traffic
+	IPAddresses []string     `json:"ipAddresses,omitempty"`
+	Port        int32        `json:"port"`
+	Protocol    MeshProtocol `json:"protocol"`
+}
+
+type MeshRetry struct {
+	// Destinations are the Services requests to are retried
+	Destinations []MeshServiceReference `json:"destinations"`
+	// RetryOn lists the envoy retry conditions, defaults to 5xx
+	RetryOn    string `json:"retryOn,omitempty"`
+	NumRetries *int32 `json:"numRetries,omitempty"`
+	// PerTryTimeout is the time allowed for each attempt, defaults to 15s
+	PerTryTimeout *metav1.Duration `json:"perTryTimeout,omitempty"`
+	// BackoffBaseInterval is the base interval of the exponential backoff between attempts, defaults to 25ms
+	BackoffBaseInterval *metav1.Duration `json:"backoffBaseInterval,omitempty"`
+}
+
+// RateLimitUnit is the period a rate limit applies to
+// +kubebuilder:validation:Enum=second;minute;hour
+type RateLimitUnit string
+
+type MeshRateLimit struct {
+	// Requests is the number of requests accepted per Unit
+	Requests int32         `json:"requests"`
+	Unit     RateLimitUnit `json:"unit"`
+	// Burst is the number of requests above the rate accepted in a short period
+	Burst int32 `json:"burst,omitempty"`
+}
+
+type MeshCircuitBreaker struct {
+	MaxConnections     *int32 `json:"maxConnections,omitempty"`
+	MaxRequests        *int32 `json:"maxRequests,omitempty"`
+	MaxPendingRequests *int32 `json:"maxPendingRequests,omitempty"`
+	MaxRetries         *int32 `json:"maxRetries,omitempty"`
+}
+
+// ExposeType selects how an Application is exposed
+// +kubebuilder:validation:Enum=ClusterIP;LoadBalancer;Ingress;Gateway
+type ExposeType string
+
+const (
+	// ExposeTypeClusterIP exposes the Application inside the cluster only
+	ExposeTypeClusterIP ExposeType = "ClusterIP"
+	// ExposeTypeLoadBalancer exposes the Application on a load balancer IP
+	ExposeTypeLoadBalancer ExposeType = "LoadBalancer"
+	// ExposeTypeIngress exposes the Application through an Ingress in front of a ClusterIP Service
+	ExposeTypeIngress ExposeType = "Ingress"
+	// ExposeTypeGateway exposes the Application through a Gateway API HTTPRoute in front of a ClusterIP Service
+	ExposeTypeGateway ExposeType = "Gateway"
+)
+
+type Expose struct {
+	Type ExposeType `json:"type"`
+	// Port is the Service port, defaults to appPort
+	Port int32 `json:"port,omitempty"`
+	// Host is the hostname routed to the Application by an Ingress or Gateway
+	Host string `json:"host,omitempty"`
+	// Path is the path prefix routed to the Application by an Ingress or Gateway, defaults to /
+	Path string `json:"path,omitempty"`
+	// IngressClassName selects the ingress controller, defaults to the cluster default
+	IngressClassName string `json:"ingressClassName,omitempty"`
+	// TLSSecretName is a Secret in the target namespace with the certificate the Ingress terminates TLS with
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+	// Gateway is the Gateway the HTTPRoute attaches to, required for the Gateway type
+	Gateway *GatewayReference `json:"gateway,omitempty"`
+}
+
+type GatewayReference struct {
+	Name string `json:"name"`
+	// Namespace defaults to the Application's target namespace
+	Namespace string `json:"namespace,omitempty"`
+	// SectionName selects a listener of the Gateway
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// FileMount mounts every key of a ConfigMap or Secret in the Application's target namespace as a file in MountPath
+// +kubebuilder:validation:XValidation:rule="has(self.configMapName) != has(self.secretName)",message="exactly one of configMapName or secretName must be set"
+type FileMount struct {
+	MountPath     string `json:"mountPath"`
+	ConfigMapName string `json:"configMapName,omitempty"`
+	SecretName    string `json:"secretName,omitempty"`
+}
+
+// KeyVault describes the Azure Key Vault objects mounted in the application container. A SecretProviderClass is
+// generated for them and mounted at MountPath.
+type KeyVault struct {
+	VaultName string `json:"vaultName"`
+	TenantID  string `json:"tenantId"`
+	// ClientID is the client ID of the workload identity used to read the vault, it must be federated with
+	// ServiceAccountName
+	ClientID string `json:"clientId"`
+	// ServiceAccountName is the service account the application pods run as, defaults to the default service account
+	ServiceAccountName string           `json:"serviceAccountName,omitempty"`
+	Objects            []KeyVaultObject `json:"objects"`
+	// MountPath defaults to /mnt/secrets-store
+	MountPath string `json:"mountPath,omitempty"`
+	// SecretName also syncs the objects into a Secret of that name in the target namespace so they can be used
+	// from env and envFrom. The driver only creates the Secret once a pod has mounted the objects.
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// KeyVaultObjectType is the type of a Key Vault object
+// +kubebuilder:validation:Enum=secret;key;cert
+type KeyVaultObjectType string
+
+const (
+	KeyVaultObjectTypeSecret KeyVaultObjectType = "secret"
+	KeyVaultObjectTypeKey    KeyVaultObjectType = "key"
+	KeyVaultObjectTypeCert   KeyVaultObjectType = "cert"
+)
+
+type KeyVaultObject struct {
+	Name string `json:"name"`
+	// Type defaults to secret
+	Type KeyVaultObjectType `json:"type,omitempty"`
+	// Version defaults to the latest version
+	Version string `json:"version,omitempty"`
+	// Key is the key of the object in the synced Secret, defaults to Name
+	Key string `json:"key,omitempty"`
+}
+
+// GitProvider is the service hosting a Repository
+// +kubebuilder:validation:Enum=GitHub;GitLab;AzureDevOps;Bitbucket;Git
+type GitProvider string
+
+const (
+	GitProviderGitHub      GitProvider = "GitHub"
+	GitProviderGitLab      GitProvider = "GitLab"
+	GitProviderAzureDevOps GitProvider = "AzureDevOps"
+	GitProviderBitbucket   GitProvider = "Bitbucket"
+	// GitProviderGit is any git server reachable over https or ssh, only its refs can be read
+	GitProviderGit GitProvider = "Git"
+)
+
 type Repository struct {
-	Owner      string `json:"owner"`
-	Name       string `json:"name"`
+	// Provider defaults to GitHub
+	Provider GitProvider `json:"provider,omitempty"`
+	// URL is the clone url of a Git repository, https:// or ssh://, or the https url of a self-hosted GitLab or
+	// Azure DevOps Server
+	URL string `json:"url,omitempty"`
+	// Owner is the GitHub owner, GitLab group, Azure DevOps organization/project or Bitbucket workspace of the
+	// repository, unused for Git
+	Owner string `json:"owner,omitempty"`
+	// Name is the name of the repository, unused for Git
+	Name       string `json:"name,omitempty"`
 	BranchName string `json:"branchName"`
+	// CredentialsSecretName is a Secret in the Application's namespace private repositories are read with. It
+	// holds either a personal access token in token or the appId, installationId and privateKey of a GitHub App.
+	// Git repositories take an optional username with the token, or an sshPrivateKey and knownHosts.
+	CredentialsSecretName string `json:"credentialsSecretName,omitempty"`
+	// ReportStatus posts the build of every commit as a commit status and records deploys as Deployments of the
+	// GitHub repository, the credentials need to be allowed to write statuses and deployments
+	ReportStatus bool `json:"reportStatus,omitempty"`
+}
+
+// GetProvider returns the provider hosting the repository, GitHub when none is set
+func (r *Repository) GetProvider() GitProvider {
+	if r.Provider == "" {
+		return GitProviderGitHub
+	}
+
+	return r.Provider
+}
+
+// IsSSH returns whether the repository is cloned over ssh, only Git repositories can be
+func (r *Repository) IsSSH() bool {
+	if r.GetProvider() != GitProviderGit {
+		return false
+	}
+
+	return strings.HasPrefix(r.URL, "ssh://") || (!strings.Contains(r.URL, "://") && strings.Contains(r.URL, ":"))
 }
 
 type DockerConfig struct {
-	Dockerfile   string `json:"dockerfile"`
-	BuildContext string `json:"buildContext"`
-	ImageName    string `json:"imageName"`
-	ImageTag     string `json:"imageTag"`
+	// Dockerfile defaults to Dockerfile
+	Dockerfile string `json:"dockerfile,omitempty"`
+	// BuildContext defaults to the repository root
+	BuildContext string `json:"buildContext,omitempty"`
+	// ImageName defaults to the name of the Application
+	ImageName string `json:"imageName,omitempty"`
+	// ImageTag defaults to latest, it is only used by the fixed tag strategy
+	ImageTag string `json:"imageTag,omitempty"`
+	// TagStrategy selects how built images are tagged, fixed when not set. Deployments reference the built image
+	// by its digest whatever the tag.
+	TagStrategy TagStrategy `json:"tagStrategy,omitempty"`
+}
+
+// TagStrategy selects the tag an Application's image is pushed with
+// +kubebuilder:validation:Enum=fixed;commit;branchCommitTimestamp;semver
+type TagStrategy string
+
+const (
+	// TagStrategyFixed tags every build with imageTag
+	TagStrategyFixed TagStrategy = "fixed"
+	// TagStrategyCommit tags a build with the SHA of the commit it built
+	TagStrategyCommit TagStrategy = "commit"
+	// TagStrategyBranchCommitTimestamp tags a build with <branch>-<short SHA>-<unix time of the build>, the tags of a
+	// branch sort in build order
+	TagStrategyBranchCommitTimestamp TagStrategy = "branchCommitTimestamp"
+	// TagStrategySemver tags a build with the highest semantic version git tag of the commit it builds, commits
+	// without one aren't built
+	TagStrategySemver TagStrategy = "semver"
+)
+
+// GetTagStrategy returns the tag strategy of the Application, fixed when none is set
+func (n *Application) GetTagStrategy() TagStrategy {
+	if n.Spec.DockerConfig == nil || n.Spec.DockerConfig.TagStrategy == "" {
+		return TagStrategyFixed
+	}
+
+	return n.Spec.DockerConfig.TagStrategy
 }
 
 type Acr struct {
 	Id string `json:"id"`
 }
 
+// BuildStrategy selects how an Application's image is built
+// +kubebuilder:validation:Enum=acr;kaniko;buildkit
+type BuildStrategy string
+
+const (
+	// BuildStrategyACR builds with ACR Tasks in the registry from spec.acr
+	BuildStrategyACR BuildStrategy = "acr"
+	// BuildStrategyKaniko builds with a Kaniko Job in the cluster and pushes to spec.build.registry
+	BuildStrategyKaniko BuildStrategy = "kaniko"
+	// BuildStrategyBuildKit builds with a rootless BuildKit Job in the cluster and pushes to spec.build.registry
+	BuildStrategyBuildKit BuildStrategy = "buildkit"
+)
+
+type BuildConfig struct {
+	// Strategy defaults to acr
+	Strategy BuildStrategy `json:"strategy,omitempty"`
+	// Registry is the OCI registry in-cluster builds push to, e.g. myregistry.azurecr.io
+	Registry string `json:"registry,omitempty"`
+	// PushSecretName is a kubernetes.io/dockerconfigjson Secret in the Application's namespace used by in-cluster
+	// builds to push to Registry
+	PushSecretName string `json:"pushSecretName,omitempty"`
+}
+
+// GetBuildStrategy returns the build strategy of the Application, acr when none is set
+func (n *Application) GetBuildStrategy() BuildStrategy {
+	if n.Spec.Build == nil || n.Spec.Build.Strategy == "" {
+		return BuildStrategyACR
+	}
+
+	return n.Spec.Build.Strategy
+}
+
 type ResourceDefinition struct {
 	CPULimit string `json:"cpuLimit"`
 	MEMLimit string `json:"memLimit"`
@@ -49,10 +424,201 @@ type ResourceDefinition struct {
 	MEMReq   string `json:"memReq"`
 }
 
+// ApplicationPhase summarizes where an Application is in its build and deploy lifecycle
+type ApplicationPhase string
+
+const (
+	// ApplicationPhaseWaitingForDockerfile waits for the pull request adding a generated Dockerfile to be merged
+	ApplicationPhaseWaitingForDockerfile ApplicationPhase = "WaitingForDockerfile"
+	ApplicationPhaseBuilding             ApplicationPhase = "Building"
+	ApplicationPhaseDeploying            ApplicationPhase = "Deploying"
+	ApplicationPhaseReady                ApplicationPhase = "Ready"
+	ApplicationPhaseFailed               ApplicationPhase = "Failed"
+)
+
+// Condition types reported in ApplicationStatus.Conditions
+const (
+	// ConditionTypeSourceReady indicates the source commit to build was resolved
+	ConditionTypeSourceReady = "SourceReady"
+	// ConditionTypeDockerfileReady indicates the Dockerfile to build exists in the repository
+	ConditionTypeDockerfileReady = "DockerfileReady"
+	// ConditionTypeBuildSucceeded indicates an image was built for the current source and build configuration
+	ConditionTypeBuildSucceeded = "BuildSucceeded"
+	// ConditionTypeDeployed indicates every rendered object was applied
+	ConditionTypeDeployed = "Deployed"
+	// ConditionTypeAvailable indicates the deployed workload is available
+	ConditionTypeAvailable = "Available"
+	// ConditionTypeSecretsMounted indicates the pods of the deployed workload mounted the Key Vault objects
+	ConditionTypeSecretsMounted = "SecretsMounted"
+	// ConditionTypeRolledOut indicates the latest image serves all traffic and no rollout is in progress
+	ConditionTypeRolledOut = "RolledOut"
+	// ConditionTypeMeshEnrolled indicates the target namespace is enrolled in the mesh of spec.mesh
+	ConditionTypeMeshEnrolled = "MeshEnrolled"
+)
+
 type ApplicationStatus struct {
-	Conditions []metav1.Condition `json:"conditions"`
+	Phase ApplicationPhase `json:"phase,omitempty"`
+	// ObservedGeneration is the generation of the spec the status was last reconciled against
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Image is the image reference currently deployed
+	Image       string `json:"image,omitempty"`
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// Commit is the source commit the deployed image was built from
+	Commit string `json:"commit,omitempty"`
+	// URL is where the Application is reached through its Service, Ingress or Gateway, empty until an address is
+	// assigned
+	URL        string             `json:"url,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Build describes the last successful build of the Application source
+	Build *BuildStatus `json:"build,omitempty"`
+	// Run describes the latest build run, which may still be in progress
+	Run *RunStatus `json:"run,omitempty"`
+	// Dockerfile describes the Dockerfile generated for a repository without one, until it is merged
+	Dockerfile *DockerfileStatus `json:"dockerfile,omitempty"`
+	// Resources lists the objects rendered for the Application and the result of applying each of them
+	Resources []ResourceStatus `json:"resources,omitempty"`
+	// Rollout describes the current or last rollout of a new image
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+	// Revision is the ApplicationRevision currently deployed
+	Revision int64 `json:"revision,omitempty"`
+	// Delivery describes the last commit of the manifests to the config repository in the gitops delivery mode
+	Delivery *DeliveryStatus `json:"delivery,omitempty"`
+	// GitHubDeployment is the GitHub Deployment recording the deploy of Commit when spec.repository.reportStatus is set
+	GitHubDeployment *GitHubDeploymentStatus `json:"githubDeployment,omitempty"`
+}
+
+type GitHubDeploymentStatus struct {
+	ID int64 `json:"id"`
+	// Commit is the commit the Deployment was created for
+	Commit string `json:"commit"`
+	// State is the last state reported for the Deployment
+	State string `json:"state,omitempty"`
+}
+
+// RolloutPhase is the phase of a rollout
+type RolloutPhase string
+
+const (
+	// RolloutPhaseProgressing shifts traffic to the canary step by step
+	RolloutPhaseProgressing RolloutPhase = "Progressing"
+	// RolloutPhasePromoting updates the stable Deployment to the canary image while the canary serves all traffic
+	RolloutPhasePromoting RolloutPhase = "Promoting"
+	// RolloutPhaseSucceeded means the canary image was promoted to stable
+	RolloutPhaseSucceeded RolloutPhase = "Succeeded"
+	// RolloutPhaseAborted means the canary failed and all traffic was shifted back to the stable image
+	RolloutPhaseAborted RolloutPhase = "Aborted"
+)
+
+type RolloutStatus struct {
+	Phase RolloutPhase `json:"phase,omitempty"`
+	// StableImage is the image serving traffic outside of a rollout
+	StableImage string `json:"stableImage"`
+	// CanaryImage is the image being rolled out, or the image of the aborted rollout which isn't retried
+	CanaryImage string `json:"canaryImage,omitempty"`
+	// Step is the index of the current step, step 0 runs the canary without traffic
+	Step int32 `json:"step,omitempty"`
+	// Weight is the percentage of traffic sent to the canary
+	Weight        int32        `json:"weight,omitempty"`
+	StepStartTime *metav1.Time `json:"stepStartTime,omitempty"`
+	Message       string       `json:"message,omitempty"`
+	// History records every step of the current or last rollout
+	History []RolloutStepRecord `json:"history,omitempty"`
+}
+
+type RolloutStepRecord struct {
+	Phase   RolloutPhase `json:"phase"`
+	Step    int32        `json:"step"`
+	Weight  int32        `json:"weight"`
+	Time    metav1.Time  `json:"time"`
+	Message string       `json:"message,omitempty"`
+}
+
+type DockerfileStatus struct {
+	// Commit is the latest source commit the Dockerfile was found missing at
+	Commit string `json:"commit"`
+	// Language is the language of the source the Dockerfile was generated for
+	Language string `json:"language,omitempty"`
+	// Branch holds the generated Dockerfile and .dockerignore
+	Branch string `json:"branch,omitempty"`
+	// PullRequestURL is the pull request merging Branch, builds start once it is merged
+	PullRequestURL string `json:"pullRequestURL,omitempty"`
 }
 
+type DeliveryStatus struct {
+	// Commit is the config repository commit holding the manifests
+	Commit string `json:"commit,omitempty"`
+	// Path is the file in the config repository the manifests are written to
+	Path string `json:"path,omitempty"`
+	// ManifestsHash is a hash of the committed manifests, they are only committed again when it changes
+	ManifestsHash string `json:"manifestsHash,omitempty"`
+	// PullRequestURL is the pull request proposing Commit when the manifests are delivered by pull request
+	PullRequestURL string `json:"pullRequestURL,omitempty"`
+}
+
+type BuildStatus struct {
+	// Commit is the source commit the image was built from
+	Commit string `json:"commit,omitempty"`
+	// InputHash is a hash of the commit and the build configuration, a new build is only scheduled when it changes
+	InputHash   string `json:"inputHash,omitempty"`
+	Image       string `json:"image,omitempty"`
+	ImageDigest string `json:"imageDigest,omitempty"`
+}
+
+// RunState is the state of a build run
+type RunState string
+
+const (
+	RunStateRunning   RunState = "Running"
+	RunStateSucceeded RunState = "Succeeded"
+	RunStateFailed    RunState = "Failed"
+)
+
+type RunStatus struct {
+	// ID identifies the run with the build service so it can be tracked across controller restarts
+	ID string `json:"id"`
+	// Strategy is the build strategy that started the run
+	Strategy       BuildStrategy `json:"strategy,omitempty"`
+	State          RunState      `json:"state"`
+	Commit         string        `json:"commit,omitempty"`
+	InputHash      string        `json:"inputHash,omitempty"`
+	Message        string        `json:"message,omitempty"`
+	StartTime      *metav1.Time  `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time  `json:"completionTime,omitempty"`
+	// LogTail is the end of the build log once the run completed, for builders that keep their log
+	LogTail string `json:"logTail,omitempty"`
+	// LogConfigMap is the ConfigMap in the namespace of the Application holding the build log, the end of the log
+	// when it is too large for a ConfigMap
+	LogConfigMap string `json:"logConfigMap,omitempty"`
+}
+
+// ApplyResult describes the outcome of server-side applying a rendered object
+type ApplyResult string
+
+const (
+	ApplyResultCreated    ApplyResult = "Created"
+	ApplyResultConfigured ApplyResult = "Configured"
+	ApplyResultUnchanged  ApplyResult = "Unchanged"
+	ApplyResultFailed     ApplyResult = "Failed"
+)
+
+type ResourceStatus struct {
+	APIVersion string      `json:"apiVersion"`
+	Kind       string      `json:"kind"`
+	Namespace  string      `json:"namespace,omitempty"`
+	Name       string      `json:"name"`
+	Result     ApplyResult `json:"result"`
+	Message    string      `json:"message,omitempty"`
+}
+
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.status.image`
+// +kubebuilder:printcolumn:name="Commit",type=string,JSONPath=`.status.commit`,priority=1
+// +kubebuilder:printcolumn:name="Run",type=string,JSONPath=`.status.run.id`,priority=1
+// +kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.url`
+// +kubebuilder:printcolumn:name="Rollout",type=string,JSONPath=`.status.rollout.phase`,priority=1
+// +kubebuilder:printcolumn:name="Revision",type=integer,JSONPath=`.status.revision`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 type Application struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -77,6 +643,20 @@ func (n *Application) SetCondition(c metav1.Condition) {
 	meta.SetStatusCondition(&n.Status.Conditions, c)
 }
 
+// MeshName returns the mesh the target namespace is enrolled in, empty when the Application isn't in a mesh
+func (n *Application) MeshName() string {
+	if n.Spec.Mesh == nil {
+		return ""
+	}
+	if n.Spec.Mesh.MeshName == "" {
+		return DefaultMeshName
+	}
+	return n.Spec.Mesh.MeshName
+}
+
+// Collides returns whether another Application with the same name deploys to the same target namespace, which would
+// have both Applications fighting over the same objects. Objects are rendered with the name of the Application,
+// spec.appName doesn't name any of them. Applications enrolling the same namespace in different meshes collide too.
 func (n *Application) Collides(ctx context.Context, cl client.Client) (bool, string, error) {
 	lgr := logr.FromContextOrDiscard(ctx).WithValues("name", n.Name, "namespace", n.Namespace)
 	lgr.Info("checking for Application collisions")
@@ -87,11 +667,20 @@ func (n *Application) Collides(ctx context.Context, cl client.Client) (bool, str
 		return false, "", fmt.Errorf("listing Applications: %w", err)
 	}
 
-	// TODO: likely need to check ACR + other things later
 	for _, app := range appList.Items {
+		// an update lists the Application being validated too
 		if app.Name == n.Name && app.Namespace == n.Namespace {
-			lgr.Info("Application collision found")
-			return true, fmt.Sprintf("app.Name \"%s\" is invalid because Application \"%s\" already in use", app.Name, app.Name), nil
+			continue
+		}
+
+		if app.Name == n.Name && app.Spec.Namespace == n.Spec.Namespace {
+			lgr.Info("Application collision found", "collidesWith", client.ObjectKeyFromObject(&app))
+			return true, fmt.Sprintf("spec.namespace \"%s\" is invalid because Application \"%s/%s\" already deploys objects named \"%s\" to it", n.Spec.Namespace, app.Namespace, app.Name, n.Name), nil
+		}
+
+		if n.Spec.Mesh != nil && app.Spec.Mesh != nil && app.Spec.Namespace == n.Spec.Namespace && app.MeshName() != n.MeshName() {
+			lgr.Info("Application mesh collision found", "collidesWith", client.ObjectKeyFromObject(&app))
+			return true, fmt.Sprintf("spec.mesh.meshName \"%s\" is invalid because Application \"%s/%s\" enrolls namespace \"%s\" in mesh \"%s\"", n.MeshName(), app.Namespace, app.Name, n.Spec.Namespace, app.MeshName()), nil
 		}
 	}
 
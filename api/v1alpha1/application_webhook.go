@@ -0,0 +1,222 @@
+package v1alpha1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Defaults for the dockerConfig of Applications built from a repository
+const (
+	DefaultDockerfile   = "Dockerfile"
+	DefaultBuildContext = "."
+	DefaultImageTag     = "latest"
+)
+
+// DefaultKeyVaultMountPath is where Key Vault objects are mounted when spec.keyVault.mountPath isn't set
+const DefaultKeyVaultMountPath = "/mnt/secrets-store"
+
+// Defaults for Applications in Open Service Mesh, the AKS add-on mesh and application routing ingress controller
+const (
+	DefaultMeshName               = "osm"
+	DefaultIngressSourceName      = "nginx"
+	DefaultIngressSourceNamespace = "app-routing-system"
+	DefaultRetryOn                = "5xx"
+
+	// DefaultRetryPerTryTimeout and DefaultRetryBackoffBaseInterval match the envoy defaults, OSM requires both
+	DefaultRetryPerTryTimeout       = 15 * time.Second
+	DefaultRetryBackoffBaseInterval = 25 * time.Millisecond
+)
+
+// DefaultRevisionHistoryLimit is the number of ApplicationRevisions kept when spec.revisionHistoryLimit isn't set
+const DefaultRevisionHistoryLimit int32 = 10
+
+// Defaults for rollouts
+const (
+	DefaultRolloutStepInterval     = time.Minute
+	DefaultRolloutProgressDeadline = 10 * time.Minute
+)
+
+// DefaultCanarySteps are the percentages of traffic a canary receives when spec.rollout.steps isn't set
+var DefaultCanarySteps = []int32{10, 50}
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks for Applications with mgr
+func SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&Application{}).
+		WithDefaulter(&applicationDefaulter{}).
+		WithValidator(&applicationValidator{client: mgr.GetClient()}).
+		Complete()
+}
+
+// Default fills in the optional fields of the Application spec
+func (n *Application) Default() {
+	if n.Spec.RevisionHistoryLimit == nil {
+		limit := DefaultRevisionHistoryLimit
+		n.Spec.RevisionHistoryLimit = &limit
+	}
+
+	if m := n.Spec.Mesh; m != nil {
+		if m.MeshName == "" {
+			m.MeshName = DefaultMeshName
+		}
+		if m.IngressSource == nil {
+			m.IngressSource = &MeshServiceReference{Name: DefaultIngressSourceName, Namespace: DefaultIngressSourceNamespace}
+		}
+		if r := m.Retry; r != nil {
+			if r.RetryOn == "" {
+				r.RetryOn = DefaultRetryOn
+			}
+			if r.PerTryTimeout == nil {
+				r.PerTryTimeout = &metav1.Duration{Duration: DefaultRetryPerTryTimeout}
+			}
+			if r.BackoffBaseInterval == nil {
+				r.BackoffBaseInterval = &metav1.Duration{Duration: DefaultRetryBackoffBaseInterval}
+			}
+		}
+	}
+
+	if e := n.Spec.Expose; e != nil && (e.Type == ExposeTypeIngress || e.Type == ExposeTypeGateway) && e.Path == "" {
+		e.Path = "/"
+	}
+
+	if r := n.Spec.Rollout; r != nil {
+		if r.TrafficRouting == "" {
+			r.TrafficRouting = TrafficRoutingSMI
+			if n.Spec.Expose != nil && n.Spec.Expose.Type == ExposeTypeGateway {
+				r.TrafficRouting = TrafficRoutingGateway
+			}
+		}
+		if r.Strategy == RolloutStrategyCanary && len(r.Steps) == 0 {
+			r.Steps = append([]int32{}, DefaultCanarySteps...)
+		}
+		if r.StepInterval == nil {
+			r.StepInterval = &metav1.Duration{Duration: DefaultRolloutStepInterval}
+		}
+		if r.ProgressDeadline == nil {
+			r.ProgressDeadline = &metav1.Duration{Duration: DefaultRolloutProgressDeadline}
+		}
+	}
+
+	if kv := n.Spec.KeyVault; kv != nil {
+		if kv.MountPath == "" {
+			kv.MountPath = DefaultKeyVaultMountPath
+		}
+		for i := range kv.Objects {
+			if kv.Objects[i].Type == "" {
+				kv.Objects[i].Type = KeyVaultObjectTypeSecret
+			}
+			if kv.Objects[i].Key == "" {
+				kv.Objects[i].Key = kv.Objects[i].Name
+			}
+		}
+	}
+
+	if d := n.Spec.Delivery; d != nil && d.GitOps != nil && d.GitOps.Path == "" {
+		d.GitOps.Path = n.Name
+	}
+
+	if n.Spec.Repository == nil {
+		return
+	}
+
+	if n.Spec.DockerConfig == nil {
+		n.Spec.DockerConfig = &DockerConfig{}
+	}
+	if n.Spec.DockerConfig.Dockerfile == "" {
+		n.Spec.DockerConfig.Dockerfile = DefaultDockerfile
+	}
+	if n.Spec.DockerConfig.BuildContext == "" {
+		n.Spec.DockerConfig.BuildContext = DefaultBuildContext
+	}
+	if n.Spec.DockerConfig.ImageName == "" {
+		n.Spec.DockerConfig.ImageName = n.Name
+	}
+	if n.Spec.DockerConfig.ImageTag == "" {
+		n.Spec.DockerConfig.ImageTag = DefaultImageTag
+	}
+}
+
+// +kubebuilder:webhook:path=/mutate-devx-kubernetes-azure-com-v1alpha1-application,mutating=true,failurePolicy=fail,sideEffects=None,groups=devx.kubernetes.azure.com,resources=applications,verbs=create;update,versions=v1alpha1,name=mapplication.devx.kubernetes.azure.com,admissionReviewVersions=v1
+
+type applicationDefaulter struct{}
+
+var _ admission.CustomDefaulter = &applicationDefaulter{}
+
+func (d *applicationDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	app, ok := obj.(*Application)
+	if !ok {
+		return fmt.Errorf("expected an Application but got %T", obj)
+	}
+
+	app.Default()
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-devx-kubernetes-azure-com-v1alpha1-application,mutating=false,failurePolicy=fail,sideEffects=None,groups=devx.kubernetes.azure.com,resources=applications,verbs=create;update,versions=v1alpha1,name=vapplication.devx.kubernetes.azure.com,admissionReviewVersions=v1
+
+type applicationValidator struct {
+	client client.Client
+}
+
+var _ admission.CustomValidator = &applicationValidator{}
+
+func (v *applicationValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	app, ok := obj.(*Application)
+	if !ok {
+		return nil, fmt.Errorf("expected an Application but got %T", obj)
+	}
+
+	return nil, errors.Join(app.Validate(), v.validateCollision(ctx, app))
+}
+
+func (v *applicationValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldApp, ok := oldObj.(*Application)
+	if !ok {
+		return nil, fmt.Errorf("expected an Application but got %T", oldObj)
+	}
+	app, ok := newObj.(*Application)
+	if !ok {
+		return nil, fmt.Errorf("expected an Application but got %T", newObj)
+	}
+
+	// the controller removes its finalizer from deleted Applications, that must go through even if the spec is invalid
+	if !app.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+
+	if err := app.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Applications that collided before the webhook was installed can still be updated as long as they don't move
+	// or change their mesh
+	if app.Spec.Namespace == oldApp.Spec.Namespace && app.MeshName() == oldApp.MeshName() {
+		return nil, nil
+	}
+
+	return nil, v.validateCollision(ctx, app)
+}
+
+func (v *applicationValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *applicationValidator) validateCollision(ctx context.Context, app *Application) error {
+	collides, message, err := app.Collides(ctx, v.client)
+	if err != nil {
+		return fmt.Errorf("checking for collisions: %w", err)
+	}
+	if collides {
+		return errors.New(message)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package v1alpha1
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func init() {
+	SchemeBuilder.Register(&ApplicationRevision{}, &ApplicationRevisionList{})
+}
+
+// RevisionApplicationLabel is set on every ApplicationRevision to the name of its Application
+const RevisionApplicationLabel = "devx.kubernetes.azure.com/application"
+
+// ApplicationRevisionSpec records what an Application deployed
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="an ApplicationRevision is immutable"
+type ApplicationRevisionSpec struct {
+	// ApplicationName is the Application in the same namespace the revision was deployed by
+	ApplicationName string `json:"applicationName"`
+	// Revision numbers the revisions of an Application in the order they were deployed
+	Revision    int64  `json:"revision"`
+	Image       string `json:"image"`
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// Commit is the source commit the image was built from, empty for prebuilt images
+	Commit string `json:"commit,omitempty"`
+	// Manifests are the objects the revision applied, as a multi-document yaml
+	Manifests  string      `json:"manifests"`
+	DeployTime metav1.Time `json:"deployTime"`
+}
+
+// +kubebuilder:printcolumn:name="Application",type=string,JSONPath=`.spec.applicationName`
+// +kubebuilder:printcolumn:name="Revision",type=integer,JSONPath=`.spec.revision`
+// +kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.spec.image`
+// +kubebuilder:printcolumn:name="Commit",type=string,JSONPath=`.spec.commit`,priority=1
+// +kubebuilder:printcolumn:name="Deployed",type=date,JSONPath=`.spec.deployTime`
+type ApplicationRevision struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ApplicationRevisionSpec `json:"spec"`
+}
+
+// RevisionName returns the name of revision of the Application appName
+func RevisionName(appName string, revision int64) string {
+	return fmt.Sprintf("%s-%d", appName, revision)
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ApplicationRevision) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+type ApplicationRevisionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ApplicationRevision `json:"items"`
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ApplicationRevisionList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
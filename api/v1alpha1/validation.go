@@ -0,0 +1,378 @@
+package v1alpha1
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	az "github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// acrResourceType is the ARM resource type spec.acr.id must reference
+const acrResourceType = "Microsoft.ContainerRegistry/registries"
+
+// Validate checks the parts of the Application spec that can be validated without looking at other objects
+func (n *Application) Validate() error {
+	return errors.Join(
+		n.ValidateSource(),
+		n.validateAcr(),
+		n.validateResources(),
+		n.validateAppPort(),
+		n.validateFiles(),
+		n.validateKeyVault(),
+		n.validateExpose(),
+		n.validateMesh(),
+		n.validateRollout(),
+		n.validateRevisions(),
+		n.validateDelivery(),
+	)
+}
+
+// ValidateSource checks that the Application has exactly one source mode, a repository to build or a prebuilt
+// image, and that the configuration that mode needs is set
+func (n *Application) ValidateSource() error {
+	switch {
+	case n.Spec.Repository != nil && n.Spec.Image != "":
+		return errors.New("only one of spec.repository or spec.image can be set")
+	case n.Spec.Repository == nil && n.Spec.Image == "":
+		return errors.New("one of spec.repository or spec.image must be set")
+	case n.Spec.Image != "":
+		return nil
+	}
+
+	if n.Spec.DockerConfig == nil {
+		return errors.New("spec.dockerConfig is required to build spec.repository")
+	}
+	if err := n.validateRepository(); err != nil {
+		return err
+	}
+	// semantic versions are read from the tags of the repository, only GitHub and Git repositories list them
+	if n.GetTagStrategy() == TagStrategySemver {
+		if provider := n.Spec.Repository.GetProvider(); provider != GitProviderGitHub && provider != GitProviderGit {
+			return fmt.Errorf("spec.dockerConfig.tagStrategy %s isn't supported for %s repositories", TagStrategySemver, provider)
+		}
+	}
+
+	switch strategy := n.GetBuildStrategy(); strategy {
+	case BuildStrategyACR:
+		if n.Spec.Acr == nil {
+			return errors.New("spec.acr is required for acr builds")
+		}
+	case BuildStrategyKaniko, BuildStrategyBuildKit:
+		if n.Spec.Build.Registry == "" {
+			return fmt.Errorf("spec.build.registry is required for %s builds", strategy)
+		}
+	default:
+		return fmt.Errorf("unsupported build strategy %q", strategy)
+	}
+
+	return nil
+}
+
+// validateRepository checks that spec.repository has what its provider needs to find it
+func (n *Application) validateRepository() error {
+	repo := n.Spec.Repository
+	if repo.BranchName == "" {
+		return errors.New("spec.repository.branchName is required")
+	}
+	if repo.ReportStatus && repo.GetProvider() != GitProviderGitHub {
+		return fmt.Errorf("spec.repository.reportStatus is only supported for %s repositories", GitProviderGitHub)
+	}
+
+	switch provider := repo.GetProvider(); provider {
+	case GitProviderGit:
+		if repo.URL == "" {
+			return errors.New("spec.repository.url is required for Git repositories")
+		}
+		if !repo.IsSSH() && !strings.HasPrefix(repo.URL, "https://") {
+			return fmt.Errorf("spec.repository.url %q must be an https:// or ssh:// url", repo.URL)
+		}
+		// ACR Tasks and Kaniko only clone over https
+		if repo.IsSSH() && n.GetBuildStrategy() != BuildStrategyBuildKit {
+			return fmt.Errorf("ssh repositories can only be built with the %s strategy", BuildStrategyBuildKit)
+		}
+	case GitProviderGitHub, GitProviderGitLab, GitProviderAzureDevOps, GitProviderBitbucket:
+		if repo.Owner == "" || repo.Name == "" {
+			return fmt.Errorf("spec.repository.owner and name are required for %s repositories", provider)
+		}
+		if provider == GitProviderAzureDevOps && strings.Count(repo.Owner, "/") != 1 {
+			return fmt.Errorf("spec.repository.owner %q must be the organization/project of the repository", repo.Owner)
+		}
+
+		switch {
+		case repo.URL == "":
+		case provider == GitProviderGitHub || provider == GitProviderBitbucket:
+			return fmt.Errorf("spec.repository.url is only supported for GitLab, AzureDevOps and Git repositories")
+		case !strings.HasPrefix(repo.URL, "https://"):
+			return fmt.Errorf("spec.repository.url %q must be an https:// url", repo.URL)
+		}
+	default:
+		return fmt.Errorf("unsupported repository provider %q", provider)
+	}
+
+	return nil
+}
+
+// validateAcr checks that spec.acr.id is the resource ID of a container registry
+func (n *Application) validateAcr() error {
+	if n.Spec.Acr == nil {
+		return nil
+	}
+
+	resource, err := az.ParseResourceID(n.Spec.Acr.Id)
+	if err != nil {
+		return fmt.Errorf("spec.acr.id %q is not a valid resource ID: %w", n.Spec.Acr.Id, err)
+	}
+	if resource.SubscriptionID == "" || resource.ResourceGroupName == "" {
+		return fmt.Errorf("spec.acr.id %q must include a subscription and resource group", n.Spec.Acr.Id)
+	}
+	if !strings.EqualFold(resource.ResourceType.String(), acrResourceType) {
+		return fmt.Errorf("spec.acr.id %q must reference a %s resource, not %s", n.Spec.Acr.Id, acrResourceType, resource.ResourceType)
+	}
+
+	return nil
+}
+
+// validateResources checks that every quantity set in spec.resourceDefinition parses
+func (n *Application) validateResources() error {
+	if n.Spec.Resources == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, q := range []struct{ field, value string }{
+		{"cpuLimit", n.Spec.Resources.CPULimit},
+		{"memLimit", n.Spec.Resources.MEMLimit},
+		{"cpuReq", n.Spec.Resources.CPUReq},
+		{"memReq", n.Spec.Resources.MEMReq},
+	} {
+		if q.value == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(q.value); err != nil {
+			errs = append(errs, fmt.Errorf("spec.resourceDefinition.%s %q is not a valid quantity: %w", q.field, q.value, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateAppPort checks that spec.appPort is a port number
+func (n *Application) validateAppPort() error {
+	port, err := strconv.Atoi(n.Spec.AppPort)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("spec.appPort %q must be a number between 1 and 65535", n.Spec.AppPort)
+	}
+
+	return nil
+}
+
+// validateFiles checks that every file mount references one ConfigMap or Secret and mounts it at a distinct
+// absolute path
+func (n *Application) validateFiles() error {
+	var errs []error
+	mountPaths := map[string]struct{}{}
+	for i, f := range n.Spec.Files {
+		if (f.ConfigMapName == "") == (f.SecretName == "") {
+			errs = append(errs, fmt.Errorf("spec.files[%d] must set exactly one of configMapName or secretName", i))
+		}
+		if !path.IsAbs(f.MountPath) {
+			errs = append(errs, fmt.Errorf("spec.files[%d].mountPath %q must be an absolute path", i, f.MountPath))
+		}
+		if _, ok := mountPaths[path.Clean(f.MountPath)]; ok {
+			errs = append(errs, fmt.Errorf("spec.files[%d].mountPath %q is already mounted", i, f.MountPath))
+		}
+		mountPaths[path.Clean(f.MountPath)] = struct{}{}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateKeyVault checks that spec.keyVault names a vault, identity and at least one object
+func (n *Application) validateKeyVault() error {
+	kv := n.Spec.KeyVault
+	if kv == nil {
+		return nil
+	}
+
+	var errs []error
+	if kv.VaultName == "" {
+		errs = append(errs, errors.New("spec.keyVault.vaultName is required"))
+	}
+	if kv.TenantID == "" {
+		errs = append(errs, errors.New("spec.keyVault.tenantId is required"))
+	}
+	if kv.ClientID == "" {
+		errs = append(errs, errors.New("spec.keyVault.clientId is required"))
+	}
+	if len(kv.Objects) == 0 {
+		errs = append(errs, errors.New("spec.keyVault.objects must not be empty"))
+	}
+	for i, o := range kv.Objects {
+		if o.Name == "" {
+			errs = append(errs, fmt.Errorf("spec.keyVault.objects[%d].name is required", i))
+		}
+	}
+	if kv.MountPath != "" && !path.IsAbs(kv.MountPath) {
+		errs = append(errs, fmt.Errorf("spec.keyVault.mountPath %q must be an absolute path", kv.MountPath))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateExpose checks that spec.expose sets what its type needs
+func (n *Application) validateExpose() error {
+	e := n.Spec.Expose
+	if e == nil {
+		return nil
+	}
+
+	var errs []error
+	switch e.Type {
+	case ExposeTypeClusterIP, ExposeTypeLoadBalancer:
+		if e.Host != "" || e.Path != "" || e.Gateway != nil {
+			errs = append(errs, fmt.Errorf("spec.expose host, path and gateway can't be set for type %s", e.Type))
+		}
+	case ExposeTypeIngress:
+		if e.Gateway != nil {
+			errs = append(errs, errors.New("spec.expose.gateway can only be set for type Gateway"))
+		}
+	case ExposeTypeGateway:
+		if e.Gateway == nil || e.Gateway.Name == "" {
+			errs = append(errs, errors.New("spec.expose.gateway.name is required for type Gateway"))
+		}
+		if e.IngressClassName != "" || e.TLSSecretName != "" {
+			errs = append(errs, errors.New("spec.expose ingressClassName and tlsSecretName can only be set for type Ingress"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported spec.expose.type %q", e.Type))
+	}
+
+	if e.Port < 0 || e.Port > 65535 {
+		errs = append(errs, fmt.Errorf("spec.expose.port %d must be between 1 and 65535", e.Port))
+	}
+	if e.Path != "" && !strings.HasPrefix(e.Path, "/") {
+		errs = append(errs, fmt.Errorf("spec.expose.path %q must start with /", e.Path))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateMesh checks that the egress, retry and traffic settings of spec.mesh are complete
+func (n *Application) validateMesh() error {
+	m := n.Spec.Mesh
+	if m == nil {
+		return nil
+	}
+
+	var errs []error
+	names := map[string]struct{}{}
+	for i, e := range m.Egress {
+		if e.Name == "" {
+			errs = append(errs, fmt.Errorf("spec.mesh.egress[%d].name is required", i))
+		}
+		if _, ok := names[e.Name]; ok {
+			errs = append(errs, fmt.Errorf("spec.mesh.egress[%d].name %q is not unique", i, e.Name))
+		}
+		names[e.Name] = struct{}{}
+		if len(e.Hosts) == 0 && len(e.IPAddresses) == 0 {
+			errs = append(errs, fmt.Errorf("spec.mesh.egress[%d] must set hosts or ipAddresses", i))
+		}
+		if e.Port < 1 || e.Port > 65535 {
+			errs = append(errs, fmt.Errorf("spec.mesh.egress[%d].port %d must be between 1 and 65535", i, e.Port))
+		}
+	}
+
+	if m.Retry != nil && len(m.Retry.Destinations) == 0 {
+		errs = append(errs, errors.New("spec.mesh.retry.destinations must not be empty"))
+	}
+	if m.RateLimit != nil && m.RateLimit.Requests < 1 {
+		errs = append(errs, errors.New("spec.mesh.rateLimit.requests must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateRollout checks that the steps of spec.rollout increase and that its traffic routing is available
+func (n *Application) validateRollout() error {
+	r := n.Spec.Rollout
+	if r == nil {
+		return nil
+	}
+
+	var errs []error
+	if r.Strategy == RolloutStrategyBlueGreen && len(r.Steps) > 0 {
+		errs = append(errs, errors.New("spec.rollout.steps can't be set for strategy BlueGreen"))
+	}
+	for i, step := range r.Steps {
+		if step < 1 || step > 99 {
+			errs = append(errs, fmt.Errorf("spec.rollout.steps[%d] %d must be between 1 and 99", i, step))
+		}
+		if i > 0 && step <= r.Steps[i-1] {
+			errs = append(errs, fmt.Errorf("spec.rollout.steps[%d] %d must be greater than the previous step", i, step))
+		}
+	}
+
+	switch r.TrafficRouting {
+	case TrafficRoutingSMI:
+		if n.Spec.Mesh == nil {
+			errs = append(errs, errors.New("spec.rollout.trafficRouting SMI requires spec.mesh"))
+		}
+	case TrafficRoutingGateway:
+		if n.Spec.Expose == nil || n.Spec.Expose.Type != ExposeTypeGateway {
+			errs = append(errs, errors.New("spec.rollout.trafficRouting Gateway requires spec.expose.type Gateway"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateRevisions checks that at least the deployed revision is kept and that spec.rollbackTo is a revision number
+func (n *Application) validateRevisions() error {
+	var errs []error
+	if limit := n.Spec.RevisionHistoryLimit; limit != nil && *limit < 1 {
+		errs = append(errs, fmt.Errorf("spec.revisionHistoryLimit %d must be at least 1", *limit))
+	}
+	if r := n.Spec.RollbackTo; r != nil && *r < 1 {
+		errs = append(errs, fmt.Errorf("spec.rollbackTo %d must be a revision number", *r))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateDelivery checks that the gitops delivery mode has a GitHub config repository and no rollout, traffic is
+// only shifted between images the controller applies
+func (n *Application) validateDelivery() error {
+	switch mode := n.GetDeliveryMode(); mode {
+	case DeliveryModeApply:
+		return nil
+	case DeliveryModeGitOps:
+	default:
+		return fmt.Errorf("unsupported spec.delivery.mode %q", mode)
+	}
+
+	gitops := n.Spec.Delivery.GitOps
+	if gitops == nil {
+		return errors.New("spec.delivery.gitops is required for the gitops delivery mode")
+	}
+
+	var errs []error
+	repo := gitops.Repository
+	if repo.GetProvider() != GitProviderGitHub {
+		errs = append(errs, fmt.Errorf("spec.delivery.gitops.repository must be a %s repository", GitProviderGitHub))
+	}
+	if repo.Owner == "" || repo.Name == "" || repo.BranchName == "" {
+		errs = append(errs, errors.New("spec.delivery.gitops.repository owner, name and branchName are required"))
+	}
+	if p := path.Clean(gitops.Path); path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		errs = append(errs, fmt.Errorf("spec.delivery.gitops.path %q must be relative to the repository root", gitops.Path))
+	}
+	if n.Spec.Rollout != nil {
+		errs = append(errs, errors.New("spec.rollout can't be set for the gitops delivery mode"))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,325 @@
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testAcrId = "/subscriptions/26ad903f-2330-429d-8389-864ac35c4350/resourceGroups/test/providers/Microsoft.ContainerRegistry/registries/test"
+
+func testApp(name, namespace string) *Application {
+	return &Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: ApplicationSpec{
+			ApplicationName: name,
+			Namespace:       "target",
+			Repository:      &Repository{Owner: "bfoley13", Name: "go_echo", BranchName: "main"},
+			DockerConfig:    &DockerConfig{ImageName: "go_echo"},
+			Acr:             &Acr{Id: testAcrId},
+			AppPort:         "1323",
+		},
+	}
+}
+
+func TestDefault(t *testing.T) {
+	app := testApp("test-app", "default")
+	app.Spec.ApplicationName = "echo"
+	app.Spec.DockerConfig = nil
+	app.Default()
+	assert.Equal(t, &DockerConfig{Dockerfile: "Dockerfile", BuildContext: ".", ImageName: "test-app", ImageTag: "latest"}, app.Spec.DockerConfig, "named after the Application")
+
+	app.Spec.DockerConfig = &DockerConfig{Dockerfile: "build/Dockerfile", BuildContext: "src", ImageName: "go_echo", ImageTag: "v1"}
+	app.Default()
+	assert.Equal(t, &DockerConfig{Dockerfile: "build/Dockerfile", BuildContext: "src", ImageName: "go_echo", ImageTag: "v1"}, app.Spec.DockerConfig)
+
+	prebuilt := &Application{Spec: ApplicationSpec{Image: "test.azurecr.io/go_echo:v1"}}
+	prebuilt.Default()
+	assert.Nil(t, prebuilt.Spec.DockerConfig)
+}
+
+func TestValidate(t *testing.T) {
+	app := testApp("test-app", "default")
+	app.Default()
+	assert.Nil(t, app.Validate())
+
+	t.Run("acr id", func(t *testing.T) {
+		for _, id := range []string{
+			"brfoletest",
+			"/subscriptions/26ad903f-2330-429d-8389-864ac35c4350",
+			"/subscriptions/26ad903f-2330-429d-8389-864ac35c4350/resourceGroups/test/providers/Microsoft.Storage/storageAccounts/test",
+		} {
+			app := testApp("test-app", "default")
+			app.Spec.Acr.Id = id
+			assert.ErrorContains(t, app.Validate(), "spec.acr.id", id)
+		}
+	})
+
+	t.Run("repository", func(t *testing.T) {
+		app := testApp("test-app", "default")
+		app.Spec.Repository = &Repository{Provider: GitProviderAzureDevOps, Owner: "org/project", Name: "repo", BranchName: "main"}
+		assert.Nil(t, app.Validate())
+
+		app.Spec.Repository.Owner = "org"
+		assert.ErrorContains(t, app.Validate(), "organization/project")
+
+		app.Spec.Repository = &Repository{Provider: GitProviderGitLab, Owner: "group", Name: "repo", BranchName: "main", URL: "http://gitlab.example.com"}
+		assert.ErrorContains(t, app.Validate(), "must be an https:// url")
+
+		app.Spec.Repository = &Repository{Provider: GitProviderBitbucket, Owner: "workspace", BranchName: "main"}
+		assert.ErrorContains(t, app.Validate(), "owner and name are required")
+
+		app.Spec.Repository = &Repository{Provider: GitProviderGitLab, Owner: "group", Name: "repo", BranchName: "main", ReportStatus: true}
+		assert.ErrorContains(t, app.Validate(), "reportStatus is only supported for GitHub repositories")
+
+		app.Spec.Repository.ReportStatus = false
+		app.Spec.DockerConfig.TagStrategy = TagStrategySemver
+		assert.ErrorContains(t, app.Validate(), "tagStrategy semver isn't supported for GitLab repositories")
+		app.Spec.DockerConfig.TagStrategy = ""
+
+		app.Spec.Repository = &Repository{Provider: GitProviderGit, URL: "https://git.example.com/repo.git", BranchName: "main"}
+		assert.Nil(t, app.Validate())
+
+		app.Spec.Repository.URL = ""
+		assert.ErrorContains(t, app.Validate(), "spec.repository.url is required")
+
+		app.Spec.Repository.URL = "git@git.example.com:team/repo.git"
+		assert.True(t, app.Spec.Repository.IsSSH())
+		assert.ErrorContains(t, app.Validate(), "ssh repositories can only be built with the buildkit strategy")
+
+		app.Spec.Acr = nil
+		app.Spec.Build = &BuildConfig{Strategy: BuildStrategyBuildKit, Registry: "registry.example.com"}
+		assert.Nil(t, app.Validate())
+	})
+
+	t.Run("resource quantities", func(t *testing.T) {
+		app := testApp("test-app", "default")
+		app.Spec.Resources = &ResourceDefinition{CPULimit: "1", MEMLimit: "1Gi"}
+		assert.Nil(t, app.Validate())
+
+		app.Spec.Resources.MEMReq = "lots"
+		assert.ErrorContains(t, app.Validate(), "spec.resourceDefinition.memReq")
+	})
+
+	t.Run("files", func(t *testing.T) {
+		app := testApp("test-app", "default")
+		app.Spec.Files = []FileMount{{MountPath: "/etc/settings", ConfigMapName: "settings"}}
+		assert.Nil(t, app.Validate())
+
+		app.Spec.Files = append(app.Spec.Files, FileMount{MountPath: "/etc/settings/", SecretName: "creds"})
+		assert.ErrorContains(t, app.Validate(), "already mounted")
+
+		app.Spec.Files = []FileMount{{MountPath: "settings", ConfigMapName: "settings", SecretName: "creds"}}
+		assert.ErrorContains(t, app.Validate(), "exactly one of configMapName or secretName")
+		assert.ErrorContains(t, app.Validate(), "absolute path")
+	})
+
+	t.Run("key vault", func(t *testing.T) {
+		app := testApp("test-app", "default")
+		app.Spec.KeyVault = &KeyVault{VaultName: "vault", TenantID: "tenant", ClientID: "client", Objects: []KeyVaultObject{{Name: "password"}}}
+		app.Default()
+		assert.Nil(t, app.Validate())
+		assert.Equal(t, KeyVaultObject{Name: "password", Type: KeyVaultObjectTypeSecret, Key: "password"}, app.Spec.KeyVault.Objects[0])
+
+		app.Spec.KeyVault.Objects = nil
+		app.Spec.KeyVault.TenantID = ""
+		assert.ErrorContains(t, app.Validate(), "spec.keyVault.tenantId")
+		assert.ErrorContains(t, app.Validate(), "spec.keyVault.objects")
+	})
+
+	t.Run("expose", func(t *testing.T) {
+		app := testApp("test-app", "default")
+		app.Spec.Expose = &Expose{Type: ExposeTypeIngress, Host: "echo.example.com"}
+		app.Default()
+		assert.Nil(t, app.Validate())
+		assert.Equal(t, "/", app.Spec.Expose.Path)
+
+		app.Spec.Expose = &Expose{Type: ExposeTypeGateway}
+		assert.ErrorContains(t, app.Validate(), "spec.expose.gateway.name")
+
+		app.Spec.Expose = &Expose{Type: ExposeTypeClusterIP, Host: "echo.example.com"}
+		assert.ErrorContains(t, app.Validate(), "can't be set for type ClusterIP")
+	})
+
+	t.Run("mesh", func(t *testing.T) {
+		app := testApp("test-app", "default")
+		app.Spec.Mesh = &Mesh{
+			Egress: []MeshEgress{{Name: "github", Hosts: []string{"api.github.com"}, Port: 443, Protocol: MeshProtocolHTTPS}},
+			Retry:  &MeshRetry{Destinations: []MeshServiceReference{{Name: "backend", Namespace: "default"}}},
+		}
+		app.Default()
+		assert.Nil(t, app.Validate())
+		assert.Equal(t, DefaultMeshName, app.Spec.Mesh.MeshName)
+		assert.Equal(t, &MeshServiceReference{Name: DefaultIngressSourceName, Namespace: DefaultIngressSourceNamespace}, app.Spec.Mesh.IngressSource)
+		assert.Equal(t, DefaultRetryOn, app.Spec.Mesh.Retry.RetryOn)
+		assert.Equal(t, DefaultRetryPerTryTimeout, app.Spec.Mesh.Retry.PerTryTimeout.Duration)
+
+		app.Spec.Mesh.Egress = append(app.Spec.Mesh.Egress, MeshEgress{Name: "github", Protocol: MeshProtocolTCP})
+		app.Spec.Mesh.Retry.Destinations = nil
+		assert.ErrorContains(t, app.Validate(), `spec.mesh.egress[1].name "github" is not unique`)
+		assert.ErrorContains(t, app.Validate(), "spec.mesh.egress[1] must set hosts or ipAddresses")
+		assert.ErrorContains(t, app.Validate(), "spec.mesh.egress[1].port")
+		assert.ErrorContains(t, app.Validate(), "spec.mesh.retry.destinations")
+	})
+
+	t.Run("rollout", func(t *testing.T) {
+		app := testApp("test-app", "default")
+		app.Spec.Mesh = &Mesh{}
+		app.Spec.Rollout = &Rollout{Strategy: RolloutStrategyCanary}
+		app.Default()
+		assert.Nil(t, app.Validate())
+		assert.Equal(t, TrafficRoutingSMI, app.Spec.Rollout.TrafficRouting)
+		assert.Equal(t, DefaultCanarySteps, app.Spec.Rollout.Steps)
+
+		app.Spec.Rollout.Steps = []int32{50, 20, 100}
+		assert.ErrorContains(t, app.Validate(), "spec.rollout.steps[1] 20 must be greater than the previous step")
+		assert.ErrorContains(t, app.Validate(), "spec.rollout.steps[2] 100 must be between 1 and 99")
+
+		app = testApp("test-app", "default")
+		app.Spec.Expose = &Expose{Type: ExposeTypeGateway, Gateway: &GatewayReference{Name: "gateway"}}
+		app.Spec.Rollout = &Rollout{Strategy: RolloutStrategyBlueGreen}
+		app.Default()
+		assert.Nil(t, app.Validate())
+		assert.Equal(t, TrafficRoutingGateway, app.Spec.Rollout.TrafficRouting)
+		assert.Empty(t, app.Spec.Rollout.Steps)
+
+		app.Spec.Rollout.TrafficRouting = TrafficRoutingSMI
+		assert.ErrorContains(t, app.Validate(), "requires spec.mesh")
+	})
+
+	t.Run("revisions", func(t *testing.T) {
+		app := testApp("test-app", "default")
+		app.Default()
+		assert.Nil(t, app.Validate())
+		assert.Equal(t, DefaultRevisionHistoryLimit, *app.Spec.RevisionHistoryLimit)
+
+		limit, revision := int32(0), int64(0)
+		app.Spec.RevisionHistoryLimit = &limit
+		app.Spec.RollbackTo = &revision
+		assert.ErrorContains(t, app.Validate(), "spec.revisionHistoryLimit")
+		assert.ErrorContains(t, app.Validate(), "spec.rollbackTo")
+	})
+
+	t.Run("delivery", func(t *testing.T) {
+		app := testApp("test-app", "default")
+		app.Spec.Delivery = &Delivery{Mode: DeliveryModeGitOps, GitOps: &GitOpsDelivery{
+			Repository: Repository{Owner: "bfoley13", Name: "config", BranchName: "main"},
+		}}
+		app.Default()
+		assert.Nil(t, app.Validate())
+		assert.Equal(t, app.Name, app.Spec.Delivery.GitOps.Path)
+
+		app.Spec.Delivery.GitOps.Path = "../apps"
+		app.Spec.Delivery.GitOps.Repository.Provider = GitProviderGitLab
+		app.Spec.Rollout = &Rollout{Strategy: RolloutStrategyBlueGreen}
+		assert.ErrorContains(t, app.Validate(), "spec.delivery.gitops.path")
+		assert.ErrorContains(t, app.Validate(), "must be a GitHub repository")
+		assert.ErrorContains(t, app.Validate(), "spec.rollout can't be set")
+
+		app.Spec.Delivery.GitOps = nil
+		assert.ErrorContains(t, app.Validate(), "spec.delivery.gitops is required")
+	})
+
+	t.Run("app port", func(t *testing.T) {
+		for _, port := range []string{"", "http", "0", "70000"} {
+			app := testApp("test-app", "default")
+			app.Spec.AppPort = port
+			assert.ErrorContains(t, app.Validate(), "spec.appPort", port)
+		}
+	})
+}
+
+func TestCollides(t *testing.T) {
+	ctx := context.Background()
+	s := runtime.NewScheme()
+	assert.Nil(t, AddToScheme(s))
+
+	existing := testApp("test-app", "default")
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(existing).Build()
+
+	collides, _, err := existing.Collides(ctx, cl)
+	assert.Nil(t, err)
+	assert.False(t, collides, "an Application doesn't collide with itself")
+
+	// the objects are named after the Application, not spec.appName
+	other := testApp("test-app", "other")
+	other.Spec.ApplicationName = "other-app"
+	collides, message, err := other.Collides(ctx, cl)
+	assert.Nil(t, err)
+	assert.True(t, collides)
+	assert.Contains(t, message, "default/test-app")
+
+	unrelated := testApp("unrelated-app", "default")
+	unrelated.Spec.ApplicationName = existing.Spec.ApplicationName
+	collides, _, err = unrelated.Collides(ctx, cl)
+	assert.Nil(t, err)
+	assert.False(t, collides)
+
+	other.Spec.Namespace = "other-target"
+	collides, _, err = other.Collides(ctx, cl)
+	assert.Nil(t, err)
+	assert.False(t, collides)
+
+	t.Run("mesh", func(t *testing.T) {
+		meshed := testApp("meshed-app", "default")
+		meshed.Spec.Mesh = &Mesh{}
+		assert.Nil(t, cl.Create(ctx, meshed))
+		defer cl.Delete(ctx, meshed)
+
+		sameMesh := testApp("same-mesh-app", "default")
+		sameMesh.Spec.Mesh = &Mesh{MeshName: DefaultMeshName}
+		collides, _, err := sameMesh.Collides(ctx, cl)
+		assert.Nil(t, err)
+		assert.False(t, collides)
+
+		otherMesh := testApp("other-mesh-app", "default")
+		otherMesh.Spec.Mesh = &Mesh{MeshName: "other"}
+		collides, message, err := otherMesh.Collides(ctx, cl)
+		assert.Nil(t, err)
+		assert.True(t, collides)
+		assert.Contains(t, message, `enrolls namespace "target" in mesh "osm"`)
+
+		v := &applicationValidator{client: cl}
+		updated := meshed.DeepCopy()
+		updated.Spec.Mesh.MeshName = "other"
+		_, err = v.ValidateUpdate(ctx, meshed, updated)
+		assert.Nil(t, err, "the only Application in the namespace can change its mesh")
+
+		otherMesh.Spec.Namespace = "other-target"
+		collides, _, err = otherMesh.Collides(ctx, cl)
+		assert.Nil(t, err)
+		assert.False(t, collides)
+	})
+
+	t.Run("webhook", func(t *testing.T) {
+		v := &applicationValidator{client: cl}
+
+		_, err := v.ValidateCreate(ctx, testApp("test-app", "other"))
+		assert.NotNil(t, err)
+
+		_, err = v.ValidateUpdate(ctx, existing, existing)
+		assert.Nil(t, err)
+
+		renamed := testApp("test-app", "other")
+		assert.Nil(t, cl.Create(ctx, renamed))
+		updated := renamed.DeepCopy()
+		updated.Spec.ApplicationName = "renamed-app"
+		_, err = v.ValidateUpdate(ctx, renamed, updated)
+		assert.Nil(t, err, "an Application that collided before can change its appName")
+		assert.Nil(t, cl.Delete(ctx, renamed))
+
+		deleted := testApp("test-app", "default")
+		deleted.Spec.AppPort = "http"
+		deleted.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+		_, err = v.ValidateUpdate(ctx, existing, deleted)
+		assert.Nil(t, err, "deleted Applications can always be updated")
+	})
+}
@@ -2,39 +2,184 @@ package azure
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerregistry/armcontainerregistry"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/devhub/armdevhub"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 )
 
-func NewDevHubClient(ctx context.Context) (*armdevhub.DeveloperHubServiceClient, error) {
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+// Config configures the Azure clients used by the controller
+type Config struct {
+	// SubscriptionID is used for clients of resources whose ID doesn't name a subscription
+	SubscriptionID string
+	// Cloud is the Azure cloud to authenticate with and manage resources in, defaults to the public cloud
+	Cloud cloud.Configuration
+	// Credential authenticates requests, defaults to a DefaultAzureCredential for Cloud
+	Credential azcore.TokenCredential
+	// Transport sends requests, defaults to the Azure SDK http client
+	Transport policy.Transporter
+}
+
+// ConfigFromEnv reads the controller's Azure configuration from AZURE_SUBSCRIPTION_ID and AZURE_CLOUD
+func ConfigFromEnv() (Config, error) {
+	c, err := CloudFromName(os.Getenv("AZURE_CLOUD"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	return Config{
+		SubscriptionID: os.Getenv("AZURE_SUBSCRIPTION_ID"),
+		Cloud:          c,
+	}, nil
+}
+
+// CloudFromName returns the configuration of a cloud by its name as used by the Azure CLI, the public cloud if
+// name is empty
+func CloudFromName(name string) (cloud.Configuration, error) {
+	switch strings.ToLower(name) {
+	case "", "azurecloud", "azurepubliccloud":
+		return cloud.AzurePublic, nil
+	case "azurechinacloud":
+		return cloud.AzureChina, nil
+	case "azureusgovernment", "azureusgovernmentcloud":
+		return cloud.AzureGovernment, nil
+	default:
+		return cloud.Configuration{}, fmt.Errorf("unknown azure cloud %q", name)
+	}
+}
+
+// ClientFactory creates Azure clients sharing one credential. Clients are cached per subscription and safe for
+// concurrent use, so a single ClientFactory is created when the manager starts.
+type ClientFactory struct {
+	subscriptionID string
+	cred           azcore.TokenCredential
+	options        *arm.ClientOptions
+
+	mu     sync.Mutex
+	acr    map[string]*armcontainerregistry.ClientFactory
+	devHub map[string]*armdevhub.ClientFactory
+}
+
+func NewClientFactory(cfg Config) (*ClientFactory, error) {
+	if cfg.Cloud.ActiveDirectoryAuthorityHost == "" {
+		cfg.Cloud = cloud.AzurePublic
+	}
+
+	clientOptions := azcore.ClientOptions{
+		Cloud:     cfg.Cloud,
+		Transport: cfg.Transport,
+	}
+
+	cred := cfg.Credential
+	if cred == nil {
+		var err error
+		cred, err = azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: clientOptions})
+		if err != nil {
+			return nil, fmt.Errorf("creating azure credential: %w", err)
+		}
+	}
+
+	return &ClientFactory{
+		subscriptionID: cfg.SubscriptionID,
+		cred:           cred,
+		options:        &arm.ClientOptions{ClientOptions: clientOptions},
+		acr:            map[string]*armcontainerregistry.ClientFactory{},
+		devHub:         map[string]*armdevhub.ClientFactory{},
+	}, nil
+}
+
+// subscription returns subscriptionID, or the configured subscription if it is empty
+func (f *ClientFactory) subscription(subscriptionID string) (string, error) {
+	if subscriptionID != "" {
+		return subscriptionID, nil
+	}
+	if f.subscriptionID != "" {
+		return f.subscriptionID, nil
+	}
+
+	return "", errors.New("no azure subscription in resource id or controller config")
+}
+
+func (f *ClientFactory) acrFactory(subscriptionID string) (*armcontainerregistry.ClientFactory, error) {
+	subscriptionID, err := f.subscription(subscriptionID)
 	if err != nil {
 		return nil, err
 	}
 
-	factory, err := armdevhub.NewClientFactory("26ad903f-2330-429d-8389-864ac35c4350", cred, nil)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if factory, ok := f.acr[subscriptionID]; ok {
+		return factory, nil
+	}
+
+	factory, err := armcontainerregistry.NewClientFactory(subscriptionID, f.cred, f.options)
+	if err != nil {
+		return nil, fmt.Errorf("creating acr client factory: %w", err)
+	}
+	f.acr[subscriptionID] = factory
+
+	return factory, nil
+}
+
+// NewACRClient returns a registries client for subscriptionID, the configured subscription if it is empty
+func (f *ClientFactory) NewACRClient(ctx context.Context, subscriptionID string) (*armcontainerregistry.RegistriesClient, error) {
+	factory, err := f.acrFactory(subscriptionID)
 	if err != nil {
 		return nil, err
 	}
 
-	return factory.NewDeveloperHubServiceClient(), nil
+	return factory.NewRegistriesClient(), nil
 }
 
-func NewACRClient(ctx context.Context) (*armcontainerregistry.RegistriesClient, error) {
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+// NewACRRunsClient returns a runs client for subscriptionID, the configured subscription if it is empty
+func (f *ClientFactory) NewACRRunsClient(ctx context.Context, subscriptionID string) (*armcontainerregistry.RunsClient, error) {
+	factory, err := f.acrFactory(subscriptionID)
 	if err != nil {
 		return nil, err
 	}
 
-	factory, err := armcontainerregistry.NewClientFactory("26ad903f-2330-429d-8389-864ac35c4350", cred, nil)
+	return factory.NewRunsClient(), nil
+}
+
+// NewACRTasksClient returns a tasks client for subscriptionID, the configured subscription if it is empty
+func (f *ClientFactory) NewACRTasksClient(ctx context.Context, subscriptionID string) (*armcontainerregistry.TasksClient, error) {
+	factory, err := f.acrFactory(subscriptionID)
 	if err != nil {
 		return nil, err
 	}
 
-	return factory.NewRegistriesClient(), nil
+	return factory.NewTasksClient(), nil
+}
+
+// NewDevHubClient returns a developer hub client for subscriptionID, the configured subscription if it is empty
+func (f *ClientFactory) NewDevHubClient(ctx context.Context, subscriptionID string) (*armdevhub.DeveloperHubServiceClient, error) {
+	subscriptionID, err := f.subscription(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	factory, ok := f.devHub[subscriptionID]
+	if !ok {
+		factory, err = armdevhub.NewClientFactory(subscriptionID, f.cred, f.options)
+		if err != nil {
+			return nil, fmt.Errorf("creating devhub client factory: %w", err)
+		}
+		f.devHub[subscriptionID] = factory
+	}
+
+	return factory.NewDeveloperHubServiceClient(), nil
 }
 
 func NewBlobClientFromUrl(ctx context.Context, url string) (*blockblob.Client, error) {
@@ -45,12 +190,3 @@ func NewBlobClientFromUrl(ctx context.Context, url string) (*blockblob.Client, e
 
 	return blockblob.NewClientWithNoCredential(url, nil)
 }
-
-func NewACRRunsClient(ctx context.Context, subscription string) (*armcontainerregistry.RunsClient, error) {
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
-	if err != nil {
-		return nil, err
-	}
-
-	return armcontainerregistry.NewRunsClient(subscription, cred, nil)
-}
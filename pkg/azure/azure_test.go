@@ -0,0 +1,95 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/fake"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestFactory returns a ClientFactory sending requests to a fake ARM server serving handler
+func newTestFactory(t *testing.T, subscriptionID string, handler http.HandlerFunc) *ClientFactory {
+	// ARM clients only send bearer tokens over TLS
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+
+	factory, err := NewClientFactory(Config{
+		SubscriptionID: subscriptionID,
+		Cloud: cloud.Configuration{
+			ActiveDirectoryAuthorityHost: server.URL,
+			Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+				cloud.ResourceManager: {Endpoint: server.URL, Audience: server.URL},
+			},
+		},
+		Credential: &fake.TokenCredential{},
+		Transport:  server.Client(),
+	})
+	assert.Nil(t, err)
+
+	return factory
+}
+
+func TestCloudFromName(t *testing.T) {
+	for name, expected := range map[string]cloud.Configuration{
+		"":                  cloud.AzurePublic,
+		"AzureCloud":        cloud.AzurePublic,
+		"AzureChinaCloud":   cloud.AzureChina,
+		"AzureUSGovernment": cloud.AzureGovernment,
+	} {
+		c, err := CloudFromName(name)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.ActiveDirectoryAuthorityHost, c.ActiveDirectoryAuthorityHost, name)
+	}
+
+	_, err := CloudFromName("AzureGermanCloud")
+	assert.NotNil(t, err)
+}
+
+func TestClientFactory(t *testing.T) {
+	ctx := context.Background()
+	var paths []string
+	factory := newTestFactory(t, "config-sub", func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		assert.Equal(t, "Bearer ", r.Header.Get("Authorization")[:7])
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"properties":{"runId":"run-1","status":"Running"}}`))
+	})
+
+	t.Run("subscription from resource id", func(t *testing.T) {
+		client, err := factory.NewACRRunsClient(ctx, "resource-sub")
+		assert.Nil(t, err)
+
+		resp, err := client.Get(ctx, "rg", "registry", "run-1", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "run-1", *resp.Properties.RunID)
+		assert.Equal(t, "/subscriptions/resource-sub/resourceGroups/rg/providers/Microsoft.ContainerRegistry/registries/registry/runs/run-1", paths[len(paths)-1])
+	})
+
+	t.Run("subscription from config", func(t *testing.T) {
+		client, err := factory.NewACRRunsClient(ctx, "")
+		assert.Nil(t, err)
+
+		_, err = client.Get(ctx, "rg", "registry", "run-1", nil)
+		assert.Nil(t, err)
+		assert.Contains(t, paths[len(paths)-1], "/subscriptions/config-sub/")
+	})
+
+	t.Run("clients are reused", func(t *testing.T) {
+		_, err := factory.NewACRClient(ctx, "resource-sub")
+		assert.Nil(t, err)
+		_, err = factory.NewDevHubClient(ctx, "resource-sub")
+		assert.Nil(t, err)
+		assert.Len(t, factory.acr, 2)
+		assert.Len(t, factory.devHub, 1)
+	})
+
+	t.Run("no subscription", func(t *testing.T) {
+		factory := newTestFactory(t, "", nil)
+		_, err := factory.NewACRClient(ctx, "")
+		assert.NotNil(t, err)
+	})
+}
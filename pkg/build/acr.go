@@ -0,0 +1,279 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerregistry/armcontainerregistry"
+	az "github.com/Azure/go-autorest/autorest/azure"
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"github.com/bfoley13/appcontroller/pkg/azure"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ACRBuilder builds images with ACR Tasks in the registry referenced by the Application
+type ACRBuilder struct {
+	clients *azure.ClientFactory
+	// httpClient downloads run logs from the storage they are kept in
+	httpClient *http.Client
+}
+
+func NewACRBuilder(clients *azure.ClientFactory) *ACRBuilder {
+	return &ACRBuilder{
+		clients:    clients,
+		httpClient: http.DefaultClient,
+	}
+}
+
+func (b *ACRBuilder) Start(ctx context.Context, app *appv1alpha1.Application, src Source) (string, error) {
+	if src.IsSSH() {
+		return "", fmt.Errorf("acr tasks can't clone ssh repositories")
+	}
+
+	if src.Token == "" {
+		return ScheduleAcrBuild(ctx, b.clients, *app, src.URL, src.Tag)
+	}
+
+	// ACR keeps the context access token of a task out of its runs and logs, a token in the source url isn't
+	return ScheduleAcrTaskBuild(ctx, b.clients, *app, src.URL, src.Token, src.Tag)
+}
+
+func (b *ACRBuilder) Status(ctx context.Context, app *appv1alpha1.Application, id string) (*Result, error) {
+	runResp, err := GetAcrRun(ctx, b.clients, *app, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if runResp.Properties == nil || runResp.Properties.Status == nil {
+		return &Result{State: appv1alpha1.RunStateRunning}, nil
+	}
+
+	status := *runResp.Properties.Status
+	result := &Result{State: acrRunState(status)}
+	switch result.State {
+	case appv1alpha1.RunStateFailed:
+		result.Message = fmt.Sprintf("acr run %s: %s", status, fromPtr(runResp.Properties.RunErrorMessage))
+	case appv1alpha1.RunStateSucceeded:
+		if len(runResp.Properties.OutputImages) == 0 {
+			result.State = appv1alpha1.RunStateFailed
+			result.Message = "acr run succeeded without an output image"
+			break
+		}
+
+		outputImage := runResp.Properties.OutputImages[0]
+		result.Image = fmt.Sprintf("%s/%s:%s", fromPtr(outputImage.Registry), fromPtr(outputImage.Repository), fromPtr(outputImage.Tag))
+		result.Digest = fromPtr(outputImage.Digest)
+	}
+
+	return result, nil
+}
+
+// Logs downloads the log of the ACR run with the given ID through its SAS url
+func (b *ACRBuilder) Logs(ctx context.Context, app *appv1alpha1.Application, id string, limit int) ([]byte, error) {
+	logURL, err := GetAcrRunLogURL(ctx, b.clients, *app, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return downloadLog(ctx, b.httpClient, logURL, limit)
+}
+
+// ScheduleAcrBuild schedules an ACR run building the image for app from the git build context at sourceLocation,
+// pushed with tag, and returns the run ID. The run is not waited on, track it with GetAcrRun.
+func ScheduleAcrBuild(ctx context.Context, clients *azure.ClientFactory, app appv1alpha1.Application, sourceLocation, tag string) (string, error) {
+	lgr := log.FromContext(ctx)
+	resource, err := az.ParseResourceID(app.Spec.Acr.Id)
+	if err != nil {
+		lgr.Error(err, "unable to parse resource id")
+		return "", err
+	}
+
+	acrClient, err := clients.NewACRClient(ctx, resource.SubscriptionID)
+	if err != nil {
+		lgr.Error(err, "unable to create acr client")
+		return "", err
+	}
+
+	runID, err := scheduleRun(ctx, acrClient, resource, &armcontainerregistry.DockerBuildRequest{
+		DockerFilePath: toPtr(app.Spec.DockerConfig.Dockerfile),
+		ImageNames:     []*string{toPtr(acrImageName(app, tag))},
+		Type:           toPtr("DockerBuildRequest"),
+		IsPushEnabled:  toPtr(true),
+		SourceLocation: toPtr(sourceLocation),
+		Platform:       acrPlatform(),
+	})
+	if err != nil {
+		lgr.Error(err, "unable schedule docker build run")
+		return "", err
+	}
+
+	return runID, nil
+}
+
+// ScheduleAcrTaskBuild schedules a run of the ACR task of app building the image from the git build context at
+// contextPath cloned with token, pushed with tag, and returns the run ID. The task is updated with the context and
+// token of every build.
+func ScheduleAcrTaskBuild(ctx context.Context, clients *azure.ClientFactory, app appv1alpha1.Application, contextPath, token, tag string) (string, error) {
+	resource, err := az.ParseResourceID(app.Spec.Acr.Id)
+	if err != nil {
+		return "", fmt.Errorf("parsing acr resource id: %w", err)
+	}
+
+	acrClient, err := clients.NewACRClient(ctx, resource.SubscriptionID)
+	if err != nil {
+		return "", fmt.Errorf("creating acr client: %w", err)
+	}
+	tasksClient, err := clients.NewACRTasksClient(ctx, resource.SubscriptionID)
+	if err != nil {
+		return "", fmt.Errorf("creating acr tasks client: %w", err)
+	}
+
+	// tasks are created in the location of their registry
+	registry, err := acrClient.Get(ctx, resource.ResourceGroup, resource.ResourceName, nil)
+	if err != nil {
+		return "", fmt.Errorf("getting registry %s: %w", resource.ResourceName, err)
+	}
+
+	name := acrTaskName(&app)
+	poller, err := tasksClient.BeginCreate(ctx, resource.ResourceGroup, resource.ResourceName, name, armcontainerregistry.Task{
+		Location: registry.Location,
+		Properties: &armcontainerregistry.TaskProperties{
+			Platform: acrPlatform(),
+			Status:   toPtr(armcontainerregistry.TaskStatusEnabled),
+			Step: &armcontainerregistry.DockerBuildStep{
+				Type:               toPtr(armcontainerregistry.StepTypeDocker),
+				DockerFilePath:     toPtr(app.Spec.DockerConfig.Dockerfile),
+				ImageNames:         []*string{toPtr(acrImageName(app, tag))},
+				IsPushEnabled:      toPtr(true),
+				ContextPath:        toPtr(contextPath),
+				ContextAccessToken: toPtr(token),
+			},
+		},
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("updating acr task %s: %w", name, err)
+	}
+	task, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("updating acr task %s: %w", name, err)
+	}
+
+	runID, err := scheduleRun(ctx, acrClient, resource, &armcontainerregistry.TaskRunRequest{
+		TaskID: task.ID,
+		Type:   toPtr("TaskRunRequest"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("scheduling run of acr task %s: %w", name, err)
+	}
+
+	return runID, nil
+}
+
+// scheduleRun schedules request in the registry resource and returns the run ID. Scheduling completes once the run
+// is queued, this doesn't wait for the build itself.
+func scheduleRun(ctx context.Context, acrClient *armcontainerregistry.RegistriesClient, resource az.Resource, request armcontainerregistry.RunRequestClassification) (string, error) {
+	poller, err := acrClient.BeginScheduleRun(ctx, resource.ResourceGroup, resource.ResourceName, request, nil)
+	if err != nil {
+		return "", err
+	}
+
+	acrRes, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("polling for acr run to be scheduled: %w", err)
+	}
+
+	if acrRes.Properties == nil || acrRes.Properties.RunID == nil {
+		return "", fmt.Errorf("scheduled acr run has no run id")
+	}
+
+	return *acrRes.Properties.RunID, nil
+}
+
+// acrTaskName returns the name of the ACR task building app, task names are unique per registry and at most 50
+// characters of letters, digits, dashes and underscores
+func acrTaskName(app *appv1alpha1.Application) string {
+	h := sha256.Sum256([]byte(app.Namespace + "/" + app.Name))
+	name := strings.ReplaceAll(app.Name, ".", "-")
+	if len(name) > 30 {
+		name = name[:30]
+	}
+
+	return fmt.Sprintf("%s-%s", strings.TrimSuffix(name, "-"), hex.EncodeToString(h[:])[:10])
+}
+
+func acrImageName(app appv1alpha1.Application, tag string) string {
+	return fmt.Sprintf("%s:%s", app.Spec.DockerConfig.ImageName, tag)
+}
+
+func acrPlatform() *armcontainerregistry.PlatformProperties {
+	return &armcontainerregistry.PlatformProperties{
+		OS:           toPtr(armcontainerregistry.OSLinux),
+		Architecture: toPtr(armcontainerregistry.ArchitectureAmd64),
+	}
+}
+
+// GetAcrRun returns the current state of the ACR run with the given ID in the registry of app
+func GetAcrRun(ctx context.Context, clients *azure.ClientFactory, app appv1alpha1.Application, runID string) (*armcontainerregistry.RunsClientGetResponse, error) {
+	lgr := log.FromContext(ctx)
+	resource, err := az.ParseResourceID(app.Spec.Acr.Id)
+	if err != nil {
+		lgr.Error(err, "unable to parse resource id")
+		return nil, err
+	}
+
+	runsClient, err := clients.NewACRRunsClient(ctx, resource.SubscriptionID)
+	if err != nil {
+		lgr.Error(err, "failed to get acr runs client")
+		return nil, err
+	}
+
+	runsResp, err := runsClient.Get(ctx, resource.ResourceGroup, resource.ResourceName, runID, nil)
+	if err != nil {
+		lgr.Error(err, "failed to get acr run")
+		return nil, err
+	}
+
+	return &runsResp, nil
+}
+
+// GetAcrRunLogURL returns the SAS url the log of the ACR run with the given ID in the registry of app is read from
+func GetAcrRunLogURL(ctx context.Context, clients *azure.ClientFactory, app appv1alpha1.Application, runID string) (string, error) {
+	resource, err := az.ParseResourceID(app.Spec.Acr.Id)
+	if err != nil {
+		return "", fmt.Errorf("parsing acr resource id: %w", err)
+	}
+
+	runsClient, err := clients.NewACRRunsClient(ctx, resource.SubscriptionID)
+	if err != nil {
+		return "", fmt.Errorf("creating acr runs client: %w", err)
+	}
+
+	resp, err := runsClient.GetLogSasURL(ctx, resource.ResourceGroup, resource.ResourceName, runID, nil)
+	if err != nil {
+		return "", fmt.Errorf("getting log url of acr run %s: %w", runID, err)
+	}
+	if resp.LogLink == nil {
+		return "", fmt.Errorf("acr run %s has no log", runID)
+	}
+
+	return *resp.LogLink, nil
+}
+
+// acrRunState maps an ACR run status to the state recorded in the Application status
+func acrRunState(status armcontainerregistry.RunStatus) appv1alpha1.RunState {
+	switch status {
+	case armcontainerregistry.RunStatusSucceeded:
+		return appv1alpha1.RunStateSucceeded
+	case armcontainerregistry.RunStatusFailed,
+		armcontainerregistry.RunStatusCanceled,
+		armcontainerregistry.RunStatusError,
+		armcontainerregistry.RunStatusTimeout:
+		return appv1alpha1.RunStateFailed
+	default:
+		return appv1alpha1.RunStateRunning
+	}
+}
@@ -0,0 +1,128 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"github.com/bfoley13/appcontroller/pkg/git"
+)
+
+// Builder builds the image of an Application and pushes it to a registry. Builds are asynchronous, Start returns
+// an ID that is passed to Status until the build is no longer running. The ID is stored in the Application status
+// so it must be enough to find the build again after a controller restart.
+type Builder interface {
+	// Start starts building the image of app from src
+	Start(ctx context.Context, app *appv1alpha1.Application, src Source) (string, error)
+	// Status returns the current state of the build with the given ID
+	Status(ctx context.Context, app *appv1alpha1.Application, id string) (*Result, error)
+}
+
+// LogReader is implemented by the builders that keep the log of a build
+type LogReader interface {
+	// Logs returns the log of the build with the given ID, only its last limit bytes when it is longer
+	Logs(ctx context.Context, app *appv1alpha1.Application, id string, limit int) ([]byte, error)
+}
+
+// Source is the revision of a repository a build clones
+type Source struct {
+	// URL is the build context in the form understood by ACR and BuildKit, the clone url of the repository without
+	// credentials followed by #<commit>:<context path>
+	URL string
+	// Branch is the branch Commit is on, Kaniko clones it before checking out the commit
+	Branch string
+	Commit string
+	// Tag is the tag the built image is pushed with
+	Tag string
+	Credentials
+}
+
+// Credentials authenticate cloning a private repository, they are empty for public ones
+type Credentials struct {
+	// Username and Token authenticate https clones
+	Username string
+	Token    string
+	// SSHPrivateKey authenticates ssh clones
+	SSHPrivateKey []byte
+}
+
+// IsSSH returns whether the source is cloned over ssh
+func (s Source) IsSSH() bool {
+	return git.IsSSHURL(s.URL)
+}
+
+// Result is the state of a build
+type Result struct {
+	State appv1alpha1.RunState
+	// Image is the reference of the pushed image, set once the build succeeded
+	Image  string
+	Digest string
+	// Message explains why the build failed
+	Message string
+}
+
+// tailWriter keeps the last limit bytes written to it
+type tailWriter struct {
+	limit int
+	buf   []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) >= w.limit {
+		w.buf = append(w.buf[:0], p[len(p)-w.limit:]...)
+		return n, nil
+	}
+
+	w.buf = append(w.buf, p...)
+	if over := len(w.buf) - w.limit; over > 0 {
+		copy(w.buf, w.buf[over:])
+		w.buf = w.buf[:w.limit]
+	}
+	return n, nil
+}
+
+// downloadLog returns the last limit bytes of the log served at logURL
+func downloadLog(ctx context.Context, httpClient *http.Client, logURL string, limit int) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, logURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating log request: %w", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("downloading log: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading log: unexpected status %s", resp.Status)
+	}
+
+	tail := &tailWriter{limit: limit}
+	if _, err := io.Copy(tail, resp.Body); err != nil {
+		return nil, fmt.Errorf("reading log: %w", err)
+	}
+	return tail.buf, nil
+}
+
+// cloneURL returns the url of the repository of src without the commit and context path
+func cloneURL(src Source) string {
+	clone, _, _ := strings.Cut(src.URL, "#")
+	return clone
+}
+
+func toPtr[T any](s T) *T {
+	v := s
+	return &v
+}
+
+func fromPtr[T any](p *T) T {
+	var v T
+	if p != nil {
+		v = *p
+	}
+	return v
+}
@@ -0,0 +1,366 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	azfake "github.com/Azure/azure-sdk-for-go/sdk/azcore/fake"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerregistry/armcontainerregistry"
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"github.com/bfoley13/appcontroller/pkg/azure"
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRunACR(t *testing.T) {
+	t.Run("RunACR", func(t *testing.T) {
+
+		app := appv1alpha1.Application{
+			Spec: appv1alpha1.ApplicationSpec{
+				Repository: &appv1alpha1.Repository{
+					Owner:      "bfoley13",
+					Name:       "go_echo",
+					BranchName: "main",
+				},
+				DockerConfig: &appv1alpha1.DockerConfig{
+					Dockerfile:   "Dockerfile",
+					BuildContext: ".",
+					ImageName:    "go_echo",
+					ImageTag:     "latest",
+				},
+				Acr: &appv1alpha1.Acr{
+					Id: "/subscriptions/26ad903f-2330-429d-8389-864ac35c4350/resourceGroups/bfoley-test/providers/Microsoft.ContainerRegistry/registries/appcontrollertest",
+				},
+			},
+		}
+
+		clients, err := azure.NewClientFactory(azure.Config{})
+		assert.Nil(t, err)
+
+		runID, err := ScheduleAcrBuild(context.Background(), clients, app, "https://github.com/bfoley13/go_echo.git#main:.", "latest")
+		assert.Nil(t, err)
+
+		_, err = GetAcrRun(context.Background(), clients, app, runID)
+		assert.Nil(t, err)
+	})
+}
+
+func TestAcrRunState(t *testing.T) {
+	assert.Equal(t, appv1alpha1.RunStateRunning, acrRunState(armcontainerregistry.RunStatusQueued))
+	assert.Equal(t, appv1alpha1.RunStateRunning, acrRunState(armcontainerregistry.RunStatusStarted))
+	assert.Equal(t, appv1alpha1.RunStateRunning, acrRunState(armcontainerregistry.RunStatusRunning))
+	assert.Equal(t, appv1alpha1.RunStateSucceeded, acrRunState(armcontainerregistry.RunStatusSucceeded))
+	assert.Equal(t, appv1alpha1.RunStateFailed, acrRunState(armcontainerregistry.RunStatusFailed))
+	assert.Equal(t, appv1alpha1.RunStateFailed, acrRunState(armcontainerregistry.RunStatusCanceled))
+	assert.Equal(t, appv1alpha1.RunStateFailed, acrRunState(armcontainerregistry.RunStatusError))
+	assert.Equal(t, appv1alpha1.RunStateFailed, acrRunState(armcontainerregistry.RunStatusTimeout))
+}
+
+// testSource is the source of testJobApp at commit 3a0f86fb
+func testSource() Source {
+	return Source{URL: "https://github.com/bfoley13/go_echo.git#3a0f86fb:.", Branch: "main", Commit: "3a0f86fb", Tag: "latest"}
+}
+
+func testJobApp() *appv1alpha1.Application {
+	return &appv1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "go-echo", Namespace: "default", UID: "uid"},
+		Spec: appv1alpha1.ApplicationSpec{
+			Repository: &appv1alpha1.Repository{
+				Owner:      "bfoley13",
+				Name:       "go_echo",
+				BranchName: "main",
+			},
+			DockerConfig: &appv1alpha1.DockerConfig{
+				Dockerfile:   "Dockerfile",
+				BuildContext: ".",
+				ImageName:    "go_echo",
+				ImageTag:     "latest",
+			},
+			Build: &appv1alpha1.BuildConfig{
+				Strategy:       appv1alpha1.BuildStrategyKaniko,
+				Registry:       "registry.example.com",
+				PushSecretName: "push-secret",
+			},
+		},
+	}
+}
+
+func TestJobBuilder(t *testing.T) {
+	ctx := context.Background()
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(appv1alpha1.AddToScheme(s))
+
+	for _, tool := range []JobTool{JobToolKaniko, JobToolBuildKit} {
+		t.Run(string(tool), func(t *testing.T) {
+			cl := fake.NewClientBuilder().WithScheme(s).Build()
+			b := NewJobBuilder(tool, cl, cl)
+			app := testJobApp()
+
+			id, err := b.Start(ctx, app, testSource())
+			assert.Nil(t, err)
+
+			job := &batchv1.Job{}
+			assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: id}, job))
+			assert.Equal(t, "registry.example.com/go_echo:latest", job.Annotations[imageAnnotation])
+			assert.Equal(t, app.Name, job.OwnerReferences[0].Name)
+			assert.Equal(t, "push-secret", job.Spec.Template.Spec.Volumes[0].Secret.SecretName)
+			container := job.Spec.Template.Spec.Containers[0]
+			assert.Equal(t, terminationLog, container.TerminationMessagePath)
+			assert.Len(t, container.VolumeMounts, 1)
+
+			// starting the same build again reuses the job
+			again, err := b.Start(ctx, app, testSource())
+			assert.Nil(t, err)
+			assert.Equal(t, id, again)
+			// every tag is pushed by its own job
+			tagged := testSource()
+			tagged.Tag = "3a0f86fb"
+			other, err := b.Start(ctx, app, tagged)
+			assert.Nil(t, err)
+			assert.NotEqual(t, id, other)
+
+			result, err := b.Status(ctx, app, id)
+			assert.Nil(t, err)
+			assert.Equal(t, appv1alpha1.RunStateRunning, result.State)
+
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+			assert.Nil(t, cl.Status().Update(ctx, job))
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: id + "-abcde", Namespace: "default", Labels: map[string]string{"job-name": id}},
+				Status: corev1.PodStatus{
+					Phase: corev1.PodSucceeded,
+					ContainerStatuses: []corev1.ContainerStatus{{
+						Name:  buildContainerName,
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: "sha256:abc\n"}},
+					}},
+				},
+			}
+			assert.Nil(t, cl.Create(ctx, pod))
+
+			// the image comes from the job, not the current spec
+			app.Spec.DockerConfig.ImageName = "other"
+			result, err = b.Status(ctx, app, id)
+			assert.Nil(t, err)
+			assert.Equal(t, appv1alpha1.RunStateSucceeded, result.State)
+			assert.Equal(t, "registry.example.com/go_echo:latest", result.Image)
+			assert.Equal(t, "sha256:abc", result.Digest)
+		})
+	}
+
+	t.Run("clones private repositories with the credentials", func(t *testing.T) {
+		src := testSource()
+		src.Credentials = Credentials{Username: "x-access-token", Token: "ghs_token"}
+		for _, tool := range []JobTool{JobToolKaniko, JobToolBuildKit} {
+			cl := fake.NewClientBuilder().WithScheme(s).Build()
+			id, err := NewJobBuilder(tool, cl, cl).Start(ctx, testJobApp(), src)
+			assert.Nil(t, err)
+
+			job := &batchv1.Job{}
+			assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: id}, job))
+			container := job.Spec.Template.Spec.Containers[0]
+			assert.NotContains(t, strings.Join(container.Args, " "), "ghs_token")
+			if tool == JobToolKaniko {
+				assert.Equal(t, "--context=git://github.com/bfoley13/go_echo.git#refs/heads/main#3a0f86fb", container.Args[0])
+			}
+			for _, env := range container.Env {
+				assert.NotContains(t, env.Value, "ghs_token")
+			}
+
+			secret := &corev1.Secret{}
+			assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: credentialsSecretName(id)}, secret))
+			assert.Equal(t, "ghs_token", string(secret.Data[gitTokenKey]))
+			assert.Equal(t, "basic eC1hY2Nlc3MtdG9rZW46Z2hzX3Rva2Vu", string(secret.Data[gitAuthHeaderKey]))
+			assert.Equal(t, id, secret.OwnerReferences[0].Name)
+		}
+
+		cl := fake.NewClientBuilder().WithScheme(s).Build()
+		ssh := Source{URL: "git@git.example.com:team/go_echo.git#3a0f86fb:.", Branch: "main", Commit: "3a0f86fb", Credentials: Credentials{SSHPrivateKey: []byte("key")}}
+		_, err := NewJobBuilder(JobToolKaniko, cl, cl).Start(ctx, testJobApp(), ssh)
+		assert.NotNil(t, err)
+
+		id, err := NewJobBuilder(JobToolBuildKit, cl, cl).Start(ctx, testJobApp(), ssh)
+		assert.Nil(t, err)
+		job := &batchv1.Job{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: id}, job))
+		assert.Contains(t, job.Spec.Template.Spec.Containers[0].Args, "default=/home/user/.ssh/"+gitSSHKey)
+		assert.Equal(t, credentialsSecretName(id), job.Spec.Template.Spec.Volumes[1].Secret.SecretName)
+	})
+
+	t.Run("requires a registry", func(t *testing.T) {
+		cl := fake.NewClientBuilder().WithScheme(s).Build()
+		app := testJobApp()
+		app.Spec.Build.Registry = ""
+
+		_, err := NewJobBuilder(JobToolKaniko, cl, cl).Start(ctx, app, testSource())
+		assert.NotNil(t, err)
+	})
+
+	t.Run("missing job fails the build", func(t *testing.T) {
+		cl := fake.NewClientBuilder().WithScheme(s).Build()
+		result, err := NewJobBuilder(JobToolKaniko, cl, cl).Status(ctx, testJobApp(), "missing")
+		assert.Nil(t, err)
+		assert.Equal(t, appv1alpha1.RunStateFailed, result.State)
+	})
+}
+
+func TestCloneURL(t *testing.T) {
+	assert.Equal(t, "https://github.com/bfoley13/go_echo.git", cloneURL(testSource()))
+}
+
+func TestACRBuilder(t *testing.T) {
+	ctx := context.Background()
+	app := testJobApp()
+	app.Spec.Acr = &appv1alpha1.Acr{Id: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerRegistry/registries/acr"}
+	registry := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerRegistry/registries/acr"
+
+	var requests []string
+	bodies := map[string]string{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		path := strings.TrimPrefix(r.URL.Path, registry)
+		requests = append(requests, r.Method+" "+path)
+		bodies[path] = string(body)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && path == "":
+			fmt.Fprint(w, `{"location":"eastus"}`)
+		case r.Method == http.MethodPut && strings.HasPrefix(path, "/tasks/"):
+			fmt.Fprintf(w, `{"id":"%s","location":"eastus","properties":{"provisioningState":"Succeeded"}}`, registry+path)
+		case r.Method == http.MethodPost && path == "/scheduleRun":
+			fmt.Fprint(w, `{"properties":{"runId":"ca1","provisioningState":"Succeeded"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	clients, err := azure.NewClientFactory(azure.Config{
+		Cloud: cloud.Configuration{
+			ActiveDirectoryAuthorityHost: server.URL,
+			Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+				cloud.ResourceManager: {Endpoint: server.URL, Audience: server.URL},
+			},
+		},
+		Credential: &azfake.TokenCredential{},
+		Transport:  server.Client(),
+	})
+	assert.Nil(t, err)
+	b := NewACRBuilder(clients)
+
+	t.Run("public repositories are built from the source url", func(t *testing.T) {
+		requests = nil
+		id, err := b.Start(ctx, app, testSource())
+		assert.Nil(t, err)
+		assert.Equal(t, "ca1", id)
+		assert.Equal(t, []string{"POST /scheduleRun"}, requests)
+		assert.Contains(t, bodies["/scheduleRun"], `"sourceLocation":"https://github.com/bfoley13/go_echo.git#3a0f86fb:."`)
+	})
+
+	t.Run("private repositories keep the token out of the run", func(t *testing.T) {
+		requests = nil
+		src := testSource()
+		src.Credentials = Credentials{Username: "x-access-token", Token: "ghs_token"}
+		id, err := b.Start(ctx, app, src)
+		assert.Nil(t, err)
+		assert.Equal(t, "ca1", id)
+
+		task := "/tasks/" + acrTaskName(app)
+		assert.Equal(t, []string{"GET ", "PUT " + task, "POST /scheduleRun"}, requests)
+		assert.Contains(t, bodies[task], `"contextAccessToken":"ghs_token"`)
+		assert.Contains(t, bodies[task], `"contextPath":"https://github.com/bfoley13/go_echo.git#3a0f86fb:."`)
+		assert.Contains(t, bodies["/scheduleRun"], `"taskId":"`+registry+task+`"`)
+		assert.NotContains(t, bodies["/scheduleRun"], "ghs_token")
+	})
+}
+
+func TestACRTaskName(t *testing.T) {
+	app := testJobApp()
+	assert.Regexp(t, `^go-echo-[0-9a-f]{10}$`, acrTaskName(app))
+
+	other := app.DeepCopy()
+	other.Namespace = "staging"
+	assert.NotEqual(t, acrTaskName(app), acrTaskName(other))
+
+	other.Name = "a-very-long.application-name-that-exceeds-the-limit"
+	assert.Regexp(t, `^[a-zA-Z0-9_-]{5,50}$`, acrTaskName(other))
+}
+
+func TestDownloadLog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/run-1.log" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		for i := 1; i <= 1000; i++ {
+			fmt.Fprintf(w, "step %d\n", i)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	log, err := downloadLog(context.Background(), server.Client(), server.URL+"/run-1.log", 19)
+	assert.Nil(t, err)
+	assert.Equal(t, "step 999\nstep 1000\n", string(log))
+
+	log, err = downloadLog(context.Background(), server.Client(), server.URL+"/run-1.log", 1<<20)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(log), "step 1\n"))
+
+	_, err = downloadLog(context.Background(), server.Client(), server.URL+"/expired.log", 18)
+	assert.ErrorContains(t, err, "403")
+}
+
+func TestTailWriter(t *testing.T) {
+	tail := &tailWriter{limit: 4}
+	for _, p := range []string{"ab", "cde", "", "f", "ghijkl"} {
+		n, err := tail.Write([]byte(p))
+		assert.Nil(t, err)
+		assert.Equal(t, len(p), n)
+	}
+	assert.Equal(t, "ijkl", string(tail.buf))
+
+	tail = &tailWriter{limit: 4}
+	tail.Write([]byte("abc"))
+	tail.Write([]byte("de"))
+	assert.Equal(t, "bcde", string(tail.buf))
+}
+
+func TestParseDigest(t *testing.T) {
+	assert.Equal(t, "sha256:abc", parseDigest("sha256:abc"))
+	assert.Equal(t, "sha256:abc", parseDigest(`{"containerimage.digest":"sha256:abc","image.name":"registry.example.com/go_echo:latest"}`))
+	assert.Equal(t, "", parseDigest(`{not json`))
+}
+
+func TestFakeBuilder(t *testing.T) {
+	ctx := context.Background()
+	b := NewFakeBuilder()
+	app := testJobApp()
+
+	id, err := b.Start(ctx, app, Source{Commit: "commit-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"commit-1"}, b.Commits)
+
+	result, err := b.Status(ctx, app, id)
+	assert.Nil(t, err)
+	assert.Equal(t, appv1alpha1.RunStateRunning, result.State)
+
+	b.Succeed(id, "sha256:abc")
+	result, err = b.Status(ctx, app, id)
+	assert.Nil(t, err)
+	assert.Equal(t, appv1alpha1.RunStateSucceeded, result.State)
+	assert.Equal(t, "sha256:abc", result.Digest)
+}
@@ -0,0 +1,101 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+)
+
+// FakeBuilder is an in-memory Builder for tests. Started builds keep running until Succeed or Fail is called.
+type FakeBuilder struct {
+	mu     sync.Mutex
+	builds map[string]*Result
+	logs   map[string]string
+	// Commits records the commit of every started build in order
+	Commits []string
+	// Tokens records the repository token of every started build in order
+	Tokens []string
+	// StartErr is returned by Start when set
+	StartErr error
+}
+
+func NewFakeBuilder() *FakeBuilder {
+	return &FakeBuilder{
+		builds: map[string]*Result{},
+		logs:   map[string]string{},
+	}
+}
+
+func (f *FakeBuilder) Start(_ context.Context, app *appv1alpha1.Application, src Source) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.StartErr != nil {
+		return "", f.StartErr
+	}
+
+	f.Commits = append(f.Commits, src.Commit)
+	f.Tokens = append(f.Tokens, src.Token)
+	id := fmt.Sprintf("fake-%d", len(f.Commits))
+	f.builds[id] = &Result{
+		State: appv1alpha1.RunStateRunning,
+		Image: fmt.Sprintf("fake.registry.io/%s:%s", app.Spec.DockerConfig.ImageName, src.Tag),
+	}
+
+	return id, nil
+}
+
+func (f *FakeBuilder) Status(_ context.Context, _ *appv1alpha1.Application, id string) (*Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result, ok := f.builds[id]
+	if !ok {
+		return nil, fmt.Errorf("build %s not found", id)
+	}
+
+	r := *result
+	return &r, nil
+}
+
+// Succeed finishes the build with the given ID, pushing an image with digest
+func (f *FakeBuilder) Succeed(id, digest string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.builds[id].State = appv1alpha1.RunStateSucceeded
+	f.builds[id].Digest = digest
+}
+
+// Fail fails the build with the given ID
+func (f *FakeBuilder) Fail(id, message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.builds[id].State = appv1alpha1.RunStateFailed
+	f.builds[id].Message = message
+}
+
+// Log sets the log of the build with the given ID
+func (f *FakeBuilder) Log(id, log string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.logs[id] = log
+}
+
+func (f *FakeBuilder) Logs(_ context.Context, _ *appv1alpha1.Application, id string, limit int) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	log, ok := f.logs[id]
+	if !ok {
+		return nil, fmt.Errorf("build %s has no log", id)
+	}
+	if len(log) > limit {
+		log = log[len(log)-limit:]
+	}
+	return []byte(log), nil
+}
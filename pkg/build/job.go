@@ -0,0 +1,367 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	kanikoImage   = "gcr.io/kaniko-project/executor:v1.23.2"
+	buildKitImage = "moby/buildkit:v0.16.0-rootless"
+
+	// terminationLog is where the build container writes the pushed image digest, kubelet copies it into the
+	// terminated state of the container so it can be read without access to the pod logs
+	terminationLog = "/dev/termination-log"
+
+	// imageAnnotation records the image a build Job pushes, the Application spec may have changed since it started
+	imageAnnotation = "devx.kubernetes.azure.com/image"
+
+	buildContainerName = "build"
+	dockerConfigVolume = "docker-config"
+	// gitAuthHeaderEnv is the BuildKit secret holding the authorization header of https clones
+	gitAuthHeaderEnv = "GIT_AUTH_HEADER"
+	gitSSHVolume     = "git-ssh"
+
+	// keys of the repository credentials Secret of a build Job
+	gitTokenKey      = "token"
+	gitAuthHeaderKey = "authHeader"
+	gitSSHKey        = "sshPrivateKey"
+
+	// jobBackoffLimit is the number of times a failed build pod is retried
+	jobBackoffLimit = 1
+	// jobTTL keeps finished Jobs around long enough to read their result, they are also owned by the Application
+	jobTTL = 24 * 60 * 60
+)
+
+// JobTool is the build tool an in-cluster build Job runs
+type JobTool string
+
+const (
+	JobToolKaniko   JobTool = "kaniko"
+	JobToolBuildKit JobTool = "buildkit"
+)
+
+// JobBuilder builds images with a Kaniko or BuildKit Job in the Application's namespace and pushes them to the
+// registry from spec.build.registry, so it works with any OCI registry and clusters without ACR Tasks
+type JobBuilder struct {
+	tool   JobTool
+	client client.Client
+	// reader reads build Jobs and Pods from the api server, the manager doesn't cache Pods
+	reader client.Reader
+}
+
+func NewJobBuilder(tool JobTool, cl client.Client, reader client.Reader) *JobBuilder {
+	return &JobBuilder{
+		tool:   tool,
+		client: cl,
+		reader: reader,
+	}
+}
+
+// jobImage returns the reference the build of app pushes to with tag
+func jobImage(app *appv1alpha1.Application, tag string) string {
+	return fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(app.Spec.Build.Registry, "/"), app.Spec.DockerConfig.ImageName, tag)
+}
+
+// jobName returns a name unique to the build of app from src with its current build configuration
+func jobName(app *appv1alpha1.Application, src Source) string {
+	h := sha256.Sum256([]byte(strings.Join([]string{src.Commit, jobImage(app, src.Tag), app.Spec.DockerConfig.Dockerfile, app.Spec.DockerConfig.BuildContext}, "\x00")))
+	name := app.Name
+	if len(name) > 40 {
+		name = name[:40]
+	}
+
+	return fmt.Sprintf("%s-build-%s", strings.TrimSuffix(name, "-"), hex.EncodeToString(h[:])[:10])
+}
+
+// credentialsSecretName returns the name of the Secret holding the repository credentials of the build Job jobName
+func credentialsSecretName(jobName string) string {
+	return jobName + "-git"
+}
+
+// credentialsEnv returns a variable set to key of the credentials Secret of the build Job jobName, credentials
+// are read from a Secret so they don't show in the Job spec
+func credentialsEnv(name, jobName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: credentialsSecretName(jobName)},
+			Key:                  key,
+		}},
+	}
+}
+
+func (b *JobBuilder) buildContainer(app *appv1alpha1.Application, jobName string, src Source) corev1.Container {
+	container := corev1.Container{
+		Name:                     buildContainerName,
+		TerminationMessagePath:   terminationLog,
+		TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+	}
+
+	switch b.tool {
+	case JobToolBuildKit:
+		container.Image = buildKitImage
+		container.Command = []string{"buildctl-daemonless.sh"}
+		container.Args = []string{
+			"build",
+			"--frontend=dockerfile.v0",
+			"--opt", "context=" + src.URL,
+			"--opt", "filename=" + app.Spec.DockerConfig.Dockerfile,
+			"--output", fmt.Sprintf("type=image,name=%s,push=true", jobImage(app, src.Tag)),
+			"--metadata-file", terminationLog,
+		}
+		// rootless BuildKit can't create its own process sandbox in an unprivileged pod
+		container.Env = []corev1.EnvVar{{Name: "BUILDKITD_FLAGS", Value: "--oci-worker-no-process-sandbox"}}
+		container.SecurityContext = &corev1.SecurityContext{
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined},
+			RunAsUser:      toPtr(int64(1000)),
+			RunAsGroup:     toPtr(int64(1000)),
+		}
+		if app.Spec.Build.PushSecretName != "" {
+			container.Env = append(container.Env, corev1.EnvVar{Name: "DOCKER_CONFIG", Value: "/home/user/.docker"})
+			container.VolumeMounts = []corev1.VolumeMount{{Name: dockerConfigVolume, MountPath: "/home/user/.docker"}}
+		}
+		// the dockerfile frontend clones https contexts with the GIT_AUTH_HEADER secret and ssh contexts with
+		// the default ssh key
+		if src.Token != "" {
+			container.Env = append(container.Env, credentialsEnv(gitAuthHeaderEnv, jobName, gitAuthHeaderKey))
+			container.Args = append(container.Args, "--secret", fmt.Sprintf("id=%s,env=%s", gitAuthHeaderEnv, gitAuthHeaderEnv))
+		}
+		if len(src.SSHPrivateKey) > 0 {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: gitSSHVolume, MountPath: "/home/user/.ssh", ReadOnly: true})
+			container.Args = append(container.Args, "--ssh", "default=/home/user/.ssh/"+gitSSHKey)
+		}
+	default:
+		// kaniko replaces the git:// scheme with https:// and checks out the commit after cloning the branch
+		container.Image = kanikoImage
+		container.Args = []string{
+			fmt.Sprintf("--context=git://%s#refs/heads/%s#%s", strings.TrimPrefix(cloneURL(src), "https://"), src.Branch, src.Commit),
+			"--context-sub-path=" + app.Spec.DockerConfig.BuildContext,
+			"--dockerfile=" + app.Spec.DockerConfig.Dockerfile,
+			"--destination=" + jobImage(app, src.Tag),
+			"--digest-file=" + terminationLog,
+		}
+		if app.Spec.Build.PushSecretName != "" {
+			container.VolumeMounts = []corev1.VolumeMount{{Name: dockerConfigVolume, MountPath: "/kaniko/.docker"}}
+		}
+		if src.Token != "" {
+			container.Env = append(container.Env,
+				corev1.EnvVar{Name: "GIT_USERNAME", Value: src.Username},
+				credentialsEnv("GIT_PASSWORD", jobName, gitTokenKey),
+			)
+		}
+	}
+
+	return container
+}
+
+func (b *JobBuilder) podAnnotations() map[string]string {
+	if b.tool != JobToolBuildKit {
+		return nil
+	}
+
+	return map[string]string{"container.apparmor.security.beta.kubernetes.io/" + buildContainerName: "unconfined"}
+}
+
+func (b *JobBuilder) Start(ctx context.Context, app *appv1alpha1.Application, src Source) (string, error) {
+	lgr := log.FromContext(ctx)
+	if app.Spec.Build == nil || app.Spec.Build.Registry == "" {
+		return "", fmt.Errorf("spec.build.registry is required for %s builds", b.tool)
+	}
+	if src.IsSSH() && b.tool != JobToolBuildKit {
+		return "", fmt.Errorf("%s can't clone ssh repositories", b.tool)
+	}
+
+	name := jobName(app, src)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: app.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       app.Name,
+				"app.kubernetes.io/component":  "build",
+				"app.kubernetes.io/managed-by": "aks-app-controller",
+			},
+			Annotations: map[string]string{
+				imageAnnotation: jobImage(app, src.Tag),
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            toPtr(int32(jobBackoffLimit)),
+			TTLSecondsAfterFinished: toPtr(int32(jobTTL)),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: b.podAnnotations(),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers:    []corev1.Container{b.buildContainer(app, name, src)},
+				},
+			},
+		},
+	}
+
+	if app.Spec.Build.PushSecretName != "" {
+		job.Spec.Template.Spec.Volumes = []corev1.Volume{{
+			Name: dockerConfigVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: app.Spec.Build.PushSecretName,
+					Items:      []corev1.KeyToPath{{Key: corev1.DockerConfigJsonKey, Path: "config.json"}},
+				},
+			},
+		}}
+	}
+
+	if len(src.SSHPrivateKey) > 0 {
+		// the key is only readable by its owner, the build runs as user 1000
+		job.Spec.Template.Spec.SecurityContext = &corev1.PodSecurityContext{FSGroup: toPtr(int64(1000))}
+		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name: gitSSHVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  credentialsSecretName(name),
+					Items:       []corev1.KeyToPath{{Key: gitSSHKey, Path: gitSSHKey}},
+					DefaultMode: toPtr(int32(0o440)),
+				},
+			},
+		})
+	}
+
+	if err := controllerutil.SetControllerReference(app, job, b.client.Scheme()); err != nil {
+		return "", fmt.Errorf("setting build job owner: %w", err)
+	}
+
+	// the job name is derived from the build inputs, an existing job is the same build started before a restart
+	if err := b.client.Create(ctx, job); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			lgr.Error(err, "unable to create build job")
+			return "", fmt.Errorf("creating build job: %w", err)
+		}
+		if err := b.reader.Get(ctx, client.ObjectKeyFromObject(job), job); err != nil {
+			return "", fmt.Errorf("getting build job: %w", err)
+		}
+	}
+
+	if src.Token != "" || len(src.SSHPrivateKey) > 0 {
+		if err := b.createCredentialsSecret(ctx, job, src.Credentials); err != nil {
+			lgr.Error(err, "unable to create build credentials secret")
+			return "", err
+		}
+	}
+
+	return job.Name, nil
+}
+
+// createCredentialsSecret stores the repository credentials for the build pod of job. The Secret is owned by the
+// Job so it is deleted with it, the pod waits for it to exist.
+func (b *JobBuilder) createCredentialsSecret(ctx context.Context, job *batchv1.Job, creds Credentials) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      credentialsSecretName(job.Name),
+			Namespace: job.Namespace,
+			Labels:    job.Labels,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{},
+	}
+	if creds.Token != "" {
+		secret.Data[gitTokenKey] = []byte(creds.Token)
+		secret.Data[gitAuthHeaderKey] = []byte("basic " + base64.StdEncoding.EncodeToString([]byte(creds.Username+":"+creds.Token)))
+	}
+	if len(creds.SSHPrivateKey) > 0 {
+		secret.Data[gitSSHKey] = creds.SSHPrivateKey
+	}
+	if err := controllerutil.SetControllerReference(job, secret, b.client.Scheme()); err != nil {
+		return fmt.Errorf("setting build credentials secret owner: %w", err)
+	}
+
+	if err := b.client.Create(ctx, secret); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("creating build credentials secret: %w", err)
+	}
+
+	return nil
+}
+
+func (b *JobBuilder) Status(ctx context.Context, app *appv1alpha1.Application, id string) (*Result, error) {
+	job := &batchv1.Job{}
+	if err := b.reader.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: id}, job); err != nil {
+		if apierrors.IsNotFound(err) {
+			return &Result{State: appv1alpha1.RunStateFailed, Message: fmt.Sprintf("build job %s no longer exists", id)}, nil
+		}
+		return nil, fmt.Errorf("getting build job: %w", err)
+	}
+
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+
+		switch c.Type {
+		case batchv1.JobFailed:
+			return &Result{State: appv1alpha1.RunStateFailed, Message: fmt.Sprintf("build job %s failed: %s", id, c.Message)}, nil
+		case batchv1.JobComplete:
+			digest, err := b.jobDigest(ctx, job)
+			if err != nil {
+				return nil, err
+			}
+
+			return &Result{State: appv1alpha1.RunStateSucceeded, Image: job.Annotations[imageAnnotation], Digest: digest}, nil
+		}
+	}
+
+	return &Result{State: appv1alpha1.RunStateRunning}, nil
+}
+
+// jobDigest reads the pushed image digest from the termination message of the succeeded build pod of job
+func (b *JobBuilder) jobDigest(ctx context.Context, job *batchv1.Job) (string, error) {
+	var pods corev1.PodList
+	if err := b.reader.List(ctx, &pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return "", fmt.Errorf("listing build pods: %w", err)
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == buildContainerName && status.State.Terminated != nil {
+				return parseDigest(status.State.Terminated.Message), nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// parseDigest returns the digest written by Kaniko, a plain digest, or by BuildKit, a json metadata file
+func parseDigest(message string) string {
+	message = strings.TrimSpace(message)
+	if !strings.HasPrefix(message, "{") {
+		return message
+	}
+
+	metadata := struct {
+		Digest string `json:"containerimage.digest"`
+	}{}
+	if err := json.Unmarshal([]byte(message), &metadata); err != nil {
+		return ""
+	}
+
+	return metadata.Digest
+}
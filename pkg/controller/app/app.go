@@ -2,32 +2,37 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"time"
 
-	az "github.com/Azure/go-autorest/autorest/azure"
 	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
 	"github.com/bfoley13/appcontroller/pkg/azure"
+	"github.com/bfoley13/appcontroller/pkg/build"
+	"github.com/bfoley13/appcontroller/pkg/source"
 	"github.com/bfoley13/draft/pkg/template"
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	meta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/cli-runtime/pkg/resource"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
-
-	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerregistry/armcontainerregistry"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	secv1 "sigs.k8s.io/secrets-store-csi-driver/apis/v1"
 
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
@@ -66,25 +71,69 @@ func (t TemplateFiles) WriteFile(fileName string, fileBytes []byte) error {
 type appReconciler struct {
 	client client.Client
 	events record.EventRecorder
+	// providers read the repositories of Applications
+	providers *source.Providers
+	builders  map[appv1alpha1.BuildStrategy]build.Builder
+	// pushes are the commits source webhooks reported, nil when no webhook is served
+	pushes *source.Events
+	// tags caches the tags of commits for the semver tag strategy, nil lists them on every reconcile
+	tags *tagCache
 }
 
-func NewReconciler(mgr ctrl.Manager) error {
+func NewReconciler(mgr ctrl.Manager, azureClients *azure.ClientFactory, providers *source.Providers, pushes *source.Events) error {
 	reconciler := &appReconciler{
-		client: mgr.GetClient(),
-		events: mgr.GetEventRecorderFor("aks-app-controller"),
+		client:    mgr.GetClient(),
+		events:    mgr.GetEventRecorderFor("aks-app-controller"),
+		providers: providers,
+		builders: map[appv1alpha1.BuildStrategy]build.Builder{
+			appv1alpha1.BuildStrategyACR:      build.NewACRBuilder(azureClients),
+			appv1alpha1.BuildStrategyKaniko:   build.NewJobBuilder(build.JobToolKaniko, mgr.GetClient(), mgr.GetAPIReader()),
+			appv1alpha1.BuildStrategyBuildKit: build.NewJobBuilder(build.JobToolBuildKit, mgr.GetClient(), mgr.GetAPIReader()),
+		},
+		pushes: pushes,
+		tags:   newTagCache(time.Now),
+	}
+
+	// ConfigMap and Secret events look up the Applications referencing them in the index instead of listing all
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &appv1alpha1.Application{}, referencedObjectsIndex, referencedObjects); err != nil {
+		return fmt.Errorf("indexing referenced objects: %w", err)
 	}
 
-	if err := ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&appv1alpha1.Application{}).
-		Owns(&appsv1.Deployment{}).
-		Named("appcontroller").
-		Complete(reconciler); err != nil {
+		Owns(&batchv1.Job{}).
+		Watches(&appsv1.Deployment{}, handler.EnqueueRequestsFromMapFunc(enqueueRenderingApp)).
+		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(enqueueRenderingApp)).
+		Watches(&networkingv1.Ingress{}, handler.EnqueueRequestsFromMapFunc(enqueueRenderingApp)).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(reconciler.enqueueReferencingApps("ConfigMap"))).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(reconciler.enqueueReferencingApps("Secret"))).
+		Named("appcontroller")
+
+	// optional kinds are only watched on clusters that serve them, watching a missing kind fails
+	if hasKind(mgr, schema.GroupVersionKind{Group: secv1.GroupVersion.Group, Version: secv1.GroupVersion.Version, Kind: "SecretProviderClassPodStatus"}) {
+		builder = builder.Watches(&secv1.SecretProviderClassPodStatus{}, handler.EnqueueRequestsFromMapFunc(reconciler.enqueueMountingApps))
+	}
+	if hasKind(mgr, gatewayv1.SchemeGroupVersion.WithKind("HTTPRoute")) {
+		builder = builder.Watches(&gatewayv1.HTTPRoute{}, handler.EnqueueRequestsFromMapFunc(enqueueRenderingApp))
+	}
+
+	if pushes != nil {
+		builder = builder.WatchesRawSource(pushes.Source(), &handler.EnqueueRequestForObject{})
+	}
+
+	if err := builder.Complete(reconciler); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// hasKind returns whether the api server serves gvk
+func hasKind(mgr ctrl.Manager, gvk schema.GroupVersionKind) bool {
+	_, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	return err == nil
+}
+
 func (ar *appReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.Result, err error) {
 	lgr := log.FromContext(ctx, "appcontroller", req.NamespacedName)
 	ctx = log.IntoContext(ctx, lgr)
@@ -101,16 +150,69 @@ func (ar *appReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res c
 		return ctrl.Result{}, err
 	}
 
-	runResp, err := RunAcrBuild(ctx, app)
-	if err != nil {
-		lgr.Error(err, "unable to run acr build")
-		return ctrl.Result{}, err
+	if !app.DeletionTimestamp.IsZero() {
+		return ar.finalize(ctx, &app)
+	}
+
+	if controllerutil.AddFinalizer(&app, cleanupFinalizer) {
+		if err := ar.client.Update(ctx, &app); err != nil {
+			lgr.Error(err, "unable to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	defer func() {
+		setPhase(&app)
+		app.Status.ObservedGeneration = app.Generation
+		if statusErr := ar.client.Status().Update(ctx, &app); statusErr != nil {
+			lgr.Error(statusErr, "unable to update app status")
+			err = errors.Join(err, statusErr)
+		}
+	}()
+
+	// the webhooks default and validate the spec on admission, they are applied again for clusters running without them
+	app.Default()
+	if err := app.Validate(); err != nil {
+		lgr.Info("invalid app spec, waiting for spec to change", "reason", err.Error())
+		setCondition(&app, appv1alpha1.ConditionTypeSourceReady, metav1.ConditionFalse, reasonInvalidSpec, err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	if app.Spec.RollbackTo != nil {
+		lgr.Info("rolling back", "revision", *app.Spec.RollbackTo)
+		return ctrl.Result{}, ar.reconcileRollback(ctx, &app)
+	}
+
+	if app.Spec.Image != "" {
+		lgr.Info("deploying prebuilt image", "image", app.Spec.Image)
+		usePrebuiltImage(&app)
+	} else {
+		res, err = ar.reconcileBuild(ctx, &app)
+		if err != nil {
+			lgr.Error(err, "unable to reconcile build")
+			return ctrl.Result{}, err
+		}
+
+		if app.Status.Build == nil {
+			lgr.Info("no image has been built yet, waiting to deploy")
+			return res, nil
+		}
+		app.Status.Image = pinnedImage(app.Status.Build.Image, app.Status.Build.ImageDigest)
+		app.Status.ImageDigest = app.Status.Build.ImageDigest
+		app.Status.Commit = app.Status.Build.Commit
 	}
 
-	if runResp == nil || runResp.Properties == nil {
-		lgr.Info("run respoinse or priperties is nil")
+	rolloutRes, err := ar.reconcileRollout(ctx, &app)
+	if err != nil {
+		lgr.Error(err, "unable to reconcile rollout")
 		return ctrl.Result{}, err
 	}
+	if rolloutRes.RequeueAfter > 0 && (res.RequeueAfter == 0 || rolloutRes.RequeueAfter < res.RequeueAfter) {
+		res.RequeueAfter = rolloutRes.RequeueAfter
+	}
+
+	imageName, imageTag := splitImageReference(stableImage(&app))
+	resources := resourcesOrDefault(&app)
 
 	fileWriter := &TemplateFiles{
 		Files: map[string][]byte{},
@@ -125,12 +227,12 @@ func (ar *appReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res c
 	deploymentTemplate.Config.SetVariable("PORT", app.Spec.AppPort)
 	deploymentTemplate.Config.SetVariable("APPNAME", app.Name)
 	deploymentTemplate.Config.SetVariable("NAMESPACE", app.Spec.Namespace)
-	deploymentTemplate.Config.SetVariable("IMAGENAME", fmt.Sprintf("%s/%s", *runResp.Properties.OutputImages[0].Registry, *runResp.Properties.OutputImages[0].Repository))
-	deploymentTemplate.Config.SetVariable("IMAGETAG", *runResp.Properties.OutputImages[0].Tag)
-	deploymentTemplate.Config.SetVariable("CPULIMIT", app.Spec.Resources.CPULimit)
-	deploymentTemplate.Config.SetVariable("MEMLIMIT", app.Spec.Resources.MEMLimit)
-	deploymentTemplate.Config.SetVariable("CPUREQ", app.Spec.Resources.CPUReq)
-	deploymentTemplate.Config.SetVariable("MEMREQ", app.Spec.Resources.MEMReq)
+	deploymentTemplate.Config.SetVariable("IMAGENAME", imageName)
+	deploymentTemplate.Config.SetVariable("IMAGETAG", imageTag)
+	deploymentTemplate.Config.SetVariable("CPULIMIT", resources.CPULimit)
+	deploymentTemplate.Config.SetVariable("MEMLIMIT", resources.MEMLimit)
+	deploymentTemplate.Config.SetVariable("CPUREQ", resources.CPUReq)
+	deploymentTemplate.Config.SetVariable("MEMREQ", resources.MEMReq)
 
 	err = deploymentTemplate.CreateTemplates()
 	if err != nil {
@@ -138,214 +240,215 @@ func (ar *appReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res c
 		return ctrl.Result{}, err
 	}
 
-	for _, fileBytes := range fileWriter.Files {
-		lgr.Info(fmt.Sprintf("file Bytes: %s", string(fileBytes)))
+	fileNames := make([]string, 0, len(fileWriter.Files))
+	for fileName := range fileWriter.Files {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
 
-		deserialized, err := deserialize(fileBytes)
+	objs := make([]*unstructured.Unstructured, 0, len(fileNames))
+	// rollouts add copies of the rendered objects, they are applied with the generated objects
+	var copies []client.Object
+	for _, fileName := range fileNames {
+		deserialized, err := deserialize(fileWriter.Files[fileName])
 		if err != nil {
-			lgr.Error(err, "unable to deserialize file")
+			lgr.Error(err, "unable to deserialize file", "file", fileName)
 			return ctrl.Result{}, err
 		}
 
-		_, err = createObject(NewRestConfig(), deserialized)
+		switch typed := deserialized.(type) {
+		case *appsv1.Deployment:
+			if err := ar.configureDeployment(ctx, &app, typed); err != nil {
+				lgr.Error(err, "unable to configure deployment", "file", fileName)
+				return ctrl.Result{}, err
+			}
+			configureRollout(&app, typed)
+			if canary := canaryDeployment(&app, typed); canary != nil {
+				copies = append(copies, canary)
+			}
+		case *corev1.Service:
+			configureService(&app, typed)
+			configureRolloutService(&app, typed)
+			for _, s := range rolloutServices(&app, typed) {
+				copies = append(copies, s)
+			}
+		}
+
+		obj, err := toUnstructured(deserialized)
 		if err != nil {
-			lgr.Error(err, "unable to create object")
+			lgr.Error(err, "unable to convert object", "file", fileName)
 			return ctrl.Result{}, err
 		}
-		lgr.Info("created object")
-	}
 
-	return ctrl.Result{}, nil
-}
-
-func createObject(restConfig *rest.Config, obj runtime.Object) (runtime.Object, error) {
-	kubeClientSet := kubernetes.NewForConfigOrDie(restConfig)
-	// Create a REST mapper that tracks information about the available resources in the cluster.
-	groupResources, err := restmapper.GetAPIGroupResources(kubeClientSet.Discovery())
-	if err != nil {
-		return nil, err
+		if err := setOwnership(&app, obj, ar.client.Scheme()); err != nil {
+			lgr.Error(err, "unable to set object ownership", "file", fileName)
+			return ctrl.Result{}, err
+		}
+		objs = append(objs, obj)
 	}
-	rm := restmapper.NewDiscoveryRESTMapper(groupResources)
 
-	// Get some metadata needed to make the REST request.
-	gvk := obj.GetObjectKind().GroupVersionKind()
-	gk := schema.GroupKind{Group: gvk.Group, Kind: gvk.Kind}
-	mapping, err := rm.RESTMapping(gk, gvk.Version)
+	// objects the draft templates don't render are generated from the spec
+	generated, err := generatedObjects(&app)
 	if err != nil {
-		return nil, err
+		lgr.Error(err, "unable to generate objects")
+		return ctrl.Result{}, err
 	}
+	for _, g := range append(copies, generated...) {
+		obj, err := toUnstructured(g)
+		if err != nil {
+			lgr.Error(err, "unable to convert generated object", "kind", g.GetObjectKind().GroupVersionKind().Kind)
+			return ctrl.Result{}, err
+		}
 
-	namespace, err := meta.NewAccessor().Namespace(obj)
-	if err != nil {
-		return nil, err
+		if err := setOwnership(&app, obj, ar.client.Scheme()); err != nil {
+			lgr.Error(err, "unable to set generated object ownership", "kind", g.GetObjectKind().GroupVersionKind().Kind)
+			return ctrl.Result{}, err
+		}
+		objs = append(objs, obj)
 	}
 
-	// Create a client specifically for creating the object.
-	restClient, err := newRestClient(restConfig, mapping.GroupVersionKind.GroupVersion())
+	// applying fills in the objects from the api server, the revision records them as rendered
+	manifests, err := encodeManifests(objs)
 	if err != nil {
-		return nil, err
+		lgr.Error(err, "unable to encode manifests")
+		return ctrl.Result{}, err
 	}
 
-	// Use the REST helper to create the object in the "default" namespace.
-	restHelper := resource.NewHelper(restClient, mapping)
-	return restHelper.Create(namespace, false, obj)
-}
-
-func newRestClient(restConfig *rest.Config, gv schema.GroupVersion) (rest.Interface, error) {
-	restConfig.ContentConfig = resource.UnstructuredPlusDefaultContentConfig()
-	restConfig.GroupVersion = &gv
-	if len(gv.Group) == 0 {
-		restConfig.APIPath = "/api"
-	} else {
-		restConfig.APIPath = "/apis"
+	if err := ar.deploy(ctx, &app, objs); err != nil {
+		return ctrl.Result{}, err
 	}
 
-	return rest.RESTClientFor(restConfig)
-}
-
-func deserialize(data []byte) (runtime.Object, error) {
-	apiextensionsv1.AddToScheme(scheme.Scheme)
-	apiextensionsv1beta1.AddToScheme(scheme.Scheme)
-	decoder := scheme.Codecs.UniversalDeserializer()
-
-	runtimeObject, _, err := decoder.Decode(data, nil, nil)
-	if err != nil {
-		return nil, err
+	// revisions record what serves traffic, the intermediate steps of a rollout aren't recorded
+	if !canaryRunning(&app) {
+		if err := ar.recordRevision(ctx, &app, manifests); err != nil {
+			lgr.Error(err, "unable to record revision")
+			return ctrl.Result{}, err
+		}
 	}
 
-	return runtimeObject, nil
+	return res, nil
 }
 
-func NewRestConfig() *rest.Config {
-	return ctrl.GetConfigOrDie()
-}
+// deploy applies objs, prunes the objects app no longer renders and reports the state of the deployed objects in
+// the status of app. In the gitops delivery mode objs are committed to the config repository instead.
+func (ar *appReconciler) deploy(ctx context.Context, app *appv1alpha1.Application, objs []*unstructured.Unstructured) error {
+	lgr := log.FromContext(ctx)
+	defer ar.reportDeployment(ctx, app)
 
-// func addDockerfileToRepo(ctx context.Context) error {
-// 	s := github.NewGitHubService("<GH_TOKEN>")
-// 	s.CreateBranch(ctx, "bfoley13", "go_echo", "test-branch")
-// 	return nil
-// }
+	if app.GetDeliveryMode() == appv1alpha1.DeliveryModeGitOps {
+		if err := ar.commitManifests(ctx, app, objs); err != nil {
+			lgr.Error(err, "unable to commit manifests")
+			return err
+		}
+		return nil
+	}
+	app.Status.Delivery = nil
 
-func RunAcrBuild(ctx context.Context, app appv1alpha1.Application) (*armcontainerregistry.RunsClientGetResponse, error) {
-	lgr := log.FromContext(ctx)
-	acrClient, err := azure.NewACRClient(ctx)
+	enrolled, err := ar.enrollNamespace(ctx, app)
 	if err != nil {
-		lgr.Error(err, "unable to create devhub client")
-		return nil, err
+		lgr.Error(err, "unable to enroll namespace in mesh")
+		return err
 	}
 
-	// resp, err := acrClient.GetBuildSourceUploadURL(ctx, "bfoley-test", "appcontrollertest", nil)
-	// if err != nil {
-	// 	lgr.Error(err, "unable to get build source url")
-	// 	return err
-	// }
-
-	// uploadURL := resp.UploadURL
-	// relativePath := resp.RelativePath
-
-	// if uploadURL == nil || relativePath == nil || *uploadURL == "" || *relativePath == "" {
-	// 	lgr.Error(err, "upload url or relative path is nil or empty")
-	// 	return fmt.Errorf("upload url or relative path is nil or empty")
-	// }
-
-	// lgr.Info(fmt.Sprintf("upload url: %s, relative path: %s", *uploadURL, *relativePath))
-
-	// blobURL, err := url.Parse(*uploadURL)
-	// if err != nil {
-	// 	lgr.Error(err, "unable to parse blob url")
-	// 	return err
-	// }
+	applied, applyErr := applyObjects(ctx, ar.client, objs)
+	applied = append(enrolled, applied...)
+	unpruned, pruneErr := pruneResources(ctx, ar.client, app, staleResources(app.Status.Resources, applied))
+	app.Status.Resources = append(applied, unpruned...)
+	if err := errors.Join(applyErr, pruneErr); err != nil {
+		lgr.Error(err, "unable to apply objects")
+		setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionFalse, reasonApplyFailed, err.Error())
+		return err
+	}
+	setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionTrue, reasonApplied, fmt.Sprintf("applied %d objects", len(applied)))
 
-	// blobClient, err := azure.NewBlobClientFromUrl(ctx, *uploadURL)
-	// if err != nil {
-	// 	lgr.Error(err, "unable to create blob client")
-	// 	return err
-	// }
+	if err := ar.reconcileAvailability(ctx, app); err != nil {
+		lgr.Error(err, "unable to check app availability")
+		return err
+	}
 
-	// s := github.NewGitHubService("<GH_TOKEN>")
-	// fileBytes, err := s.DownloadRepo(ctx, "bfoley13", "go_echo", "main")
-	// if err != nil {
-	// 	lgr.Error(err, "unable to download repo")
-	// 	return err
-	// }
+	if err := ar.reconcileSecretsMounted(ctx, app); err != nil {
+		lgr.Error(err, "unable to check key vault mounts")
+		return err
+	}
 
-	// os.MkdirAll(filepath.Dir(*relativePath), os.ModePerm)
-	// os.WriteFile(*relativePath, fileBytes, os.ModePerm)
+	if err := ar.reconcileURL(ctx, app); err != nil {
+		lgr.Error(err, "unable to resolve app url")
+		return err
+	}
 
-	// newFile, _ := os.Open(*relativePath)
+	return nil
+}
 
-	// blobResp, err := blobClient.UploadFile(ctx, newFile, nil)
-	// if err != nil {
-	// 	lgr.Error(err, "unable to upload tar file")
-	// 	return err
-	// }
-	// lgr.Info(fmt.Sprintf("blob upload response: %v", blobResp))
+// generatedObjects returns the objects rendered for app in addition to the draft templates
+func generatedObjects(app *appv1alpha1.Application) ([]client.Object, error) {
+	var objs []client.Object
+	if app.Spec.KeyVault != nil {
+		spc, err := secretProviderClass(app)
+		if err != nil {
+			return nil, fmt.Errorf("generating secret provider class: %w", err)
+		}
+		objs = append(objs, spc)
+	}
 
-	resource, err := az.ParseResourceID(app.Spec.Acr.Id)
+	route, err := routeObject(app)
 	if err != nil {
-		lgr.Error(err, "unable to parse resource id")
-		return nil, err
+		return nil, fmt.Errorf("generating route: %w", err)
+	}
+	if route != nil {
+		objs = append(objs, route)
 	}
 
-	poller, err := acrClient.BeginScheduleRun(ctx, resource.ResourceGroup, resource.ResourceName, &armcontainerregistry.DockerBuildRequest{
-		DockerFilePath: toPtr(app.Spec.DockerConfig.Dockerfile),
-		ImageNames:     []*string{toPtr(fmt.Sprintf("%s:%s", app.Spec.DockerConfig.ImageName, app.Spec.DockerConfig.ImageTag))},
-		Type:           toPtr("DockerBuildRequest"),
-		IsPushEnabled:  toPtr(true),
-		SourceLocation: toPtr(fmt.Sprintf("https://github.com/%s/%s.git#%s:%s", app.Spec.Repository.Owner, app.Spec.Repository.Name, app.Spec.Repository.BranchName, app.Spec.DockerConfig.BuildContext)),
-		Platform: &armcontainerregistry.PlatformProperties{
-			OS:           toPtr(armcontainerregistry.OSLinux),
-			Architecture: toPtr(armcontainerregistry.ArchitectureAmd64),
-		},
-	}, nil)
+	policies, err := meshObjects(app)
 	if err != nil {
-		lgr.Error(err, "unable schedule docker build run")
-		return nil, err
+		return nil, fmt.Errorf("generating mesh policies: %w", err)
 	}
+	objs = append(objs, policies...)
 
-	acrRes, err := poller.PollUntilDone(ctx, nil)
-	if err != nil {
-		lgr.Error(err, "failed polling for acr build")
-		return nil, err
+	if app.Spec.Rollout != nil && app.Spec.Rollout.TrafficRouting == appv1alpha1.TrafficRoutingSMI {
+		objs = append(objs, trafficSplit(app))
 	}
-	runsClient, err := azure.NewACRRunsClient(ctx, resource.SubscriptionID)
-	if err != nil {
-		lgr.Error(err, "failed to get acr runs client")
-		return nil, err
+
+	return objs, nil
+}
+
+// defaultResources are used for Applications that don't set spec.resourceDefinition
+var defaultResources = appv1alpha1.ResourceDefinition{
+	CPULimit: "1",
+	MEMLimit: "1Gi",
+	CPUReq:   "500m",
+	MEMReq:   "512Mi",
+}
+
+func resourcesOrDefault(app *appv1alpha1.Application) appv1alpha1.ResourceDefinition {
+	if app.Spec.Resources == nil {
+		return defaultResources
 	}
 
-	isTerminalState := false
-	var acrRunResp *armcontainerregistry.RunsClientGetResponse
-	for !isTerminalState {
-		runsResp, err := runsClient.Get(ctx, resource.ResourceGroup, resource.ResourceName, *acrRes.Properties.RunID, nil)
-		if err != nil {
-			lgr.Error(err, "failed to get acr run")
-			return nil, err
-		}
+	return *app.Spec.Resources
+}
 
-		if runsResp.Properties != nil && runsResp.Properties.Status != nil {
-			if *runsResp.Properties.Status == "Succeeded" {
-				isTerminalState = true
-				lgr.Info("acr build succeeded")
-				acrRunResp = &runsResp
-				continue
-			} else if *runsResp.Properties.Status == "Failed" {
-				err = fmt.Errorf("failed acr build: %s", string(*runsResp.Properties.RunErrorMessage))
-				lgr.Error(err, "acr build failed")
-				return nil, err
-			} else {
-				lgr.Info(fmt.Sprintf("acr build in state: %s", *runsResp.Properties.Status))
-			}
-		}
+func deserialize(data []byte) (runtime.Object, error) {
+	apiextensionsv1.AddToScheme(scheme.Scheme)
+	apiextensionsv1beta1.AddToScheme(scheme.Scheme)
+	decoder := scheme.Codecs.UniversalDeserializer()
 
-		lgr.Info("waiting for acr build to complete")
-		time.Sleep(5 * time.Second)
+	runtimeObject, _, err := decoder.Decode(data, nil, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	return acrRunResp, nil
+	return runtimeObject, nil
 }
 
 func toPtr[T any](s T) *T {
 	v := s
 	return &v
 }
+
+func fromPtr[T any](p *T) T {
+	var v T
+	if p != nil {
+		v = *p
+	}
+	return v
+}
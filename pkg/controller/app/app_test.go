@@ -1,36 +1,1815 @@
 package app
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"github.com/bfoley13/appcontroller/pkg/build"
+	"github.com/bfoley13/appcontroller/pkg/github"
+	"github.com/bfoley13/appcontroller/pkg/source"
+	policyv1alpha1 "github.com/openservicemesh/osm/pkg/apis/policy/v1alpha1"
 	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	secv1 "sigs.k8s.io/secrets-store-csi-driver/apis/v1"
 )
 
-func TestRunACR(t *testing.T) {
-	t.Run("RunACR", func(t *testing.T) {
+// newFakeClient returns a fake client that emulates server-side apply, which the controller-runtime
+// fake client does not support, by creating or updating the applied object
+func newFakeClient(objs ...client.Object) client.Client {
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(appv1alpha1.AddToScheme(s))
+	utilruntime.Must(secv1.Install(s))
+	utilruntime.Must(gatewayv1.AddToScheme(s))
+	utilruntime.Must(policyv1alpha1.AddToScheme(s))
+	s.AddKnownTypeWithName(trafficSplitGVK, &unstructured.Unstructured{})
+	s.AddKnownTypeWithName(trafficSplitGVK.GroupVersion().WithKind("TrafficSplitList"), &unstructured.UnstructuredList{})
 
-		app := appv1alpha1.Application{
-			Spec: appv1alpha1.ApplicationSpec{
-				Repository: &appv1alpha1.Repository{
-					Owner:      "bfoley13",
-					Name:       "go_echo",
-					BranchName: "main",
-				},
-				DockerConfig: &appv1alpha1.DockerConfig{
-					Dockerfile:   "Dockerfile",
-					BuildContext: ".",
-					ImageName:    "go_echo",
-					ImageTag:     "latest",
-				},
-				Acr: &appv1alpha1.Acr{
-					Id: "/subscriptions/26ad903f-2330-429d-8389-864ac35c4350/resourceGroups/bfoley-test/providers/Microsoft.ContainerRegistry/registries/appcontrollertest",
+	return fake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&appv1alpha1.Application{}).
+		WithIndex(&appv1alpha1.Application{}, referencedObjectsIndex, referencedObjects).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Patch: func(ctx context.Context, cl client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				if patch.Type() != types.ApplyPatchType {
+					return cl.Patch(ctx, obj, patch, opts...)
+				}
+
+				applied := obj.(*unstructured.Unstructured)
+				current := &unstructured.Unstructured{}
+				current.SetGroupVersionKind(applied.GroupVersionKind())
+				err := cl.Get(ctx, client.ObjectKeyFromObject(applied), current)
+				if apierrors.IsNotFound(err) {
+					return cl.Create(ctx, applied)
+				}
+				if err != nil {
+					return err
+				}
+
+				if equality.Semantic.DeepEqual(current.Object["data"], applied.Object["data"]) &&
+					equality.Semantic.DeepEqual(current.Object["spec"], applied.Object["spec"]) &&
+					equality.Semantic.DeepEqual(current.GetLabels(), applied.GetLabels()) &&
+					equality.Semantic.DeepEqual(current.GetOwnerReferences(), applied.GetOwnerReferences()) {
+					current.DeepCopyInto(applied)
+					return nil
+				}
+
+				applied.SetResourceVersion(current.GetResourceVersion())
+				return cl.Update(ctx, applied)
+			},
+		}).
+		Build()
+}
+
+func renderedConfigMap(data string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace("default")
+	obj.SetName("test-config")
+	obj.Object["data"] = map[string]interface{}{"key": data}
+	return obj
+}
+
+func TestApplyObjects(t *testing.T) {
+	ctx := context.Background()
+	cl := newFakeClient()
+
+	t.Run("creates missing objects", func(t *testing.T) {
+		statuses, err := applyObjects(ctx, cl, []*unstructured.Unstructured{renderedConfigMap("one")})
+		assert.Nil(t, err)
+		assert.Len(t, statuses, 1)
+		assert.Equal(t, appv1alpha1.ApplyResultCreated, statuses[0].Result)
+		assert.Equal(t, "ConfigMap", statuses[0].Kind)
+		assert.Equal(t, "test-config", statuses[0].Name)
+	})
+
+	t.Run("leaves unchanged objects alone", func(t *testing.T) {
+		statuses, err := applyObjects(ctx, cl, []*unstructured.Unstructured{renderedConfigMap("one")})
+		assert.Nil(t, err)
+		assert.Equal(t, appv1alpha1.ApplyResultUnchanged, statuses[0].Result)
+	})
+
+	t.Run("updates changed objects", func(t *testing.T) {
+		statuses, err := applyObjects(ctx, cl, []*unstructured.Unstructured{renderedConfigMap("two")})
+		assert.Nil(t, err)
+		assert.Equal(t, appv1alpha1.ApplyResultConfigured, statuses[0].Result)
+	})
+
+	t.Run("reports failures per object", func(t *testing.T) {
+		unnamed := renderedConfigMap("one")
+		unnamed.SetName("")
+
+		statuses, err := applyObjects(ctx, cl, []*unstructured.Unstructured{unnamed, renderedConfigMap("three")})
+		assert.NotNil(t, err)
+		assert.Len(t, statuses, 2)
+		assert.Equal(t, appv1alpha1.ApplyResultFailed, statuses[0].Result)
+		assert.NotEmpty(t, statuses[0].Message)
+		assert.Equal(t, appv1alpha1.ApplyResultConfigured, statuses[1].Result)
+	})
+}
+
+func TestToUnstructured(t *testing.T) {
+	deserialized, err := deserialize([]byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: test
+  namespace: default
+spec:
+  template:
+    notAField: true
+    spec:
+      containers:
+      - name: test
+        image: test:latest
+`))
+	assert.Nil(t, err)
+
+	obj, err := toUnstructured(deserialized)
+	assert.Nil(t, err)
+	assert.Equal(t, "apps/v1", obj.GetAPIVersion())
+	assert.Equal(t, "Deployment", obj.GetKind())
+	_, found, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "template", "notAField")
+	assert.False(t, found)
+	_, found, _ = unstructured.NestedFieldNoCopy(obj.Object, "status")
+	assert.False(t, found)
+	_, found, _ = unstructured.NestedFieldNoCopy(obj.Object, "metadata", "creationTimestamp")
+	assert.False(t, found)
+}
+
+func testApp() *appv1alpha1.Application {
+	return &appv1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test-app",
+			Namespace:  "default",
+			Finalizers: []string{cleanupFinalizer},
+		},
+		Spec: appv1alpha1.ApplicationSpec{
+			ApplicationName: "test-app",
+			Namespace:       "target",
+			AppPort:         "80",
+		},
+	}
+}
+
+func renderedBy(app *appv1alpha1.Application, obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if err := setOwnership(app, obj, newFakeClient().Scheme()); err != nil {
+		panic(err)
+	}
+	return obj
+}
+
+func TestSetOwnership(t *testing.T) {
+	app := testApp()
+
+	t.Run("same namespace objects are owned", func(t *testing.T) {
+		obj := renderedBy(app, renderedConfigMap("one"))
+		assert.True(t, isRenderedBy(app, obj))
+		assert.Len(t, obj.GetOwnerReferences(), 1)
+		assert.Equal(t, app.Name, obj.GetOwnerReferences()[0].Name)
+		assert.Equal(t, []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(app)}}, enqueueRenderingApp(context.Background(), obj))
+	})
+
+	t.Run("other namespace objects are only labeled", func(t *testing.T) {
+		obj := renderedConfigMap("one")
+		obj.SetNamespace("target")
+		obj = renderedBy(app, obj)
+		assert.True(t, isRenderedBy(app, obj))
+		assert.Empty(t, obj.GetOwnerReferences())
+
+		reqs := enqueueRenderingApp(context.Background(), obj)
+		assert.Len(t, reqs, 1)
+		assert.Equal(t, client.ObjectKeyFromObject(app), reqs[0].NamespacedName)
+	})
+}
+
+func TestPruneResources(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+
+	owned := renderedBy(app, renderedConfigMap("one"))
+	unowned := renderedConfigMap("one")
+	unowned.SetName("unowned-config")
+	cl := newFakeClient(owned, unowned)
+
+	previous := []appv1alpha1.ResourceStatus{
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "test-config"},
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "unowned-config"},
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "already-gone"},
+	}
+	stale := staleResources(previous, previous[2:])
+	assert.Len(t, stale, 2)
+
+	remaining, err := pruneResources(ctx, cl, app, stale)
+	assert.Nil(t, err)
+	assert.Empty(t, remaining)
+
+	err = cl.Get(ctx, client.ObjectKeyFromObject(owned), &corev1.ConfigMap{})
+	assert.True(t, apierrors.IsNotFound(err))
+	err = cl.Get(ctx, client.ObjectKeyFromObject(unowned), &corev1.ConfigMap{})
+	assert.Nil(t, err)
+}
+
+func TestFinalize(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+
+	crossNamespace := renderedConfigMap("one")
+	crossNamespace.SetNamespace("target")
+	crossNamespace = renderedBy(app, crossNamespace)
+	enrolled := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "target", Labels: map[string]string{meshMonitoredByLabel: "osm"}}}
+	app.Status.Resources = []appv1alpha1.ResourceStatus{
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "target", Name: "test-config"},
+		{APIVersion: "v1", Kind: "Namespace", Name: "target"},
+	}
+
+	cl := newFakeClient(app, crossNamespace, enrolled)
+	assert.Nil(t, cl.Delete(ctx, app))
+	assert.Nil(t, cl.Get(ctx, client.ObjectKeyFromObject(app), app))
+
+	ar := &appReconciler{client: cl}
+	_, err := ar.finalize(ctx, app)
+	assert.Nil(t, err)
+
+	err = cl.Get(ctx, client.ObjectKeyFromObject(crossNamespace), &corev1.ConfigMap{})
+	assert.True(t, apierrors.IsNotFound(err))
+	err = cl.Get(ctx, client.ObjectKeyFromObject(app), &appv1alpha1.Application{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	namespace := &corev1.Namespace{}
+	assert.Nil(t, cl.Get(ctx, client.ObjectKey{Name: "target"}, namespace), "the namespace is only removed from the mesh")
+	assert.NotContains(t, namespace.Labels, meshMonitoredByLabel)
+}
+
+func TestNeedsBuild(t *testing.T) {
+	app := testApp()
+	app.Spec.Acr = &appv1alpha1.Acr{Id: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerRegistry/registries/acr"}
+	app.Spec.DockerConfig = &appv1alpha1.DockerConfig{
+		Dockerfile:   "Dockerfile",
+		BuildContext: ".",
+		ImageName:    "test-app",
+		ImageTag:     "latest",
+	}
+
+	hash := buildInputHash(app, "commit-1", "")
+	assert.True(t, needsBuild(app, hash))
+
+	app.Status.Build = &appv1alpha1.BuildStatus{Commit: "commit-1", InputHash: hash}
+	assert.False(t, needsBuild(app, buildInputHash(app, "commit-1", "")))
+	assert.True(t, needsBuild(app, buildInputHash(app, "commit-2", "")))
+
+	// in progress and failed runs aren't scheduled again for the same inputs
+	app.Status.Run = &appv1alpha1.RunStatus{ID: "run-2", State: appv1alpha1.RunStateRunning, InputHash: buildInputHash(app, "commit-2", "")}
+	assert.False(t, needsBuild(app, buildInputHash(app, "commit-2", "")))
+	app.Status.Run.State = appv1alpha1.RunStateFailed
+	assert.False(t, needsBuild(app, buildInputHash(app, "commit-2", "")))
+	assert.True(t, needsBuild(app, buildInputHash(app, "commit-3", "")))
+
+	app.Spec.DockerConfig.BuildContext = "./src"
+	assert.True(t, needsBuild(app, buildInputHash(app, "commit-1", "")))
+
+	app.Spec.DockerConfig.BuildContext = "."
+	app.Spec.DockerConfig.Dockerfile = "build/Dockerfile"
+	assert.True(t, needsBuild(app, buildInputHash(app, "commit-1", "")))
+
+	// a new version tag of a built commit is built again
+	app.Spec.DockerConfig.Dockerfile = "Dockerfile"
+	app.Spec.DockerConfig.TagStrategy = appv1alpha1.TagStrategySemver
+	app.Status.Build.InputHash = buildInputHash(app, "commit-1", "v1.0.0")
+	assert.False(t, needsBuild(app, buildInputHash(app, "commit-1", "v1.0.0")))
+	assert.True(t, needsBuild(app, buildInputHash(app, "commit-1", "v1.1.0")))
+}
+
+func TestImageTag(t *testing.T) {
+	app := testApp()
+	app.Spec.Repository = &appv1alpha1.Repository{Owner: "bfoley13", Name: "go_echo", BranchName: "feature/echo"}
+	app.Spec.DockerConfig = &appv1alpha1.DockerConfig{ImageName: "go_echo", ImageTag: "latest"}
+	commit := "3a0f86fb8e2d9c4a5b6c7d8e9f0a1b2c3d4e5f60"
+	now := time.Unix(1700000000, 0)
+
+	for strategy, want := range map[appv1alpha1.TagStrategy]string{
+		"":                            "latest",
+		appv1alpha1.TagStrategyFixed:  "latest",
+		appv1alpha1.TagStrategyCommit: commit,
+		appv1alpha1.TagStrategyBranchCommitTimestamp: "feature-echo-3a0f86f-1700000000",
+		appv1alpha1.TagStrategySemver:                "v1.2.0-rc.1_build.5",
+	} {
+		app.Spec.DockerConfig.TagStrategy = strategy
+		assert.Equal(t, want, imageTag(app, commit, "v1.2.0-rc.1+build.5", now), strategy)
+	}
+
+	app.Spec.DockerConfig.TagStrategy = appv1alpha1.TagStrategyBranchCommitTimestamp
+	app.Spec.Repository.BranchName = strings.Repeat("b", 200)
+	assert.Len(t, imageTag(app, commit, "", now), maxTagLength)
+
+	assert.Equal(t, "registry.io/echo:v1@sha256:abc", pinnedImage("registry.io/echo:v1", "sha256:abc"))
+	assert.Equal(t, "registry.io/echo:v1", pinnedImage("registry.io/echo:v1", ""))
+	assert.Equal(t, "registry.io/echo:v1@sha256:abc", pinnedImage("registry.io/echo:v1@sha256:abc", "sha256:def"))
+}
+
+func TestSemverTag(t *testing.T) {
+	ctx := context.Background()
+	repo := &appv1alpha1.Repository{Owner: "bfoley13", Name: "go_echo", BranchName: "main"}
+	provider := &fakeProvider{tags: map[string][]string{
+		"commit-1": {"latest", "v1", "1.2.0", "v1.10.0-rc.1", "v1.9.0", "v1.10.0+build.2"},
+		"commit-2": {"release", "v2"},
+		"commit-3": {"v2.0.0-rc.1", "v2.0.0", "v2.0.0-rc.2"},
+	}}
+
+	version, err := semverTag(ctx, nil, provider, repo, "commit-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1.10.0+build.2", version)
+
+	version, err = semverTag(ctx, nil, provider, repo, "commit-2")
+	assert.Nil(t, err)
+	assert.Empty(t, version)
+
+	// a release outranks the prereleases of its version
+	version, err = semverTag(ctx, nil, provider, repo, "commit-3")
+	assert.Nil(t, err)
+	assert.Equal(t, "v2.0.0", version)
+
+	t.Run("cache", func(t *testing.T) {
+		now := time.Now()
+		cache := newTagCache(func() time.Time { return now })
+		lookups := provider.tagLookups
+
+		version, err := semverTag(ctx, cache, provider, repo, "commit-2")
+		assert.Nil(t, err)
+		assert.Empty(t, version)
+		assert.Equal(t, lookups+1, provider.tagLookups)
+
+		provider.tags["commit-2"] = append(provider.tags["commit-2"], "v2.1.0")
+		version, err = semverTag(ctx, cache, provider, repo, "commit-2")
+		assert.Nil(t, err)
+		assert.Empty(t, version, "the listed tags are reused")
+		assert.Equal(t, lookups+1, provider.tagLookups)
+
+		now = now.Add(tagCacheTTL)
+		version, err = semverTag(ctx, cache, provider, repo, "commit-2")
+		assert.Nil(t, err)
+		assert.Equal(t, "v2.1.0", version)
+		assert.Equal(t, lookups+2, provider.tagLookups)
+	})
+}
+
+func TestSplitImageReference(t *testing.T) {
+	tests := []struct {
+		ref  string
+		name string
+		tag  string
+	}{
+		{ref: "test.azurecr.io/go_echo:v1", name: "test.azurecr.io/go_echo", tag: "v1"},
+		{ref: "test.azurecr.io/go_echo", name: "test.azurecr.io/go_echo", tag: "latest"},
+		{ref: "localhost:5000/go_echo", name: "localhost:5000/go_echo", tag: "latest"},
+		{ref: "localhost:5000/go_echo:v2", name: "localhost:5000/go_echo", tag: "v2"},
+		{ref: "test.azurecr.io/go_echo@sha256:abc", name: "test.azurecr.io/go_echo", tag: "latest@sha256:abc"},
+		{ref: "test.azurecr.io/go_echo:v1@sha256:abc", name: "test.azurecr.io/go_echo", tag: "v1@sha256:abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			name, tag := splitImageReference(tt.ref)
+			assert.Equal(t, tt.name, name)
+			assert.Equal(t, tt.tag, tag)
+		})
+	}
+}
+
+func TestSetPhase(t *testing.T) {
+	app := testApp()
+	setPhase(app)
+	assert.Equal(t, appv1alpha1.ApplicationPhaseBuilding, app.Status.Phase)
+
+	app.Status.Run = &appv1alpha1.RunStatus{ID: "run-1", State: appv1alpha1.RunStateRunning}
+	setCondition(app, appv1alpha1.ConditionTypeSourceReady, metav1.ConditionTrue, reasonResolved, "")
+	setCondition(app, appv1alpha1.ConditionTypeBuildSucceeded, metav1.ConditionUnknown, reasonBuilding, "")
+	setPhase(app)
+	assert.Equal(t, appv1alpha1.ApplicationPhaseBuilding, app.Status.Phase)
+
+	app.Status.Run.State = appv1alpha1.RunStateSucceeded
+	setCondition(app, appv1alpha1.ConditionTypeBuildSucceeded, metav1.ConditionTrue, reasonBuildSucceeded, "")
+	setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionTrue, reasonApplied, "")
+	setCondition(app, appv1alpha1.ConditionTypeAvailable, metav1.ConditionFalse, reasonProgressing, "")
+	setPhase(app)
+	assert.Equal(t, appv1alpha1.ApplicationPhaseDeploying, app.Status.Phase)
+
+	setCondition(app, appv1alpha1.ConditionTypeAvailable, metav1.ConditionTrue, reasonAvailable, "")
+	setPhase(app)
+	assert.Equal(t, appv1alpha1.ApplicationPhaseReady, app.Status.Phase)
+
+	setCondition(app, appv1alpha1.ConditionTypeBuildSucceeded, metav1.ConditionFalse, reasonBuildFailed, "")
+	setPhase(app)
+	assert.Equal(t, appv1alpha1.ApplicationPhaseFailed, app.Status.Phase)
+}
+
+func TestReconcileAvailability(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+	app.Status.Resources = []appv1alpha1.ResourceStatus{
+		{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "target", Name: "test-app"},
+		{APIVersion: "v1", Kind: "Service", Namespace: "target", Name: "test-app"},
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-app", Namespace: "target", Generation: 1},
+		Spec:       appsv1.DeploymentSpec{Replicas: toPtr(int32(2))},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: 2, AvailableReplicas: 1},
+	}
+	cl := newFakeClient()
+	ar := &appReconciler{client: cl}
+
+	// objects that were just applied may not be cached yet
+	assert.Nil(t, ar.reconcileAvailability(ctx, app))
+	assert.Equal(t, reasonProgressing, app.GetCondition(appv1alpha1.ConditionTypeAvailable).Reason)
+
+	assert.Nil(t, cl.Create(ctx, deployment))
+	assert.Nil(t, cl.Status().Update(ctx, deployment))
+	assert.Nil(t, ar.reconcileAvailability(ctx, app))
+	assert.True(t, conditionIs(app, appv1alpha1.ConditionTypeAvailable, metav1.ConditionFalse))
+	assert.Equal(t, "1 of 2 updated replicas available", app.GetCondition(appv1alpha1.ConditionTypeAvailable).Message)
+
+	deployment.Status.AvailableReplicas = 2
+	assert.Nil(t, cl.Status().Update(ctx, deployment))
+
+	assert.Nil(t, ar.reconcileAvailability(ctx, app))
+	assert.True(t, conditionIs(app, appv1alpha1.ConditionTypeAvailable, metav1.ConditionTrue))
+}
+
+// fakeProvider resolves every ref to commit and serves files as the repository, a Dockerfile when files are nil.
+// The branches, files, commits and pull requests created in it are recorded.
+type fakeProvider struct {
+	source.SourceProvider
+	commit       string
+	files        map[string]string
+	branches     []string
+	created      map[string]string
+	commits      []string
+	pullRequests []string
+	statuses     []string
+	deployments  []string
+	tags         map[string][]string
+	tagLookups   int
+}
+
+func (f *fakeProvider) ResolveRef(_ context.Context, _, _, _ string) (string, error) {
+	return f.commit, nil
+}
+
+func (f *fakeProvider) SourceURL(owner, repo, ref, contextPath string) string {
+	return fmt.Sprintf("https://git.example.com/%s/%s.git#%s:%s", owner, repo, ref, contextPath)
+}
+
+func (f *fakeProvider) DownloadRepo(_ context.Context, owner, repo, _ string) ([]byte, error) {
+	files := f.files
+	if files == nil {
+		files = map[string]string{"Dockerfile": "FROM scratch\n"}
+	}
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: owner + "-" + repo + "/" + name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (f *fakeProvider) CreateBranch(_ context.Context, _, _, branch, base string) error {
+	for _, b := range f.branches {
+		if strings.HasPrefix(b, branch+"<-") {
+			return fmt.Errorf("branch %s already exists", branch)
+		}
+	}
+	f.branches = append(f.branches, branch+"<-"+base)
+	return nil
+}
+
+func (f *fakeProvider) CreateFiles(_ context.Context, _, _, _, filePath string, content []byte) error {
+	if f.created == nil {
+		f.created = map[string]string{}
+	}
+	f.created[filePath] = string(content)
+	return nil
+}
+
+func (f *fakeProvider) CommitFiles(ctx context.Context, owner, repo, branch, _ string, files map[string][]byte) (string, error) {
+	f.commits = append(f.commits, branch)
+	for filePath, content := range files {
+		if err := f.CreateFiles(ctx, owner, repo, branch, filePath, content); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("config-%d", len(f.commits)), nil
+}
+
+func (f *fakeProvider) CreatePullRequest(_ context.Context, _, _, head, base, _, _ string) (string, error) {
+	f.pullRequests = append(f.pullRequests, head+"->"+base)
+	return fmt.Sprintf("https://git.example.com/pulls/%d", len(f.pullRequests)), nil
+}
+
+func (f *fakeProvider) PullRequestURL(_ context.Context, _, _, head, base string) (string, error) {
+	for i, pr := range f.pullRequests {
+		if pr == head+"->"+base {
+			return fmt.Sprintf("https://git.example.com/pulls/%d", i+1), nil
+		}
+	}
+	return "", nil
+}
+
+func (f *fakeProvider) SetCommitStatus(_ context.Context, _, _, sha, _, state, description, _ string) error {
+	f.statuses = append(f.statuses, fmt.Sprintf("%s %s: %s", sha, state, description))
+	return nil
+}
+
+func (f *fakeProvider) CreateDeployment(_ context.Context, _, _, ref, environment, _ string) (int64, error) {
+	f.deployments = append(f.deployments, ref+" to "+environment)
+	return int64(len(f.deployments)), nil
+}
+
+func (f *fakeProvider) CreateDeploymentStatus(_ context.Context, _, _ string, id int64, state, environmentURL, _ string) error {
+	f.deployments = append(f.deployments, fmt.Sprintf("%d %s %s", id, state, environmentURL))
+	return nil
+}
+
+func (f *fakeProvider) CommitTags(_ context.Context, _, _, sha string) ([]string, error) {
+	f.tagLookups++
+	return f.tags[sha], nil
+}
+
+func fakeProviders(cl client.Reader, newProvider source.ProviderFunc) *source.Providers {
+	return source.NewProviders(cl, github.NewTokenCache(nil), newProvider)
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+	app.Finalizers = nil
+	app.Spec.Namespace = "default"
+	app.Spec.Repository = &appv1alpha1.Repository{Owner: "bfoley13", Name: "go_echo", BranchName: "main"}
+	app.Spec.DockerConfig = &appv1alpha1.DockerConfig{Dockerfile: "Dockerfile", BuildContext: ".", ImageName: "go_echo", ImageTag: "latest"}
+	app.Spec.Acr = &appv1alpha1.Acr{Id: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerRegistry/registries/acr"}
+	app.Spec.Resources = &appv1alpha1.ResourceDefinition{CPULimit: "1", MEMLimit: "1Gi", CPUReq: "1", MEMReq: "1Gi"}
+
+	cl := newFakeClient(app)
+	builder := build.NewFakeBuilder()
+	resolver := &fakeProvider{commit: "commit-1"}
+	events := record.NewFakeRecorder(10)
+	ar := &appReconciler{
+		client: cl,
+		providers: fakeProviders(cl, func(*appv1alpha1.Repository, source.Credentials) source.SourceProvider {
+			return resolver
+		}),
+		events:   events,
+		builders: map[appv1alpha1.BuildStrategy]build.Builder{appv1alpha1.BuildStrategyACR: builder},
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+	current := func() *appv1alpha1.Application {
+		got := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, req.NamespacedName, got))
+		return got
+	}
+
+	t.Run("starts a build", func(t *testing.T) {
+		res, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, buildPollInterval, res.RequeueAfter)
+
+		got := current()
+		assert.Contains(t, got.Finalizers, cleanupFinalizer)
+		assert.Equal(t, appv1alpha1.ApplicationPhaseBuilding, got.Status.Phase)
+		assert.Equal(t, "fake-1", got.Status.Run.ID)
+		assert.Equal(t, appv1alpha1.RunStateRunning, got.Status.Run.State)
+		assert.Empty(t, got.Status.Resources)
+		assert.Equal(t, "Normal Building building commit commit-1 in run fake-1", <-events.Events)
+	})
+
+	t.Run("waits for the build", func(t *testing.T) {
+		res, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, buildPollInterval, res.RequeueAfter)
+		assert.Len(t, builder.Commits, 1)
+	})
+
+	t.Run("deploys the built image", func(t *testing.T) {
+		builder.Succeed("fake-1", "sha256:abc")
+		res, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Zero(t, res.RequeueAfter)
+
+		got := current()
+		// the deployed image is pinned to the digest that was built
+		assert.Equal(t, "fake.registry.io/go_echo:latest@sha256:abc", got.Status.Image)
+		assert.Equal(t, "sha256:abc", got.Status.ImageDigest)
+		assert.Equal(t, "commit-1", got.Status.Commit)
+		assert.Equal(t, "Normal BuildSucceeded built fake.registry.io/go_echo:latest from commit commit-1", <-events.Events)
+		assert.True(t, conditionIs(got, appv1alpha1.ConditionTypeBuildSucceeded, metav1.ConditionTrue))
+		assert.True(t, conditionIs(got, appv1alpha1.ConditionTypeDeployed, metav1.ConditionTrue))
+		assert.Equal(t, appv1alpha1.ApplicationPhaseDeploying, got.Status.Phase)
+		assert.Len(t, got.Status.Resources, 2)
+
+		deployment := &appsv1.Deployment{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: app.Name}, deployment))
+		assert.Equal(t, "fake.registry.io/go_echo:latest@sha256:abc", deployment.Spec.Template.Spec.Containers[0].Image)
+	})
+
+	t.Run("skips unchanged builds", func(t *testing.T) {
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Len(t, builder.Commits, 1)
+	})
+
+	t.Run("builds new commits", func(t *testing.T) {
+		resolver.commit = "commit-2"
+		res, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, buildPollInterval, res.RequeueAfter)
+		assert.Equal(t, []string{"commit-1", "commit-2"}, builder.Commits)
+		assert.Equal(t, "Normal Building building commit commit-2 in run fake-2", <-events.Events)
+
+		builder.Fail("fake-2", "build broke")
+		builder.Log("fake-2", "Step 1/2 : FROM golang\nStep 2/2 : RUN go build\nundefined: echo\n")
+		_, err = ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+
+		got := current()
+		assert.Equal(t, appv1alpha1.ApplicationPhaseFailed, got.Status.Phase)
+		assert.Equal(t, "build broke", got.Status.Run.Message)
+		assert.Equal(t, "Step 1/2 : FROM golang\nStep 2/2 : RUN go build\nundefined: echo", got.Status.Run.LogTail)
+		assert.Equal(t, "test-app-build-log", got.Status.Run.LogConfigMap)
+		buildLog := &corev1.ConfigMap{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: app.Namespace, Name: got.Status.Run.LogConfigMap}, buildLog))
+		assert.Equal(t, "fake-2", buildLog.Annotations[buildRunAnnotation])
+		assert.Contains(t, buildLog.Data[buildLogKey], "undefined: echo")
+		assert.Equal(t, "Warning BuildFailed build broke, log ends with:\nStep 1/2 : FROM golang\nStep 2/2 : RUN go build\nundefined: echo", <-events.Events)
+		// the last good build stays deployed
+		assert.Equal(t, "commit-1", got.Status.Commit)
+
+		// a failed build isn't retried until the source changes
+		_, err = ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Len(t, builder.Commits, 2)
+	})
+
+	t.Run("builds pushed commits", func(t *testing.T) {
+		ar.pushes = source.NewEvents()
+		ar.pushes.Push(req.NamespacedName, "commit-3")
+		res, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, buildPollInterval, res.RequeueAfter)
+		// the push is built although the resolver still reports the previous head
+		assert.Equal(t, []string{"commit-1", "commit-2", "commit-3"}, builder.Commits)
+
+		_, ok := ar.pushes.TakeCommit(req.NamespacedName)
+		assert.False(t, ok)
+	})
+}
+
+func TestPrivateRepository(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+	app.Spec.Namespace = "default"
+	app.Spec.Repository = &appv1alpha1.Repository{Owner: "bfoley13", Name: "private", BranchName: "main", CredentialsSecretName: "github"}
+	app.Spec.DockerConfig = &appv1alpha1.DockerConfig{Dockerfile: "Dockerfile", BuildContext: ".", ImageName: "private", ImageTag: "latest"}
+	app.Spec.Acr = &appv1alpha1.Acr{Id: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerRegistry/registries/acr"}
+
+	cl := newFakeClient(app)
+	builder := build.NewFakeBuilder()
+	var tokens []string
+	ar := &appReconciler{
+		client: cl,
+		providers: fakeProviders(cl, func(_ *appv1alpha1.Repository, creds source.Credentials) source.SourceProvider {
+			if creds.Token == "" {
+				return &fakeProvider{commit: "public"}
+			}
+			tokens = append(tokens, creds.Token)
+			return &fakeProvider{commit: "private"}
+		}),
+		events:   &record.FakeRecorder{},
+		builders: map[appv1alpha1.BuildStrategy]build.Builder{appv1alpha1.BuildStrategyACR: builder},
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+
+	t.Run("waits for the credentials", func(t *testing.T) {
+		_, err := ar.Reconcile(ctx, req)
+		assert.NotNil(t, err)
+
+		got := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, req.NamespacedName, got))
+		assert.Equal(t, reasonCredentialsFailed, conditionReason(got, appv1alpha1.ConditionTypeSourceReady))
+		assert.Empty(t, builder.Commits)
+
+		reqs := ar.enqueueReferencingApps("Secret")(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "github", Namespace: app.Namespace}})
+		assert.Equal(t, []reconcile.Request{req}, reqs)
+		// credentials are read from the Application's namespace only
+		assert.Empty(t, ar.enqueueReferencingApps("Secret")(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "github", Namespace: "other"}}))
+	})
+
+	t.Run("builds with the token", func(t *testing.T) {
+		assert.Nil(t, cl.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "github", Namespace: app.Namespace},
+			Data:       map[string][]byte{github.TokenKey: []byte("ghp_token")},
+		}))
+
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"ghp_token"}, tokens)
+		assert.Equal(t, []string{"private"}, builder.Commits)
+		assert.Equal(t, []string{"ghp_token"}, builder.Tokens)
+	})
+}
+
+func TestGenerateDockerfile(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+	app.Spec.Namespace = "default"
+	app.Spec.Repository = &appv1alpha1.Repository{Owner: "bfoley13", Name: "go_echo", BranchName: "main"}
+	app.Spec.DockerConfig = &appv1alpha1.DockerConfig{Dockerfile: "Dockerfile", BuildContext: "src", ImageName: "go_echo", ImageTag: "latest"}
+	app.Spec.Acr = &appv1alpha1.Acr{Id: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerRegistry/registries/acr"}
+
+	cl := newFakeClient(app)
+	builder := build.NewFakeBuilder()
+	provider := &fakeProvider{commit: "commit-1", files: map[string]string{
+		"README.md":   "# echo\n",
+		"src/go.mod":  "module example.com/echo\n\ngo 1.22\n",
+		"src/main.go": "package main\n\nimport \"net/http\"\n\nfunc main() {\n\thttp.ListenAndServe(\":80\", nil)\n}\n",
+	}}
+	ar := &appReconciler{
+		client: cl,
+		providers: fakeProviders(cl, func(*appv1alpha1.Repository, source.Credentials) source.SourceProvider {
+			return provider
+		}),
+		events:   &record.FakeRecorder{},
+		builders: map[appv1alpha1.BuildStrategy]build.Builder{appv1alpha1.BuildStrategyACR: builder},
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+	current := func() *appv1alpha1.Application {
+		got := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, req.NamespacedName, got))
+		return got
+	}
+
+	t.Run("opens a pull request adding a generated dockerfile", func(t *testing.T) {
+		res, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, dockerfilePollInterval, res.RequeueAfter)
+		assert.Empty(t, builder.Commits)
+
+		assert.Equal(t, []string{"appcontroller/test-app-dockerfile<-main"}, provider.branches)
+		assert.Equal(t, []string{"appcontroller/test-app-dockerfile"}, provider.commits)
+		assert.Contains(t, provider.created, "src/Dockerfile")
+		assert.Contains(t, provider.created, "src/.dockerignore")
+		assert.Contains(t, provider.created["src/Dockerfile"], "EXPOSE 80")
+		assert.Equal(t, []string{"appcontroller/test-app-dockerfile->main"}, provider.pullRequests)
+
+		got := current()
+		assert.Equal(t, appv1alpha1.ApplicationPhaseWaitingForDockerfile, got.Status.Phase)
+		assert.Equal(t, &appv1alpha1.DockerfileStatus{
+			Commit:         "commit-1",
+			Language:       "gomodule",
+			Branch:         "appcontroller/test-app-dockerfile",
+			PullRequestURL: "https://git.example.com/pulls/1",
+		}, got.Status.Dockerfile)
+	})
+
+	t.Run("reuses the branch and pull request of a lost status update", func(t *testing.T) {
+		got := current()
+		got.Status.Dockerfile = nil
+		assert.Nil(t, cl.Status().Update(ctx, got))
+
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Len(t, provider.branches, 1)
+		assert.Len(t, provider.commits, 1)
+		assert.Len(t, provider.pullRequests, 1)
+		assert.Equal(t, "https://git.example.com/pulls/1", current().Status.Dockerfile.PullRequestURL)
+	})
+
+	t.Run("pushes to the branch of a failed attempt", func(t *testing.T) {
+		got := current()
+		got.Status.Dockerfile = nil
+		assert.Nil(t, cl.Status().Update(ctx, got))
+		provider.pullRequests = nil
+
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Len(t, provider.branches, 1, "the branch already exists")
+		assert.Equal(t, []string{"appcontroller/test-app-dockerfile", "appcontroller/test-app-dockerfile"}, provider.commits)
+		assert.Equal(t, []string{"appcontroller/test-app-dockerfile->main"}, provider.pullRequests)
+		assert.Equal(t, "https://git.example.com/pulls/1", current().Status.Dockerfile.PullRequestURL)
+	})
+
+	t.Run("waits for the pull request to be merged", func(t *testing.T) {
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+
+		provider.commit = "commit-2"
+		_, err = ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Len(t, provider.pullRequests, 1)
+		assert.Empty(t, builder.Commits)
+		assert.Equal(t, "commit-2", current().Status.Dockerfile.Commit)
+	})
+
+	t.Run("builds once the dockerfile is merged", func(t *testing.T) {
+		provider.commit = "commit-3"
+		provider.files["src/Dockerfile"] = provider.created["src/Dockerfile"]
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"commit-3"}, builder.Commits)
+
+		got := current()
+		assert.Nil(t, got.Status.Dockerfile)
+		assert.True(t, conditionIs(got, appv1alpha1.ConditionTypeDockerfileReady, metav1.ConditionTrue))
+		assert.Equal(t, appv1alpha1.ApplicationPhaseBuilding, got.Status.Phase)
+	})
+}
+
+func TestGitOpsDelivery(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+	app.Spec.Namespace = "default"
+	app.Spec.Image = "test.azurecr.io/go_echo:v1"
+	app.Spec.Delivery = &appv1alpha1.Delivery{Mode: appv1alpha1.DeliveryModeGitOps, GitOps: &appv1alpha1.GitOpsDelivery{
+		Repository: appv1alpha1.Repository{Owner: "bfoley13", Name: "config", BranchName: "main"},
+		Path:       "apps/echo",
+	}}
+
+	cl := newFakeClient(app)
+	config := &fakeProvider{}
+	ar := &appReconciler{
+		client: cl,
+		providers: fakeProviders(cl, func(*appv1alpha1.Repository, source.Credentials) source.SourceProvider {
+			return config
+		}),
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+	current := func() *appv1alpha1.Application {
+		got := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, req.NamespacedName, got))
+		return got
+	}
+
+	t.Run("commits the manifests instead of applying them", func(t *testing.T) {
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"main"}, config.commits)
+
+		manifests := config.created["apps/echo/manifests.yaml"]
+		assert.Contains(t, manifests, "image: test.azurecr.io/go_echo:v1")
+		assert.NotContains(t, manifests, "ownerReferences")
+
+		err = cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: app.Name}, &appsv1.Deployment{})
+		assert.True(t, apierrors.IsNotFound(err))
+
+		got := current()
+		assert.Equal(t, "config-1", got.Status.Delivery.Commit)
+		assert.Equal(t, "apps/echo/manifests.yaml", got.Status.Delivery.Path)
+		assert.Empty(t, got.Status.Resources)
+		assert.Equal(t, reasonCommitted, conditionReason(got, appv1alpha1.ConditionTypeDeployed))
+		assert.Equal(t, appv1alpha1.ApplicationPhaseReady, got.Status.Phase)
+	})
+
+	t.Run("only commits changed manifests", func(t *testing.T) {
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Len(t, config.commits, 1)
+	})
+
+	t.Run("proposes changes in a pull request", func(t *testing.T) {
+		got := current()
+		got.Spec.Image = "test.azurecr.io/go_echo:v2"
+		got.Spec.Delivery.GitOps.PullRequest = true
+		assert.Nil(t, cl.Update(ctx, got))
+
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		// the branch is created from the branch the pull request merges into
+		assert.Len(t, config.branches, 1)
+		branch, base, _ := strings.Cut(config.branches[0], "<-")
+		assert.True(t, strings.HasPrefix(branch, "appcontroller/test-app-manifests-"))
+		assert.Equal(t, "main", base)
+		assert.Equal(t, []string{"main", branch}, config.commits)
+		assert.Equal(t, []string{branch + "->main"}, config.pullRequests)
+		assert.Contains(t, config.created["apps/echo/manifests.yaml"], "image: test.azurecr.io/go_echo:v2")
+
+		got = current()
+		assert.Equal(t, "config-2", got.Status.Delivery.Commit)
+		assert.Equal(t, "https://git.example.com/pulls/1", got.Status.Delivery.PullRequestURL)
+		assert.Equal(t, reasonPullRequestOpened, conditionReason(got, appv1alpha1.ConditionTypeDeployed))
+	})
+
+	t.Run("finds the pull request of a lost status update", func(t *testing.T) {
+		got := current()
+		got.Status.Delivery = nil
+		assert.Nil(t, cl.Status().Update(ctx, got))
+
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Len(t, config.commits, 2)
+		assert.Len(t, config.pullRequests, 1)
+
+		got = current()
+		assert.Equal(t, "https://git.example.com/pulls/1", got.Status.Delivery.PullRequestURL)
+		assert.Equal(t, reasonPullRequestOpened, conditionReason(got, appv1alpha1.ConditionTypeDeployed))
+	})
+}
+
+func TestReportStatus(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+	app.Spec.Namespace = "default"
+	app.Spec.Repository = &appv1alpha1.Repository{Owner: "bfoley13", Name: "go_echo", BranchName: "main", ReportStatus: true}
+	app.Spec.DockerConfig = &appv1alpha1.DockerConfig{Dockerfile: "Dockerfile", BuildContext: ".", ImageName: "go_echo", ImageTag: "latest"}
+	app.Spec.Acr = &appv1alpha1.Acr{Id: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerRegistry/registries/acr"}
+
+	cl := newFakeClient(app)
+	builder := build.NewFakeBuilder()
+	repo := &fakeProvider{commit: "commit-1"}
+	ar := &appReconciler{
+		client: cl,
+		providers: fakeProviders(cl, func(*appv1alpha1.Repository, source.Credentials) source.SourceProvider {
+			return repo
+		}),
+		events:   &record.FakeRecorder{},
+		builders: map[appv1alpha1.BuildStrategy]build.Builder{appv1alpha1.BuildStrategyACR: builder},
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+
+	t.Run("reports builds as commit statuses", func(t *testing.T) {
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"commit-1 pending: building in run fake-1"}, repo.statuses)
+
+		builder.Succeed("fake-1", "sha256:abc")
+		_, err = ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, "commit-1 success: built fake.registry.io/go_echo:latest", repo.statuses[1])
+		assert.Equal(t, []string{"commit-1 to default/test-app", "1 in_progress "}, repo.deployments)
+	})
+
+	t.Run("reports the deploy once it is available", func(t *testing.T) {
+		deployment := &appsv1.Deployment{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: app.Name}, deployment))
+		replicas := int32(1)
+		if deployment.Spec.Replicas != nil {
+			replicas = *deployment.Spec.Replicas
+		}
+		deployment.Status = appsv1.DeploymentStatus{ObservedGeneration: deployment.Generation, UpdatedReplicas: replicas, AvailableReplicas: replicas}
+		assert.Nil(t, cl.Status().Update(ctx, deployment))
+
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		got := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, req.NamespacedName, got))
+		assert.Equal(t, &appv1alpha1.GitHubDeploymentStatus{ID: 1, Commit: "commit-1", State: "success"}, got.Status.GitHubDeployment)
+		assert.Equal(t, "1 success "+got.Status.URL, repo.deployments[2])
+
+		// a successful deploy isn't reported again
+		_, err = ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Len(t, repo.deployments, 3)
+	})
+
+	t.Run("reports failed builds with their message", func(t *testing.T) {
+		repo.commit = "commit-2"
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		builder.Fail("fake-2", "build broke")
+		builder.Log("fake-2", "Step 2/2 : RUN go build\n./main.go:3:1: undefined: echo\n")
+		_, err = ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, "commit-2 failure: run fake-2 failed: build broke, log ends with: ./main.go:3:1: undefined: echo", repo.statuses[len(repo.statuses)-1])
+		assert.Len(t, repo.deployments, 3)
+	})
+
+	t.Run("shortens the message to fit the end of the log", func(t *testing.T) {
+		run := &appv1alpha1.RunStatus{ID: "fake-3", Message: strings.Repeat("é", 200), LogTail: "Step 1/2 : FROM golang\n" + strings.Repeat("x", 200)}
+		description := buildFailedDescription(run)
+		assert.Equal(t, maxStatusDescription, utf8.RuneCountInString(description))
+		assert.True(t, strings.HasSuffix(description, "xxx"))
+		assert.Contains(t, description, "run fake-3 failed: éé")
+		assert.Contains(t, description, "..., log ends with: ...xx")
+
+		run.LogTail = "undefined: echo"
+		description = buildFailedDescription(run)
+		assert.Equal(t, maxStatusDescription, utf8.RuneCountInString(description))
+		assert.True(t, strings.HasSuffix(description, "é..., log ends with: undefined: echo"))
+	})
+}
+
+func TestSemverTagging(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+	app.Spec.Namespace = "default"
+	app.Spec.Repository = &appv1alpha1.Repository{Owner: "bfoley13", Name: "go_echo", BranchName: "main"}
+	app.Spec.DockerConfig = &appv1alpha1.DockerConfig{Dockerfile: "Dockerfile", BuildContext: ".", ImageName: "go_echo", TagStrategy: appv1alpha1.TagStrategySemver}
+	app.Spec.Acr = &appv1alpha1.Acr{Id: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerRegistry/registries/acr"}
+
+	cl := newFakeClient(app)
+	builder := build.NewFakeBuilder()
+	repo := &fakeProvider{commit: "commit-1", tags: map[string][]string{}}
+	now := time.Now()
+	ar := &appReconciler{
+		client: cl,
+		providers: fakeProviders(cl, func(*appv1alpha1.Repository, source.Credentials) source.SourceProvider {
+			return repo
+		}),
+		events:   &record.FakeRecorder{},
+		builders: map[appv1alpha1.BuildStrategy]build.Builder{appv1alpha1.BuildStrategyACR: builder},
+		tags:     newTagCache(func() time.Time { return now }),
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+
+	t.Run("waits for a version tag", func(t *testing.T) {
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Empty(t, builder.Commits)
+
+		got := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, req.NamespacedName, got))
+		assert.Equal(t, reasonWaitingForTag, conditionReason(got, appv1alpha1.ConditionTypeBuildSucceeded))
+	})
+
+	t.Run("builds tagged commits with their version", func(t *testing.T) {
+		repo.tags["commit-1"] = []string{"v1.0.0"}
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Empty(t, builder.Commits, "the tags listed without a version are reused")
+
+		now = now.Add(tagCacheTTL)
+		_, err = ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"commit-1"}, builder.Commits)
+
+		builder.Succeed("fake-1", "sha256:abc")
+		_, err = ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		got := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, req.NamespacedName, got))
+		assert.Equal(t, "fake.registry.io/go_echo:v1.0.0@sha256:abc", got.Status.Image)
+	})
+
+	t.Run("builds a built commit again with a new version", func(t *testing.T) {
+		lookups := repo.tagLookups
+		repo.tags["commit-1"] = append(repo.tags["commit-1"], "v1.0.1")
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, lookups, repo.tagLookups, "the tags are listed at most once per ttl")
+		assert.Equal(t, []string{"commit-1"}, builder.Commits)
+
+		now = now.Add(tagCacheTTL)
+		_, err = ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, lookups+1, repo.tagLookups)
+		assert.Equal(t, []string{"commit-1", "commit-1"}, builder.Commits)
+	})
+
+	t.Run("builds the release of a new commit tagged with its prerelease", func(t *testing.T) {
+		builder.Succeed("fake-2", "sha256:def")
+		repo.commit = "commit-2"
+		repo.tags["commit-2"] = []string{"v1.1.0-rc.1", "v1.1.0"}
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"commit-1", "commit-1", "commit-2"}, builder.Commits)
+
+		builder.Succeed("fake-3", "sha256:fed")
+		_, err = ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+		got := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, req.NamespacedName, got))
+		assert.Equal(t, "fake.registry.io/go_echo:v1.1.0@sha256:fed", got.Status.Image)
+	})
+}
+
+func TestReconcilePrebuiltImage(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+	app.Spec.Namespace = "default"
+	app.Spec.Image = "test.azurecr.io/go_echo@sha256:abc"
+
+	cl := newFakeClient(app)
+	builder := build.NewFakeBuilder()
+	ar := &appReconciler{
+		client:   cl,
+		events:   &record.FakeRecorder{},
+		builders: map[appv1alpha1.BuildStrategy]build.Builder{appv1alpha1.BuildStrategyACR: builder},
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+
+	_, err := ar.Reconcile(ctx, req)
+	assert.Nil(t, err)
+	assert.Empty(t, builder.Commits)
+
+	got := &appv1alpha1.Application{}
+	assert.Nil(t, cl.Get(ctx, req.NamespacedName, got))
+	assert.Equal(t, app.Spec.Image, got.Status.Image)
+	assert.Equal(t, "sha256:abc", got.Status.ImageDigest)
+	assert.True(t, conditionIs(got, appv1alpha1.ConditionTypeDeployed, metav1.ConditionTrue))
+
+	deployment := &appsv1.Deployment{}
+	assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: app.Name}, deployment))
+	assert.Equal(t, "test.azurecr.io/go_echo:latest@sha256:abc", deployment.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, defaultResources.CPULimit, deployment.Spec.Template.Spec.Containers[0].Resources.Limits.Cpu().String())
+
+	t.Run("rejects multiple sources", func(t *testing.T) {
+		got.Spec.Repository = &appv1alpha1.Repository{Owner: "bfoley13", Name: "go_echo", BranchName: "main"}
+		assert.Nil(t, cl.Update(ctx, got))
+
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+
+		assert.Nil(t, cl.Get(ctx, req.NamespacedName, got))
+		assert.Equal(t, appv1alpha1.ApplicationPhaseFailed, got.Status.Phase)
+		assert.Equal(t, reasonInvalidSpec, got.GetCondition(appv1alpha1.ConditionTypeSourceReady).Reason)
+	})
+}
+
+func TestValidateSource(t *testing.T) {
+	app := testApp()
+	assert.NotNil(t, app.ValidateSource())
+
+	app.Spec.Image = "test.azurecr.io/go_echo:v1"
+	assert.Nil(t, app.ValidateSource())
+
+	app.Spec.Image = ""
+	app.Spec.Repository = &appv1alpha1.Repository{Owner: "bfoley13", Name: "go_echo", BranchName: "main"}
+	assert.NotNil(t, app.ValidateSource(), "docker config is required")
+
+	app.Spec.DockerConfig = &appv1alpha1.DockerConfig{Dockerfile: "Dockerfile", BuildContext: ".", ImageName: "go_echo", ImageTag: "latest"}
+	assert.NotNil(t, app.ValidateSource(), "acr is required")
+
+	app.Spec.Build = &appv1alpha1.BuildConfig{Strategy: appv1alpha1.BuildStrategyKaniko}
+	assert.NotNil(t, app.ValidateSource(), "registry is required")
+
+	app.Spec.Build.Registry = "registry.example.com"
+	assert.Nil(t, app.ValidateSource())
+}
+
+func TestConfigureDeployment(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+	app.Spec.Env = []corev1.EnvVar{
+		{Name: "MODE", Value: "test"},
+		{Name: "PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "creds"}, Key: "password"}}},
+	}
+	app.Spec.EnvFrom = []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}}}
+	app.Spec.Files = []appv1alpha1.FileMount{
+		{MountPath: "/etc/settings", ConfigMapName: "settings"},
+		{MountPath: "/etc/creds", SecretName: "creds"},
+	}
+
+	settings := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "target"},
+		Data:       map[string]string{"level": "debug"},
+	}
+	cl := newFakeClient(app, settings)
+	ar := &appReconciler{client: cl}
+
+	newDeployment := func() *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: app.Name, Namespace: "target"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: app.Name}}},
 				},
 			},
 		}
+	}
+
+	deployment := newDeployment()
+	assert.Nil(t, ar.configureDeployment(ctx, app, deployment))
+	container := deployment.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, app.Spec.Env, container.Env)
+	assert.Equal(t, app.Spec.EnvFrom, container.EnvFrom)
+	assert.Len(t, deployment.Spec.Template.Spec.Volumes, 2)
+	assert.Equal(t, "settings", deployment.Spec.Template.Spec.Volumes[0].ConfigMap.Name)
+	assert.Equal(t, "creds", deployment.Spec.Template.Spec.Volumes[1].Secret.SecretName)
+	assert.Equal(t, []corev1.VolumeMount{
+		{Name: fileMountVolume(0), MountPath: "/etc/settings", ReadOnly: true},
+		{Name: fileMountVolume(1), MountPath: "/etc/creds", ReadOnly: true},
+	}, container.VolumeMounts)
+	hash := deployment.Spec.Template.Annotations[configHashAnnotation]
+	assert.NotEmpty(t, hash)
+
+	t.Run("hash is stable", func(t *testing.T) {
+		deployment := newDeployment()
+		assert.Nil(t, ar.configureDeployment(ctx, app, deployment))
+		assert.Equal(t, hash, deployment.Spec.Template.Annotations[configHashAnnotation])
+	})
+
+	t.Run("hash changes with referenced data", func(t *testing.T) {
+		assert.Nil(t, cl.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "target"},
+			Data:       map[string][]byte{"password": []byte("hunter2")},
+		}))
+
+		deployment := newDeployment()
+		assert.Nil(t, ar.configureDeployment(ctx, app, deployment))
+		assert.NotEqual(t, hash, deployment.Spec.Template.Annotations[configHashAnnotation])
+	})
+
+	t.Run("referencing apps are enqueued", func(t *testing.T) {
+		reqs := ar.enqueueReferencingApps("ConfigMap")(ctx, settings)
+		assert.Equal(t, []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(app)}}, reqs)
+
+		other := settings.DeepCopy()
+		other.Namespace = "default"
+		assert.Empty(t, ar.enqueueReferencingApps("ConfigMap")(ctx, other))
+		assert.Empty(t, ar.enqueueReferencingApps("Secret")(ctx, settings))
+	})
 
-		_, err := RunAcrBuild(context.Background(), app)
+	t.Run("no references", func(t *testing.T) {
+		deployment := newDeployment()
+		assert.Nil(t, ar.configureDeployment(ctx, testApp(), deployment))
+		assert.Empty(t, deployment.Spec.Template.Annotations)
+	})
+}
+
+func TestKeyVault(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+	app.Spec.Namespace = "default"
+	app.Spec.Image = "test.azurecr.io/go_echo:v1"
+	app.Spec.KeyVault = &appv1alpha1.KeyVault{
+		VaultName:          "vault",
+		TenantID:           "tenant",
+		ClientID:           "client",
+		ServiceAccountName: "workload",
+		Objects:            []appv1alpha1.KeyVaultObject{{Name: "password"}, {Name: "tls", Type: appv1alpha1.KeyVaultObjectTypeCert, Key: "tls.crt"}},
+		SecretName:         "vault-secrets",
+	}
+	app.Spec.EnvFrom = []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "vault-secrets"}}}}
+	app.Default()
+
+	t.Run("secret provider class", func(t *testing.T) {
+		spc, err := secretProviderClass(app)
 		assert.Nil(t, err)
+		assert.Equal(t, "default", spc.Namespace)
+		assert.Equal(t, secv1.Provider("azure"), spc.Spec.Provider)
+		assert.Equal(t, "vault", spc.Spec.Parameters["keyvaultName"])
+		assert.Equal(t, "client", spc.Spec.Parameters["clientID"])
+		assert.Equal(t, "array:\n- |\n  objectName: password\n  objectType: secret\n  objectVersion: \"\"\n- |\n  objectName: tls\n  objectType: cert\n  objectVersion: \"\"\n", spc.Spec.Parameters["objects"])
+		assert.Equal(t, []*secv1.SecretObject{{
+			SecretName: "vault-secrets",
+			Type:       "Opaque",
+			Data:       []*secv1.SecretObjectData{{ObjectName: "password", Key: "password"}, {ObjectName: "tls", Key: "tls.crt"}},
+		}}, spc.Spec.SecretObjects)
+	})
+
+	cl := newFakeClient(app)
+	ar := &appReconciler{client: cl}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+	_, err := ar.Reconcile(ctx, req)
+	assert.Nil(t, err)
+
+	t.Run("deployment mounts the objects", func(t *testing.T) {
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: app.Name}, &secv1.SecretProviderClass{}))
+
+		deployment := &appsv1.Deployment{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: app.Name}, deployment))
+		podSpec := deployment.Spec.Template.Spec
+		assert.Equal(t, "true", deployment.Spec.Template.Labels[workloadIdentityLabel])
+		assert.Equal(t, "workload", podSpec.ServiceAccountName)
+		assert.Equal(t, secretsStoreDriver, podSpec.Volumes[0].CSI.Driver)
+		assert.Equal(t, []corev1.VolumeMount{{Name: keyVaultVolume, MountPath: appv1alpha1.DefaultKeyVaultMountPath, ReadOnly: true}}, podSpec.Containers[0].VolumeMounts)
+		assert.Empty(t, deployment.Spec.Template.Annotations[configHashAnnotation], "the synced secret isn't hashed")
+	})
+
+	got := &appv1alpha1.Application{}
+	assert.Nil(t, cl.Get(ctx, req.NamespacedName, got))
+	assert.Equal(t, reasonMountPending, got.GetCondition(appv1alpha1.ConditionTypeSecretsMounted).Reason)
+
+	t.Run("reports mounts", func(t *testing.T) {
+		podStatus := &secv1.SecretProviderClassPodStatus{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-default-test-app", Namespace: "default"},
+			Status:     secv1.SecretProviderClassPodStatusStatus{PodName: "pod", SecretProviderClassName: app.Name, Mounted: true},
+		}
+		assert.Nil(t, cl.Create(ctx, podStatus))
+		assert.Equal(t, []reconcile.Request{{NamespacedName: req.NamespacedName}}, ar.enqueueMountingApps(ctx, podStatus))
+
+		assert.Nil(t, ar.reconcileSecretsMounted(ctx, got))
+		assert.True(t, conditionIs(got, appv1alpha1.ConditionTypeSecretsMounted, metav1.ConditionTrue))
+
+		got.Spec.KeyVault = nil
+		assert.Nil(t, ar.reconcileSecretsMounted(ctx, got))
+		assert.Nil(t, got.GetCondition(appv1alpha1.ConditionTypeSecretsMounted))
+	})
+}
+
+func TestExpose(t *testing.T) {
+	ctx := context.Background()
+	newApp := func(expose *appv1alpha1.Expose) *appv1alpha1.Application {
+		app := testApp()
+		app.Spec.Namespace = "default"
+		app.Spec.Image = "test.azurecr.io/go_echo:v1"
+		app.Spec.Expose = expose
+		app.Default()
+		return app
+	}
+	reconcileApp := func(t *testing.T, app *appv1alpha1.Application, objs ...client.Object) (client.Client, *appv1alpha1.Application) {
+		cl := newFakeClient(append(objs, app)...)
+		ar := &appReconciler{client: cl}
+		_, err := ar.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		assert.Nil(t, err)
+
+		got := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKeyFromObject(app), got))
+		return cl, got
+	}
+	serviceKey := client.ObjectKey{Namespace: "default", Name: "test-app"}
+
+	t.Run("load balancer by default", func(t *testing.T) {
+		cl, got := reconcileApp(t, newApp(nil))
+		service := &corev1.Service{}
+		assert.Nil(t, cl.Get(ctx, serviceKey, service))
+		assert.Equal(t, corev1.ServiceTypeLoadBalancer, service.Spec.Type)
+		assert.Empty(t, got.Status.URL, "waits for the load balancer address")
+
+		service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "20.0.0.1"}}
+		assert.Nil(t, cl.Status().Update(ctx, service))
+		ar := &appReconciler{client: cl}
+		assert.Nil(t, ar.reconcileURL(ctx, got))
+		assert.Equal(t, "http://20.0.0.1:80", got.Status.URL)
+	})
+
+	t.Run("cluster ip", func(t *testing.T) {
+		cl, got := reconcileApp(t, newApp(&appv1alpha1.Expose{Type: appv1alpha1.ExposeTypeClusterIP, Port: 8080}))
+		service := &corev1.Service{}
+		assert.Nil(t, cl.Get(ctx, serviceKey, service))
+		assert.Equal(t, corev1.ServiceTypeClusterIP, service.Spec.Type)
+		assert.Equal(t, int32(8080), service.Spec.Ports[0].Port)
+		assert.Equal(t, "http://test-app.default.svc.cluster.local:8080", got.Status.URL)
+	})
+
+	t.Run("ingress", func(t *testing.T) {
+		cl, got := reconcileApp(t, newApp(&appv1alpha1.Expose{Type: appv1alpha1.ExposeTypeIngress, Host: "echo.example.com", IngressClassName: "nginx", TLSSecretName: "echo-tls"}))
+		service := &corev1.Service{}
+		assert.Nil(t, cl.Get(ctx, serviceKey, service))
+		assert.Equal(t, corev1.ServiceTypeClusterIP, service.Spec.Type)
+
+		ingress := &networkingv1.Ingress{}
+		assert.Nil(t, cl.Get(ctx, serviceKey, ingress))
+		assert.Equal(t, "nginx", *ingress.Spec.IngressClassName)
+		assert.Equal(t, "echo.example.com", ingress.Spec.Rules[0].Host)
+		assert.Equal(t, "/", ingress.Spec.Rules[0].HTTP.Paths[0].Path)
+		assert.Equal(t, int32(80), ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port.Number)
+		assert.Equal(t, []string{"echo.example.com"}, ingress.Spec.TLS[0].Hosts)
+		assert.Equal(t, "echo-tls", ingress.Spec.TLS[0].SecretName)
+		assert.Equal(t, "azure-devx-appcontroller", ingress.Labels["kubernetes.azure.com/generator"], "rendered from the draft addon")
+		assert.Empty(t, ingress.Annotations, "the mesh and key vault annotations don't apply")
+		assert.Equal(t, "https://echo.example.com/", got.Status.URL)
+		assert.Contains(t, got.Status.Resources, appv1alpha1.ResourceStatus{APIVersion: "networking.k8s.io/v1", Kind: "Ingress", Namespace: "default", Name: "test-app", Result: appv1alpha1.ApplyResultCreated})
+	})
+
+	t.Run("ingress to a mesh backend", func(t *testing.T) {
+		app := newApp(&appv1alpha1.Expose{Type: appv1alpha1.ExposeTypeIngress, Host: "echo.example.com", Path: "/"})
+		app.Spec.Mesh = &appv1alpha1.Mesh{}
+		ingress, err := renderIngress(app, 80)
+		assert.Nil(t, err)
+		assert.Equal(t, "true", ingress.Annotations["kubernetes.azure.com/use-osm-mtls"])
+		assert.Equal(t, "HTTPS", ingress.Annotations["nginx.ingress.kubernetes.io/backend-protocol"])
+		assert.Contains(t, ingress.Annotations["nginx.ingress.kubernetes.io/configuration-snippet"], "default.default.cluster.local")
+		assert.Nil(t, ingress.Spec.IngressClassName)
+		assert.Nil(t, ingress.Spec.TLS)
+	})
+
+	t.Run("ingress without host", func(t *testing.T) {
+		cl, got := reconcileApp(t, newApp(&appv1alpha1.Expose{Type: appv1alpha1.ExposeTypeIngress, Path: "/echo"}))
+		assert.Empty(t, got.Status.URL)
+
+		ingress := &networkingv1.Ingress{}
+		assert.Nil(t, cl.Get(ctx, serviceKey, ingress))
+		ingress.Status.LoadBalancer.Ingress = []networkingv1.IngressLoadBalancerIngress{{IP: "20.0.0.2"}}
+		assert.Nil(t, cl.Status().Update(ctx, ingress))
+		ar := &appReconciler{client: cl}
+		assert.Nil(t, ar.reconcileURL(ctx, got))
+		assert.Equal(t, "http://20.0.0.2/echo", got.Status.URL)
+	})
+
+	t.Run("gateway", func(t *testing.T) {
+		gateway := &gatewayv1.Gateway{
+			ObjectMeta: metav1.ObjectMeta{Name: "gateway", Namespace: "infra"},
+			Status:     gatewayv1.GatewayStatus{Addresses: []gatewayv1.GatewayStatusAddress{{Value: "20.0.0.3"}}},
+		}
+		cl, got := reconcileApp(t, newApp(&appv1alpha1.Expose{Type: appv1alpha1.ExposeTypeGateway, Gateway: &appv1alpha1.GatewayReference{Name: "gateway", Namespace: "infra", SectionName: "http"}}), gateway)
+
+		route := &gatewayv1.HTTPRoute{}
+		assert.Nil(t, cl.Get(ctx, serviceKey, route))
+		assert.Equal(t, gatewayv1.ObjectName("gateway"), route.Spec.ParentRefs[0].Name)
+		assert.Equal(t, gatewayv1.Namespace("infra"), *route.Spec.ParentRefs[0].Namespace)
+		assert.Equal(t, gatewayv1.SectionName("http"), *route.Spec.ParentRefs[0].SectionName)
+		assert.Equal(t, "/", *route.Spec.Rules[0].Matches[0].Path.Value)
+		assert.Equal(t, gatewayv1.ObjectName("test-app"), route.Spec.Rules[0].BackendRefs[0].Name)
+		assert.Equal(t, "http://20.0.0.3/", got.Status.URL)
+	})
+}
+
+func TestMesh(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+	app.Spec.Namespace = "default"
+	app.Spec.Image = "test.azurecr.io/go_echo:v1"
+	app.Spec.Expose = &appv1alpha1.Expose{Type: appv1alpha1.ExposeTypeIngress}
+	app.Spec.Mesh = &appv1alpha1.Mesh{
+		Egress: []appv1alpha1.MeshEgress{{Name: "github", Hosts: []string{"api.github.com"}, Port: 443, Protocol: appv1alpha1.MeshProtocolHTTPS}},
+		Retry: &appv1alpha1.MeshRetry{
+			Destinations: []appv1alpha1.MeshServiceReference{{Name: "backend", Namespace: "default"}},
+			NumRetries:   toPtr(int32(3)),
+		},
+		RateLimit:      &appv1alpha1.MeshRateLimit{Requests: 100, Unit: appv1alpha1.RateLimitUnit("minute")},
+		CircuitBreaker: &appv1alpha1.MeshCircuitBreaker{MaxConnections: toPtr(int32(50))},
+	}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"team": "echo"}}}
+
+	cl := newFakeClient(app, namespace)
+	ar := &appReconciler{client: cl}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(app)}
+	_, err := ar.Reconcile(ctx, req)
+	assert.Nil(t, err)
+
+	t.Run("enrolls the namespace", func(t *testing.T) {
+		got := &corev1.Namespace{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Name: "default"}, got))
+		assert.Equal(t, "osm", got.Labels[meshMonitoredByLabel])
+		assert.Equal(t, "enabled", got.Annotations[sidecarInjectionAnnotation])
+
+		app := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, req.NamespacedName, app))
+		assert.True(t, conditionIs(app, appv1alpha1.ConditionTypeMeshEnrolled, metav1.ConditionTrue))
+		assert.Contains(t, app.Status.Resources, appv1alpha1.ResourceStatus{APIVersion: "v1", Kind: "Namespace", Name: "default", Result: appv1alpha1.ApplyResultConfigured})
+	})
+
+	t.Run("reports a namespace enrolled in another mesh", func(t *testing.T) {
+		conflicting := interceptor.NewClient(cl.(client.WithWatch), interceptor.Funcs{
+			Patch: func(ctx context.Context, cl client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				if obj.GetObjectKind().GroupVersionKind().Kind == "Namespace" {
+					return apierrors.NewConflict(corev1.Resource("namespaces"), obj.GetName(), errors.New(`conflict with "osm" using v1: .metadata.labels.openservicemesh.io/monitored-by`))
+				}
+				return cl.Patch(ctx, obj, patch, opts...)
+			},
+		})
+		_, err := (&appReconciler{client: conflicting}).Reconcile(ctx, req)
+		assert.Nil(t, err)
+
+		app := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, req.NamespacedName, app))
+		assert.Equal(t, reasonMeshConflict, app.GetCondition(appv1alpha1.ConditionTypeMeshEnrolled).Reason)
+		assert.True(t, conditionIs(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionTrue), "the rest of the app is deployed")
+	})
+
+	t.Run("annotates pods for injection", func(t *testing.T) {
+		deployment := &appsv1.Deployment{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: "test-app"}, deployment))
+		assert.Equal(t, "enabled", deployment.Spec.Template.Annotations[sidecarInjectionAnnotation])
+	})
+
+	t.Run("generates policies", func(t *testing.T) {
+		key := client.ObjectKey{Namespace: "default", Name: "test-app"}
+
+		backend := &policyv1alpha1.IngressBackend{}
+		assert.Nil(t, cl.Get(ctx, key, backend))
+		assert.Equal(t, policyv1alpha1.IngressSourceSpec{Kind: policyv1alpha1.KindService, Name: "nginx", Namespace: "app-routing-system"}, backend.Spec.Sources[0])
+		assert.Equal(t, 80, backend.Spec.Backends[0].Port.Number)
+
+		egress := &policyv1alpha1.Egress{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: "test-app-github"}, egress))
+		assert.Equal(t, "default", egress.Spec.Sources[0].Name)
+		assert.Equal(t, []policyv1alpha1.PortSpec{{Number: 443, Protocol: "https"}}, egress.Spec.Ports)
+
+		retry := &policyv1alpha1.Retry{}
+		assert.Nil(t, cl.Get(ctx, key, retry))
+		assert.Equal(t, "5xx", retry.Spec.RetryPolicy.RetryOn)
+		assert.Equal(t, uint32(3), *retry.Spec.RetryPolicy.NumRetries)
+		assert.Equal(t, "Service", retry.Spec.Destinations[0].Kind)
+
+		setting := &policyv1alpha1.UpstreamTrafficSetting{}
+		assert.Nil(t, cl.Get(ctx, key, setting))
+		assert.Equal(t, "test-app.default.svc.cluster.local", setting.Spec.Host)
+		assert.Equal(t, uint32(100), setting.Spec.RateLimit.Local.HTTP.Requests)
+		assert.Equal(t, uint32(50), *setting.Spec.ConnectionSettings.TCP.MaxConnections)
+	})
+
+	t.Run("removes the namespace from the mesh with spec.mesh", func(t *testing.T) {
+		app := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, req.NamespacedName, app))
+		app.Spec.Mesh = nil
+		assert.Nil(t, cl.Update(ctx, app))
+		_, err := ar.Reconcile(ctx, req)
+		assert.Nil(t, err)
+
+		got := &corev1.Namespace{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Name: "default"}, got))
+		assert.NotContains(t, got.Labels, meshMonitoredByLabel)
+		assert.NotContains(t, got.Annotations, sidecarInjectionAnnotation)
+
+		assert.Nil(t, cl.Get(ctx, req.NamespacedName, app))
+		assert.Nil(t, app.GetCondition(appv1alpha1.ConditionTypeMeshEnrolled))
+		for _, r := range app.Status.Resources {
+			assert.NotEqual(t, "Namespace", r.Kind)
+		}
+	})
+
+	t.Run("no ingress backend for cluster ip", func(t *testing.T) {
+		clusterIP := app.DeepCopy()
+		clusterIP.Spec.Expose = &appv1alpha1.Expose{Type: appv1alpha1.ExposeTypeClusterIP}
+		clusterIP.Default()
+		backend, err := ingressBackend(clusterIP)
+		assert.Nil(t, err)
+		assert.Nil(t, backend)
+	})
+}
+
+func TestRollout(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+	app.Spec.Namespace = "default"
+	app.Spec.Image = "test.azurecr.io/go_echo:v1"
+	app.Spec.Mesh = &appv1alpha1.Mesh{}
+	app.Spec.Rollout = &appv1alpha1.Rollout{Strategy: appv1alpha1.RolloutStrategyCanary, Steps: []int32{50}}
+
+	cl := newFakeClient(app)
+	ar := &appReconciler{client: cl}
+	key := client.ObjectKeyFromObject(app)
+	reconcileApp := func(t *testing.T) *appv1alpha1.Application {
+		_, err := ar.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		assert.Nil(t, err)
+
+		got := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, key, got))
+		return got
+	}
+	updateApp := func(t *testing.T, update func(*appv1alpha1.Application)) {
+		got := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, key, got))
+		update(got)
+		assert.Nil(t, cl.Update(ctx, got))
+		assert.Nil(t, cl.Get(ctx, key, got))
+		update(got)
+		assert.Nil(t, cl.Status().Update(ctx, got))
+	}
+	// endStep moves the start of the current step past the step interval
+	endStep := func(t *testing.T) {
+		updateApp(t, func(a *appv1alpha1.Application) {
+			if a.Status.Rollout != nil && a.Status.Rollout.StepStartTime != nil {
+				a.Status.Rollout.StepStartTime = &metav1.Time{Time: time.Now().Add(-2 * time.Minute)}
+			}
+		})
+	}
+	makeAvailable := func(t *testing.T, name string) {
+		deployment := &appsv1.Deployment{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, deployment))
+		deployment.Status = appsv1.DeploymentStatus{ObservedGeneration: deployment.Generation, UpdatedReplicas: 2, AvailableReplicas: 2}
+		assert.Nil(t, cl.Status().Update(ctx, deployment))
+	}
+	deploymentImage := func(t *testing.T, name string) string {
+		deployment := &appsv1.Deployment{}
+		if err := cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, deployment); err != nil {
+			assert.True(t, apierrors.IsNotFound(err))
+			return ""
+		}
+		return deployment.Spec.Template.Spec.Containers[0].Image
+	}
+	splitWeights := func(t *testing.T) []int64 {
+		split := &unstructured.Unstructured{}
+		split.SetGroupVersionKind(trafficSplitGVK)
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: "test-app"}, split))
+		backends, _, _ := unstructured.NestedSlice(split.Object, "spec", "backends")
+		var weights []int64
+		for _, b := range backends {
+			weights = append(weights, b.(map[string]interface{})["weight"].(int64))
+		}
+		return weights
+	}
+
+	t.Run("first image is deployed directly", func(t *testing.T) {
+		got := reconcileApp(t)
+		assert.Equal(t, "test.azurecr.io/go_echo:v1", got.Status.Rollout.StableImage)
+		assert.True(t, conditionIs(got, appv1alpha1.ConditionTypeRolledOut, metav1.ConditionTrue))
+		assert.Equal(t, "test.azurecr.io/go_echo:v1", deploymentImage(t, "test-app"))
+		assert.Empty(t, deploymentImage(t, "test-app-canary"))
+		assert.Equal(t, []int64{100, 0}, splitWeights(t))
+
+		service := &corev1.Service{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: "test-app-canary"}, service))
+		assert.Equal(t, trackCanary, service.Spec.Selector[trackLabel])
+		assert.Equal(t, corev1.ServiceTypeClusterIP, service.Spec.Type)
+
+		// the TrafficSplit splits the traffic to the Service selecting the pods of both
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: "test-app"}, service))
+		assert.NotContains(t, service.Spec.Selector, trackLabel)
+	})
+
+	t.Run("new image is rolled out in steps", func(t *testing.T) {
+		updateApp(t, func(a *appv1alpha1.Application) { a.Spec.Image = "test.azurecr.io/go_echo:v2" })
+		got := reconcileApp(t)
+		assert.Equal(t, appv1alpha1.RolloutPhaseProgressing, got.Status.Rollout.Phase)
+		assert.Equal(t, appv1alpha1.ApplicationPhaseDeploying, got.Status.Phase)
+		assert.Equal(t, "test.azurecr.io/go_echo:v1", deploymentImage(t, "test-app"))
+		assert.Equal(t, "test.azurecr.io/go_echo:v2", deploymentImage(t, "test-app-canary"))
+		assert.Equal(t, []int64{100, 0}, splitWeights(t))
+
+		endStep(t)
+		got = reconcileApp(t)
+		assert.Equal(t, int32(0), got.Status.Rollout.Step, "waits for the canary to be available")
+
+		makeAvailable(t, "test-app-canary")
+		got = reconcileApp(t)
+		assert.Equal(t, int32(1), got.Status.Rollout.Step)
+		assert.Equal(t, int32(50), got.Status.Rollout.Weight)
+		assert.Equal(t, []int64{50, 50}, splitWeights(t))
+
+		got = reconcileApp(t)
+		assert.Equal(t, int32(1), got.Status.Rollout.Step, "observes each step for the step interval")
+
+		endStep(t)
+		got = reconcileApp(t)
+		assert.Equal(t, appv1alpha1.RolloutPhasePromoting, got.Status.Rollout.Phase)
+		assert.Equal(t, []int64{0, 100}, splitWeights(t))
+		assert.Equal(t, "test.azurecr.io/go_echo:v2", deploymentImage(t, "test-app"))
+
+		makeAvailable(t, "test-app")
+		got = reconcileApp(t)
+		assert.Equal(t, appv1alpha1.RolloutPhaseSucceeded, got.Status.Rollout.Phase)
+		assert.Equal(t, "test.azurecr.io/go_echo:v2", got.Status.Rollout.StableImage)
+		assert.True(t, conditionIs(got, appv1alpha1.ConditionTypeRolledOut, metav1.ConditionTrue))
+		assert.Empty(t, deploymentImage(t, "test-app-canary"))
+		assert.Equal(t, []int64{100, 0}, splitWeights(t))
+		assert.Len(t, got.Status.Rollout.History, 4)
+	})
+
+	t.Run("failed canary is rolled back", func(t *testing.T) {
+		updateApp(t, func(a *appv1alpha1.Application) { a.Spec.Image = "test.azurecr.io/go_echo:v3" })
+		reconcileApp(t)
+
+		canary := &appsv1.Deployment{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: "test-app-canary"}, canary))
+		canary.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded", Message: "image pull failed"}}
+		assert.Nil(t, cl.Status().Update(ctx, canary))
+
+		got := reconcileApp(t)
+		assert.Equal(t, appv1alpha1.RolloutPhaseAborted, got.Status.Rollout.Phase)
+		assert.Equal(t, appv1alpha1.ApplicationPhaseFailed, got.Status.Phase)
+		assert.Contains(t, got.GetCondition(appv1alpha1.ConditionTypeRolledOut).Message, "image pull failed")
+		assert.Empty(t, deploymentImage(t, "test-app-canary"))
+		assert.Equal(t, "test.azurecr.io/go_echo:v2", deploymentImage(t, "test-app"))
+
+		got = reconcileApp(t)
+		assert.Equal(t, appv1alpha1.RolloutPhaseAborted, got.Status.Rollout.Phase, "the failed image isn't rolled out again")
+	})
+
+	t.Run("gateway backends", func(t *testing.T) {
+		gatewayApp := app.DeepCopy()
+		gatewayApp.Spec.Rollout.TrafficRouting = appv1alpha1.TrafficRoutingGateway
+		gatewayApp.Status.Rollout = &appv1alpha1.RolloutStatus{Phase: appv1alpha1.RolloutPhaseProgressing, Weight: 10}
+		refs := httpBackendRefs(gatewayApp, 80)
+		assert.Equal(t, gatewayv1.ObjectName("test-app-stable"), refs[0].Name)
+		assert.Equal(t, int32(90), *refs[0].Weight)
+		assert.Equal(t, gatewayv1.ObjectName("test-app-canary"), refs[1].Name)
+		assert.Equal(t, int32(10), *refs[1].Weight)
+	})
+
+	t.Run("service selects stable pods without a TrafficSplit", func(t *testing.T) {
+		gatewayApp := app.DeepCopy()
+		gatewayApp.Spec.Rollout.TrafficRouting = appv1alpha1.TrafficRoutingGateway
+		gatewayApp.Status.Rollout = &appv1alpha1.RolloutStatus{Phase: appv1alpha1.RolloutPhaseProgressing, Weight: 10}
+		service := &corev1.Service{Spec: corev1.ServiceSpec{Selector: map[string]string{"app": "test-app"}}}
+		configureRolloutService(gatewayApp, service)
+		assert.Equal(t, map[string]string{"app": "test-app", trackLabel: trackStable}, service.Spec.Selector)
+
+		services := rolloutServices(gatewayApp, service)
+		assert.Equal(t, trackStable, services[0].Spec.Selector[trackLabel])
+		assert.Equal(t, trackCanary, services[1].Spec.Selector[trackLabel])
+	})
+}
+
+func TestRevisions(t *testing.T) {
+	ctx := context.Background()
+	app := testApp()
+	app.Spec.Namespace = "default"
+	app.Spec.Image = "test.azurecr.io/go_echo:v1"
+	app.Spec.RevisionHistoryLimit = toPtr(int32(2))
+
+	cl := newFakeClient(app)
+	ar := &appReconciler{client: cl}
+	key := client.ObjectKeyFromObject(app)
+	reconcileApp := func(t *testing.T, update func(*appv1alpha1.Application)) *appv1alpha1.Application {
+		got := &appv1alpha1.Application{}
+		assert.Nil(t, cl.Get(ctx, key, got))
+		update(got)
+		assert.Nil(t, cl.Update(ctx, got))
+
+		_, err := ar.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		assert.Nil(t, err)
+		assert.Nil(t, cl.Get(ctx, key, got))
+		return got
+	}
+	revisions := func(t *testing.T) []int64 {
+		var list appv1alpha1.ApplicationRevisionList
+		assert.Nil(t, cl.List(ctx, &list))
+		var numbers []int64
+		for _, r := range list.Items {
+			numbers = append(numbers, r.Spec.Revision)
+		}
+		sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+		return numbers
+	}
+	deploymentImage := func(t *testing.T) string {
+		deployment := &appsv1.Deployment{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: "test-app"}, deployment))
+		return deployment.Spec.Template.Spec.Containers[0].Image
+	}
+
+	t.Run("records deploys", func(t *testing.T) {
+		got := reconcileApp(t, func(*appv1alpha1.Application) {})
+		assert.Equal(t, int64(1), got.Status.Revision)
+
+		revision := &appv1alpha1.ApplicationRevision{}
+		assert.Nil(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: "test-app-1"}, revision))
+		assert.Equal(t, "test.azurecr.io/go_echo:v1", revision.Spec.Image)
+		assert.Equal(t, "test-app", revision.Labels[appv1alpha1.RevisionApplicationLabel])
+		assert.Equal(t, "test-app", revision.OwnerReferences[0].Name)
+		objs, err := decodeManifests(revision.Spec.Manifests)
+		assert.Nil(t, err)
+		assert.Len(t, objs, 2)
+
+		reconcileApp(t, func(*appv1alpha1.Application) {})
+		assert.Equal(t, []int64{1}, revisions(t), "unchanged manifests aren't recorded again")
+	})
+
+	t.Run("keeps the history limit", func(t *testing.T) {
+		got := reconcileApp(t, func(a *appv1alpha1.Application) { a.Spec.Image = "test.azurecr.io/go_echo:v2" })
+		assert.Equal(t, int64(2), got.Status.Revision)
+		got = reconcileApp(t, func(a *appv1alpha1.Application) { a.Spec.Image = "test.azurecr.io/go_echo:v3" })
+		assert.Equal(t, int64(3), got.Status.Revision)
+		assert.Equal(t, []int64{2, 3}, revisions(t))
+	})
+
+	t.Run("rolls back", func(t *testing.T) {
+		got := reconcileApp(t, func(a *appv1alpha1.Application) { a.Spec.RollbackTo = toPtr(int64(2)) })
+		assert.Equal(t, int64(2), got.Status.Revision)
+		assert.Equal(t, "test.azurecr.io/go_echo:v2", got.Status.Image)
+		assert.Equal(t, "test.azurecr.io/go_echo:v2", deploymentImage(t))
+		assert.Equal(t, reasonRolledBack, got.GetCondition(appv1alpha1.ConditionTypeDeployed).Reason)
+		assert.Equal(t, []int64{2, 3}, revisions(t))
+
+		got = reconcileApp(t, func(a *appv1alpha1.Application) { a.Spec.RollbackTo = toPtr(int64(1)) })
+		assert.Equal(t, reasonRevisionNotFound, got.GetCondition(appv1alpha1.ConditionTypeDeployed).Reason)
+		assert.Equal(t, appv1alpha1.ApplicationPhaseFailed, got.Status.Phase)
+
+		got = reconcileApp(t, func(a *appv1alpha1.Application) { a.Spec.RollbackTo = nil })
+		assert.Equal(t, "test.azurecr.io/go_echo:v3", deploymentImage(t))
+		assert.Equal(t, int64(3), got.Status.Revision, "the latest revision is deployed again")
+	})
+
+	t.Run("revision missing from the cache", func(t *testing.T) {
+		// unlabeled so listing doesn't return it, like a revision created by a reconcile the cache hasn't seen
+		assert.Nil(t, cl.Create(ctx, &appv1alpha1.ApplicationRevision{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-app-4", Namespace: "default"},
+			Spec:       appv1alpha1.ApplicationRevisionSpec{ApplicationName: "test-app", Revision: 4},
+		}))
+
+		got := reconcileApp(t, func(a *appv1alpha1.Application) { a.Spec.Image = "test.azurecr.io/go_echo:v4" })
+		assert.Equal(t, int64(4), got.Status.Revision)
+		assert.Equal(t, "test.azurecr.io/go_echo:v4", deploymentImage(t))
 	})
 }
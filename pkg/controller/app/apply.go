@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// fieldManager is the server-side apply field manager used for every object the controller applies
+const fieldManager = "aks-app-controller"
+
+// toUnstructured converts a typed object decoded from a rendered template into an unstructured object.
+// Converting from the typed object drops any fields the template emits that are not part of the schema,
+// which server-side apply would otherwise reject.
+func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, fmt.Errorf("converting to unstructured: %w", err)
+	}
+
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+	// these are set by the api server and must not be part of an apply configuration
+	unstructured.RemoveNestedField(u.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u.Object, "status")
+	return u, nil
+}
+
+// applyObject server-side applies obj with opts and reports whether it was created, changed or left as is
+func applyObject(ctx context.Context, cl client.Client, obj *unstructured.Unstructured, opts ...client.PatchOption) (appv1alpha1.ApplyResult, error) {
+	current := &unstructured.Unstructured{}
+	current.SetGroupVersionKind(obj.GroupVersionKind())
+	err := cl.Get(ctx, client.ObjectKeyFromObject(obj), current)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return appv1alpha1.ApplyResultFailed, fmt.Errorf("getting current object: %w", err)
+	}
+	exists := err == nil
+
+	if err := cl.Patch(ctx, obj, client.Apply, opts...); err != nil {
+		return appv1alpha1.ApplyResultFailed, fmt.Errorf("applying object: %w", err)
+	}
+
+	switch {
+	case !exists:
+		return appv1alpha1.ApplyResultCreated, nil
+	case current.GetResourceVersion() == obj.GetResourceVersion():
+		return appv1alpha1.ApplyResultUnchanged, nil
+	default:
+		return appv1alpha1.ApplyResultConfigured, nil
+	}
+}
+
+// applyObjects applies every object and returns the per-object results. Failing to apply one object
+// does not stop the others from being applied, the failures are joined into the returned error.
+func applyObjects(ctx context.Context, cl client.Client, objs []*unstructured.Unstructured) ([]appv1alpha1.ResourceStatus, error) {
+	lgr := log.FromContext(ctx)
+
+	var errs []error
+	statuses := make([]appv1alpha1.ResourceStatus, 0, len(objs))
+	for _, obj := range objs {
+		lgr := lgr.WithValues("kind", obj.GetKind(), "namespace", obj.GetNamespace(), "name", obj.GetName())
+		status := appv1alpha1.ResourceStatus{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+		}
+
+		result, err := applyObject(ctx, cl, obj, client.FieldOwner(fieldManager), client.ForceOwnership)
+		status.Result = result
+		if err != nil {
+			lgr.Error(err, "unable to apply object")
+			status.Message = err.Error()
+			errs = append(errs, fmt.Errorf("%s %s/%s: %w", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err))
+		} else {
+			lgr.Info("applied object", "result", result)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, errors.Join(errs...)
+}
@@ -0,0 +1,254 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"github.com/bfoley13/appcontroller/pkg/build"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// buildPollInterval is how long to wait before checking on a build that is still running
+const buildPollInterval = 10 * time.Second
+
+// buildInputHash hashes everything that affects the image built for app at commit, version is the semver tag of
+// commit. A new build is only needed when the hash differs from the one recorded for the last successful build.
+func buildInputHash(app *appv1alpha1.Application, commit, version string) string {
+	h := sha256.New()
+	var registry string
+	if app.Spec.Acr != nil {
+		registry = app.Spec.Acr.Id
+	}
+	if app.Spec.Build != nil && app.Spec.Build.Registry != "" {
+		registry = app.Spec.Build.Registry
+	}
+
+	for _, input := range []string{
+		commit,
+		string(app.GetBuildStrategy()),
+		registry,
+		app.Spec.DockerConfig.Dockerfile,
+		app.Spec.DockerConfig.BuildContext,
+		app.Spec.DockerConfig.ImageName,
+		app.Spec.DockerConfig.ImageTag,
+		string(app.GetTagStrategy()),
+		version,
+	} {
+		h.Write([]byte(input))
+		// separate inputs so moving characters between them changes the hash
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// needsBuild returns true if neither the last successful build nor the latest run of app match inputHash.
+// A failed run isn't retried until the inputs change.
+func needsBuild(app *appv1alpha1.Application, inputHash string) bool {
+	if app.Status.Run != nil && app.Status.Run.InputHash == inputHash {
+		return false
+	}
+
+	return app.Status.Build == nil || app.Status.Build.InputHash != inputHash
+}
+
+// builderFor returns the Builder for the given strategy
+func (ar *appReconciler) builderFor(strategy appv1alpha1.BuildStrategy) (build.Builder, error) {
+	if strategy == "" {
+		strategy = appv1alpha1.BuildStrategyACR
+	}
+
+	builder, ok := ar.builders[strategy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported build strategy %q", strategy)
+	}
+
+	return builder, nil
+}
+
+// reconcileBuild makes sure an image is built for the current source of app. Builds run asynchronously, their ID
+// is recorded in the status and a requeue is returned until they finish. Once a run succeeds the built image is
+// recorded as the Application's build.
+func (ar *appReconciler) reconcileBuild(ctx context.Context, app *appv1alpha1.Application) (ctrl.Result, error) {
+	lgr := log.FromContext(ctx)
+
+	if run := app.Status.Run; run != nil && run.State == appv1alpha1.RunStateRunning {
+		lgr := lgr.WithValues("runID", run.ID, "strategy", run.Strategy)
+		builder, err := ar.builderFor(run.Strategy)
+		if err != nil {
+			lgr.Error(err, "unable to get builder for run")
+			return ctrl.Result{}, err
+		}
+
+		result, err := builder.Status(ctx, app, run.ID)
+		if err != nil {
+			lgr.Error(err, "unable to get build status")
+			return ctrl.Result{}, err
+		}
+
+		switch result.State {
+		case appv1alpha1.RunStateRunning:
+			lgr.Info("build still running")
+			return ctrl.Result{RequeueAfter: buildPollInterval}, nil
+		case appv1alpha1.RunStateFailed:
+			run.State = result.State
+			run.Message = result.Message
+			run.CompletionTime = toPtr(metav1.Now())
+			lgr.Info("build failed", "message", run.Message)
+			ar.recordBuildLog(ctx, app, builder, run)
+			setCondition(app, appv1alpha1.ConditionTypeBuildSucceeded, metav1.ConditionFalse, reasonBuildFailed, run.Message)
+			ar.events.Event(app, corev1.EventTypeWarning, reasonBuildFailed, buildFailedMessage(run))
+			ar.reportBuild(ctx, app, run)
+			return ctrl.Result{}, nil
+		}
+
+		lgr.Info("build succeeded")
+		run.State = result.State
+		run.Message = ""
+		run.CompletionTime = toPtr(metav1.Now())
+		app.Status.Build = &appv1alpha1.BuildStatus{
+			Commit:      run.Commit,
+			InputHash:   run.InputHash,
+			Image:       result.Image,
+			ImageDigest: result.Digest,
+		}
+		ar.recordBuildLog(ctx, app, builder, run)
+		setCondition(app, appv1alpha1.ConditionTypeBuildSucceeded, metav1.ConditionTrue, reasonBuildSucceeded, fmt.Sprintf("built %s from commit %s", app.Status.Build.Image, run.Commit))
+		ar.events.Eventf(app, corev1.EventTypeNormal, reasonBuildSucceeded, "built %s from commit %s", app.Status.Build.Image, run.Commit)
+		ar.reportBuild(ctx, app, run)
+	}
+
+	repo := app.Spec.Repository
+	provider, creds, err := ar.providers.For(ctx, app.Namespace, repo)
+	if err != nil {
+		lgr.Error(err, "unable to get repository credentials")
+		setCondition(app, appv1alpha1.ConditionTypeSourceReady, metav1.ConditionFalse, reasonCredentialsFailed, err.Error())
+		return ctrl.Result{}, err
+	}
+
+	// a pushed commit is built as is, the branch head the api reports can lag behind the push
+	commit, pushed := ar.pushes.TakeCommit(client.ObjectKeyFromObject(app))
+	if !pushed {
+		commit, err = provider.ResolveRef(ctx, repo.Owner, repo.Name, repo.BranchName)
+		if err != nil {
+			lgr.Error(err, "unable to resolve source commit")
+			setCondition(app, appv1alpha1.ConditionTypeSourceReady, metav1.ConditionFalse, reasonResolveFailed, err.Error())
+			return ctrl.Result{}, err
+		}
+	}
+	setCondition(app, appv1alpha1.ConditionTypeSourceReady, metav1.ConditionTrue, reasonResolved, fmt.Sprintf("branch %s is at commit %s", repo.BranchName, commit))
+
+	// the version is part of the build inputs, tagging a built commit with a new version builds it again
+	var version string
+	if app.GetTagStrategy() == appv1alpha1.TagStrategySemver {
+		version, err = semverTag(ctx, ar.tags, provider, repo, commit)
+		if err != nil {
+			lgr.Error(err, "unable to resolve version")
+			setCondition(app, appv1alpha1.ConditionTypeSourceReady, metav1.ConditionFalse, reasonResolveFailed, err.Error())
+			return ctrl.Result{}, err
+		}
+		if version == "" {
+			lgr.Info("commit has no version tag, waiting for one", "commit", commit)
+			setCondition(app, appv1alpha1.ConditionTypeBuildSucceeded, metav1.ConditionUnknown, reasonWaitingForTag, fmt.Sprintf("commit %s has no semantic version tag", commit))
+			return ctrl.Result{}, nil
+		}
+	}
+
+	inputHash := buildInputHash(app, commit, version)
+	if !needsBuild(app, inputHash) {
+		lgr.Info("source and build configuration unchanged, skipping build", "commit", commit)
+		if app.Status.Build != nil && app.Status.Build.InputHash == inputHash {
+			setCondition(app, appv1alpha1.ConditionTypeBuildSucceeded, metav1.ConditionTrue, reasonBuildSucceeded, fmt.Sprintf("built %s from commit %s", app.Status.Build.Image, commit))
+		}
+		return ctrl.Result{}, nil
+	}
+
+	ready, err := ar.reconcileDockerfile(ctx, app, provider, commit)
+	if err != nil {
+		lgr.Error(err, "unable to reconcile dockerfile")
+		return ctrl.Result{}, err
+	}
+	if !ready {
+		lgr.Info("waiting for dockerfile", "pullRequest", app.Status.Dockerfile.PullRequestURL)
+		return ctrl.Result{RequeueAfter: dockerfilePollInterval}, nil
+	}
+
+	strategy := app.GetBuildStrategy()
+	builder, err := ar.builderFor(strategy)
+	if err != nil {
+		lgr.Error(err, "unable to get builder")
+		setCondition(app, appv1alpha1.ConditionTypeBuildSucceeded, metav1.ConditionFalse, reasonScheduleFailed, err.Error())
+		return ctrl.Result{}, err
+	}
+
+	lgr.Info("scheduling build", "commit", commit, "strategy", strategy)
+	runID, err := builder.Start(ctx, app, build.Source{
+		URL:         provider.SourceURL(repo.Owner, repo.Name, commit, app.Spec.DockerConfig.BuildContext),
+		Branch:      repo.BranchName,
+		Commit:      commit,
+		Tag:         imageTag(app, commit, version, time.Now()),
+		Credentials: creds,
+	})
+	if err != nil {
+		lgr.Error(err, "unable to schedule build")
+		setCondition(app, appv1alpha1.ConditionTypeBuildSucceeded, metav1.ConditionFalse, reasonScheduleFailed, err.Error())
+		return ctrl.Result{}, err
+	}
+
+	lgr.Info("scheduled build", "runID", runID)
+	app.Status.Run = &appv1alpha1.RunStatus{
+		ID:        runID,
+		Strategy:  strategy,
+		State:     appv1alpha1.RunStateRunning,
+		Commit:    commit,
+		InputHash: inputHash,
+		StartTime: toPtr(metav1.Now()),
+	}
+	setCondition(app, appv1alpha1.ConditionTypeBuildSucceeded, metav1.ConditionUnknown, reasonBuilding, fmt.Sprintf("building commit %s in run %s", commit, runID))
+	ar.events.Eventf(app, corev1.EventTypeNormal, reasonBuilding, "building commit %s in run %s", commit, runID)
+	ar.reportBuild(ctx, app, app.Status.Run)
+
+	return ctrl.Result{RequeueAfter: buildPollInterval}, nil
+}
+
+// usePrebuiltImage deploys spec.image as is, nothing is built for the Application
+func usePrebuiltImage(app *appv1alpha1.Application) {
+	app.Status.Image = app.Spec.Image
+	app.Status.ImageDigest = imageDigest(app.Spec.Image)
+	app.Status.Commit = ""
+	app.Status.Run = nil
+	meta.RemoveStatusCondition(&app.Status.Conditions, appv1alpha1.ConditionTypeBuildSucceeded)
+	setCondition(app, appv1alpha1.ConditionTypeSourceReady, metav1.ConditionTrue, reasonPrebuiltImage, fmt.Sprintf("deploying prebuilt image %s", app.Spec.Image))
+}
+
+// imageDigest returns the digest of an image reference, empty if it references a tag
+func imageDigest(ref string) string {
+	_, digest, _ := strings.Cut(ref, "@")
+	return digest
+}
+
+// splitImageReference splits an image reference into the name and tag used by the deployment template, which
+// joins them with a colon. A digest is kept on the tag, the tag is ignored when pulling an image by digest.
+func splitImageReference(ref string) (string, string) {
+	ref, digest, hasDigest := strings.Cut(ref, "@")
+	name, tag := ref, "latest"
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, tag = ref[:i], ref[i+1:]
+	}
+
+	if hasDigest {
+		tag = tag + "@" + digest
+	}
+
+	return name, tag
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"github.com/bfoley13/appcontroller/pkg/build"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// buildLogKey is the key of the build log ConfigMap the log is stored in
+	buildLogKey = "build.log"
+	// buildRunAnnotation records the run the build log ConfigMap holds the log of
+	buildRunAnnotation = "appcontroller.azure.com/run-id"
+	// maxBuildLogSize keeps the build log ConfigMap well below the size limit of objects
+	maxBuildLogSize = 512 * 1024
+	// logTailLines and maxLogTailSize bound the log tail kept in the run status
+	logTailLines   = 20
+	maxLogTailSize = 2048
+)
+
+// buildLogConfigMapName is the ConfigMap the log of the last completed build of app is stored in
+func buildLogConfigMapName(app *appv1alpha1.Application) string {
+	return app.Name + "-build-log"
+}
+
+// logTail returns the last lines of log, at most maxLogTailSize bytes of them
+func logTail(log []byte) string {
+	lines := strings.Split(strings.TrimRight(string(log), "\n"), "\n")
+	if len(lines) > logTailLines {
+		lines = lines[len(lines)-logTailLines:]
+	}
+	tail := strings.Join(lines, "\n")
+	if len(tail) > maxLogTailSize {
+		tail = tail[len(tail)-maxLogTailSize:]
+	}
+	return strings.ToValidUTF8(tail, "")
+}
+
+// recordBuildLog stores the log of the completed run in the build log ConfigMap of app, replacing the log of the
+// previous run, and its tail in the run status. Builders that don't keep logs are skipped. Recording is best
+// effort, a failure is logged and doesn't fail the build.
+func (ar *appReconciler) recordBuildLog(ctx context.Context, app *appv1alpha1.Application, builder build.Builder, run *appv1alpha1.RunStatus) {
+	lgr := log.FromContext(ctx).WithValues("runID", run.ID)
+	reader, ok := builder.(build.LogReader)
+	if !ok {
+		return
+	}
+
+	buildLog, err := reader.Logs(ctx, app, run.ID, maxBuildLogSize)
+	if err != nil {
+		lgr.Error(err, "unable to get build log")
+		return
+	}
+	run.LogTail = logTail(buildLog)
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        buildLogConfigMapName(app),
+			Namespace:   app.Namespace,
+			Annotations: map[string]string{buildRunAnnotation: run.ID},
+		},
+		Data: map[string]string{buildLogKey: strings.ToValidUTF8(string(buildLog), "")},
+	}
+	if err := controllerutil.SetControllerReference(app, cm, ar.client.Scheme()); err != nil {
+		lgr.Error(err, "unable to set build log ownership")
+		return
+	}
+
+	err = ar.client.Create(ctx, cm)
+	if apierrors.IsAlreadyExists(err) {
+		err = ar.client.Update(ctx, cm)
+	}
+	if err != nil {
+		lgr.Error(err, "unable to store build log")
+		return
+	}
+	run.LogConfigMap = cm.Name
+}
+
+// buildFailedMessage is the event message of the failed run, the message of the run followed by the end of its log
+func buildFailedMessage(run *appv1alpha1.RunStatus) string {
+	if run.LogTail == "" {
+		return run.Message
+	}
+
+	lines := strings.Split(run.LogTail, "\n")
+	if len(lines) > 5 {
+		lines = lines[len(lines)-5:]
+	}
+	return fmt.Sprintf("%s, log ends with:\n%s", run.Message, strings.Join(lines, "\n"))
+}
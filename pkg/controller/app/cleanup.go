@@ -0,0 +1,170 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// cleanupFinalizer blocks deletion of an Application until the objects it rendered into other namespaces,
+	// which can't be garbage collected through owner references, are deleted
+	cleanupFinalizer = "devx.kubernetes.azure.com/cleanup"
+
+	// appNameLabel and appNamespaceLabel identify the Application that rendered an object
+	appNameLabel      = "devx.kubernetes.azure.com/app-name"
+	appNamespaceLabel = "devx.kubernetes.azure.com/app-namespace"
+)
+
+// setOwnership labels obj as rendered by app. Objects in the Application's own namespace also get a controller
+// owner reference so they are garbage collected with it, owner references can't cross namespaces.
+func setOwnership(app *appv1alpha1.Application, obj *unstructured.Unstructured, s *runtime.Scheme) error {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[appNameLabel] = app.Name
+	labels[appNamespaceLabel] = app.Namespace
+	obj.SetLabels(labels)
+
+	if obj.GetNamespace() != app.Namespace {
+		return nil
+	}
+
+	if err := controllerutil.SetControllerReference(app, obj, s); err != nil {
+		return fmt.Errorf("setting controller reference: %w", err)
+	}
+
+	return nil
+}
+
+// isRenderedBy returns true if obj carries the labels set by setOwnership for app
+func isRenderedBy(app *appv1alpha1.Application, obj client.Object) bool {
+	labels := obj.GetLabels()
+	return labels[appNameLabel] == app.Name && labels[appNamespaceLabel] == app.Namespace
+}
+
+// enqueueRenderingApp maps an object to the Application that rendered it by the labels set by setOwnership. Owner
+// references only exist in the Application's namespace, the labels are set in every namespace.
+func enqueueRenderingApp(_ context.Context, obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	name, namespace := labels[appNameLabel], labels[appNamespaceLabel]
+	if name == "" || namespace == "" {
+		return nil
+	}
+
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: namespace, Name: name}}}
+}
+
+func resourceKey(r appv1alpha1.ResourceStatus) string {
+	return fmt.Sprintf("%s/%s/%s/%s", r.APIVersion, r.Kind, r.Namespace, r.Name)
+}
+
+// staleResources returns the resources from previous that are not in current
+func staleResources(previous, current []appv1alpha1.ResourceStatus) []appv1alpha1.ResourceStatus {
+	keep := make(map[string]struct{}, len(current))
+	for _, r := range current {
+		keep[resourceKey(r)] = struct{}{}
+	}
+
+	var stale []appv1alpha1.ResourceStatus
+	for _, r := range previous {
+		if _, ok := keep[resourceKey(r)]; !ok {
+			stale = append(stale, r)
+		}
+	}
+
+	return stale
+}
+
+// deleteResource deletes the object described by r if it was rendered by app. The target namespace is shared, it is
+// only removed from the mesh.
+func deleteResource(ctx context.Context, cl client.Client, app *appv1alpha1.Application, r appv1alpha1.ResourceStatus) error {
+	if r.APIVersion == "v1" && r.Kind == "Namespace" {
+		return unenrollNamespace(ctx, cl, app, r.Name)
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(r.APIVersion)
+	obj.SetKind(r.Kind)
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: r.Name}, obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	// the object may have been taken over by something else since it was rendered
+	if !isRenderedBy(app, obj) {
+		return nil
+	}
+
+	if err := cl.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// pruneResources deletes the given resources. Resources that fail to delete are returned so they stay in the
+// Application status and are retried on the next reconcile.
+func pruneResources(ctx context.Context, cl client.Client, app *appv1alpha1.Application, stale []appv1alpha1.ResourceStatus) ([]appv1alpha1.ResourceStatus, error) {
+	lgr := log.FromContext(ctx)
+
+	var errs []error
+	var remaining []appv1alpha1.ResourceStatus
+	for _, r := range stale {
+		lgr := lgr.WithValues("kind", r.Kind, "namespace", r.Namespace, "name", r.Name)
+		if err := deleteResource(ctx, cl, app, r); err != nil {
+			lgr.Error(err, "unable to prune object")
+			r.Result = appv1alpha1.ApplyResultFailed
+			r.Message = fmt.Sprintf("pruning object: %s", err.Error())
+			remaining = append(remaining, r)
+			errs = append(errs, fmt.Errorf("pruning %s %s/%s: %w", r.Kind, r.Namespace, r.Name, err))
+			continue
+		}
+
+		lgr.Info("pruned object")
+	}
+
+	return remaining, errors.Join(errs...)
+}
+
+// finalize deletes the objects app rendered into other namespaces then removes the cleanup finalizer.
+// Objects in the Application's namespace are garbage collected through their owner reference.
+func (ar *appReconciler) finalize(ctx context.Context, app *appv1alpha1.Application) (ctrl.Result, error) {
+	lgr := log.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(app, cleanupFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	lgr.Info("cleaning up app resources")
+	var crossNamespace []appv1alpha1.ResourceStatus
+	for _, r := range app.Status.Resources {
+		if r.Namespace != app.Namespace {
+			crossNamespace = append(crossNamespace, r)
+		}
+	}
+
+	if _, err := pruneResources(ctx, ar.client, app, crossNamespace); err != nil {
+		lgr.Error(err, "unable to clean up app resources")
+		return ctrl.Result{}, err
+	}
+
+	controllerutil.RemoveFinalizer(app, cleanupFinalizer)
+	if err := ar.client.Update(ctx, app); err != nil {
+		lgr.Error(err, "unable to remove finalizer")
+		return ctrl.Result{}, err
+	}
+
+	lgr.Info("app resources cleaned up")
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,229 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// configHashAnnotation is set on the pod template to a hash of the ConfigMaps and Secrets the Application
+// references, changing their data changes the pod template and rolls the Deployment
+const configHashAnnotation = "devx.kubernetes.azure.com/config-hash"
+
+// configRef identifies a ConfigMap or Secret referenced by an Application
+type configRef struct {
+	Kind string
+	Name string
+}
+
+// configRefs returns the ConfigMaps and Secrets referenced by the env, envFrom and files of app, sorted and
+// without duplicates
+func configRefs(app *appv1alpha1.Application) []configRef {
+	seen := map[configRef]struct{}{}
+	add := func(kind, name string) {
+		if name != "" {
+			seen[configRef{Kind: kind, Name: name}] = struct{}{}
+		}
+	}
+
+	for _, env := range app.Spec.Env {
+		if env.ValueFrom == nil {
+			continue
+		}
+		if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+			add("ConfigMap", ref.Name)
+		}
+		if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+			add("Secret", ref.Name)
+		}
+	}
+	for _, envFrom := range app.Spec.EnvFrom {
+		if envFrom.ConfigMapRef != nil {
+			add("ConfigMap", envFrom.ConfigMapRef.Name)
+		}
+		if envFrom.SecretRef != nil {
+			add("Secret", envFrom.SecretRef.Name)
+		}
+	}
+	for _, f := range app.Spec.Files {
+		add("ConfigMap", f.ConfigMapName)
+		add("Secret", f.SecretName)
+	}
+
+	// the Key Vault secret is synced from the mounted objects, hashing it would roll the pods that created it
+	if kv := app.Spec.KeyVault; kv != nil {
+		delete(seen, configRef{Kind: "Secret", Name: kv.SecretName})
+	}
+
+	refs := make([]configRef, 0, len(seen))
+	for ref := range seen {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Kind != refs[j].Kind {
+			return refs[i].Kind < refs[j].Kind
+		}
+		return refs[i].Name < refs[j].Name
+	})
+
+	return refs
+}
+
+// configHash hashes the data of the ConfigMaps and Secrets app references in its target namespace. A missing
+// object is hashed as missing, the pods wait for it and are rolled once it is created.
+func (ar *appReconciler) configHash(ctx context.Context, app *appv1alpha1.Application) (string, error) {
+	h := sha256.New()
+	for _, ref := range configRefs(app) {
+		key := client.ObjectKey{Namespace: app.Spec.Namespace, Name: ref.Name}
+
+		var data any
+		switch ref.Kind {
+		case "ConfigMap":
+			cm := &corev1.ConfigMap{}
+			if err := ar.client.Get(ctx, key, cm); err != nil && !apierrors.IsNotFound(err) {
+				return "", fmt.Errorf("getting configmap %s: %w", key, err)
+			} else if err == nil {
+				data = []any{cm.Data, cm.BinaryData}
+			}
+		case "Secret":
+			secret := &corev1.Secret{}
+			if err := ar.client.Get(ctx, key, secret); err != nil && !apierrors.IsNotFound(err) {
+				return "", fmt.Errorf("getting secret %s: %w", key, err)
+			} else if err == nil {
+				data = secret.Data
+			}
+		}
+
+		// json sorts map keys so equal data always hashes the same
+		b, err := json.Marshal(data)
+		if err != nil {
+			return "", fmt.Errorf("marshalling %s %s: %w", ref.Kind, key, err)
+		}
+		h.Write([]byte(ref.Kind + "/" + ref.Name))
+		h.Write([]byte{0})
+		h.Write(b)
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileMountVolume returns the name of the pod volume for the i-th file mount
+func fileMountVolume(i int) string {
+	return fmt.Sprintf("app-files-%d", i)
+}
+
+// configureDeployment adds the env, envFrom, files and Key Vault objects of app to the application container of
+// the rendered deployment and annotates its pod template for sidecar injection and with the config hash
+func (ar *appReconciler) configureDeployment(ctx context.Context, app *appv1alpha1.Application, deployment *appsv1.Deployment) error {
+	lgr := log.FromContext(ctx)
+	podSpec := &deployment.Spec.Template.Spec
+	if len(podSpec.Containers) == 0 {
+		return fmt.Errorf("deployment %s has no containers", deployment.Name)
+	}
+
+	// the template renders the application as the only container
+	container := &podSpec.Containers[0]
+	container.Env = append(container.Env, app.Spec.Env...)
+	container.EnvFrom = append(container.EnvFrom, app.Spec.EnvFrom...)
+	for i, f := range app.Spec.Files {
+		volume := corev1.Volume{Name: fileMountVolume(i)}
+		if f.ConfigMapName != "" {
+			volume.ConfigMap = &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: f.ConfigMapName}}
+		} else {
+			volume.Secret = &corev1.SecretVolumeSource{SecretName: f.SecretName}
+		}
+
+		podSpec.Volumes = append(podSpec.Volumes, volume)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      volume.Name,
+			MountPath: f.MountPath,
+			ReadOnly:  true,
+		})
+	}
+
+	configureKeyVault(app, deployment)
+	configureMesh(app, deployment)
+
+	if len(configRefs(app)) == 0 {
+		return nil
+	}
+
+	hash, err := ar.configHash(ctx, app)
+	if err != nil {
+		lgr.Error(err, "unable to hash referenced config")
+		return err
+	}
+
+	if deployment.Spec.Template.Annotations == nil {
+		deployment.Spec.Template.Annotations = map[string]string{}
+	}
+	deployment.Spec.Template.Annotations[configHashAnnotation] = hash
+
+	return nil
+}
+
+// referencedObjectsIndex indexes Applications by the ConfigMaps and Secrets they read, see referencedObjectKey
+const referencedObjectsIndex = "spec.referencedObjects"
+
+// referencedObjectKey is the referencedObjectsIndex key of the ConfigMap or Secret namespace/name
+func referencedObjectKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// referencedObjects returns the referencedObjectsIndex keys of an Application: the config it references in its
+// target namespace and the repository credentials Secrets in its own namespace
+func referencedObjects(obj client.Object) []string {
+	app, ok := obj.(*appv1alpha1.Application)
+	if !ok {
+		return nil
+	}
+
+	var keys []string
+	for _, ref := range configRefs(app) {
+		keys = append(keys, referencedObjectKey(ref.Kind, app.Spec.Namespace, ref.Name))
+	}
+	repos := []*appv1alpha1.Repository{app.Spec.Repository}
+	if app.Spec.Delivery != nil && app.Spec.Delivery.GitOps != nil {
+		repos = append(repos, &app.Spec.Delivery.GitOps.Repository)
+	}
+	for _, repo := range repos {
+		if repo != nil && repo.CredentialsSecretName != "" {
+			keys = append(keys, referencedObjectKey("Secret", app.Namespace, repo.CredentialsSecretName))
+		}
+	}
+
+	return keys
+}
+
+// enqueueReferencingApps maps a ConfigMap or Secret to the Applications that reference it, so a change to its
+// data updates their config hash and a build waiting for missing repository credentials starts once they are
+// created
+func (ar *appReconciler) enqueueReferencingApps(kind string) func(context.Context, client.Object) []reconcile.Request {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		var apps appv1alpha1.ApplicationList
+		key := referencedObjectKey(kind, obj.GetNamespace(), obj.GetName())
+		if err := ar.client.List(ctx, &apps, client.MatchingFields{referencedObjectsIndex: key}); err != nil {
+			log.FromContext(ctx).Error(err, "unable to list apps referencing object", "kind", kind, "name", obj.GetName())
+			return nil
+		}
+
+		reqs := make([]reconcile.Request, 0, len(apps.Items))
+		for _, app := range apps.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&app)})
+		}
+
+		return reqs
+	}
+}
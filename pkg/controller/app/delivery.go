@@ -0,0 +1,122 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// manifestsFile is the file in spec.delivery.gitops.path the manifests are written to
+const manifestsFile = "manifests.yaml"
+
+// fileCommitter is implemented by the providers that commit many files at once, committing manifests needs to
+// replace the manifests of the previous commit
+type fileCommitter interface {
+	CommitFiles(ctx context.Context, owner, repo, branch, message string, files map[string][]byte) (string, error)
+}
+
+// manifestsBranch is the branch a change to the manifests of app is proposed from, every change gets its own
+func manifestsBranch(app *appv1alpha1.Application, hash string) string {
+	return fmt.Sprintf("appcontroller/%s-manifests-%s", app.Name, hash[:8])
+}
+
+// commitManifests commits objs to the config repository of app instead of applying them. The manifests are only
+// committed again when they change.
+func (ar *appReconciler) commitManifests(ctx context.Context, app *appv1alpha1.Application, objs []*unstructured.Unstructured) error {
+	gitops := app.Spec.Delivery.GitOps
+	repo := &gitops.Repository
+	lgr := log.FromContext(ctx).WithValues("owner", repo.Owner, "repo", repo.Name)
+
+	// the GitOps agent owns the objects, uids of this cluster mean nothing to it
+	for _, obj := range objs {
+		obj.SetOwnerReferences(nil)
+	}
+	manifests, err := encodeManifests(objs)
+	if err != nil {
+		setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionFalse, reasonCommitFailed, err.Error())
+		return fmt.Errorf("encoding manifests: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(manifests))
+	hash := hex.EncodeToString(sum[:])
+	filePath := path.Join(gitops.Path, manifestsFile)
+	if status := app.Status.Delivery; status != nil && status.ManifestsHash == hash && status.Path == filePath {
+		return nil
+	}
+
+	provider, _, err := ar.providers.For(ctx, app.Namespace, repo)
+	if err != nil {
+		setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionFalse, reasonCredentialsFailed, err.Error())
+		return fmt.Errorf("getting config repository provider: %w", err)
+	}
+	committer, ok := provider.(fileCommitter)
+	if !ok {
+		err := fmt.Errorf("%s repositories can't be committed to", repo.GetProvider())
+		setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionFalse, reasonCommitFailed, err.Error())
+		return err
+	}
+
+	branch := repo.BranchName
+	if gitops.PullRequest {
+		branch = manifestsBranch(app, hash)
+		if finder, ok := provider.(pullRequestFinder); ok {
+			url, err := finder.PullRequestURL(ctx, repo.Owner, repo.Name, branch, repo.BranchName)
+			if err != nil {
+				setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionFalse, reasonCommitFailed, err.Error())
+				return fmt.Errorf("looking up manifests pull request: %w", err)
+			}
+			if url != "" {
+				commit, err := provider.ResolveRef(ctx, repo.Owner, repo.Name, branch)
+				if err != nil {
+					setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionFalse, reasonCommitFailed, err.Error())
+					return fmt.Errorf("resolving manifests branch: %w", err)
+				}
+				lgr.Info("manifests already proposed", "pullRequest", url)
+
+				app.Status.Delivery = &appv1alpha1.DeliveryStatus{Commit: commit, Path: filePath, ManifestsHash: hash, PullRequestURL: url}
+				setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionTrue, reasonPullRequestOpened, fmt.Sprintf("proposed %d objects in pull request %s", len(objs), url))
+				return nil
+			}
+		}
+
+		// the branch of a failed attempt already exists, committing to it fails if it doesn't
+		if err := provider.CreateBranch(ctx, repo.Owner, repo.Name, branch, repo.BranchName); err != nil {
+			lgr.Info("unable to create manifests branch, committing to it anyway", "branch", branch, "reason", err.Error())
+		}
+	}
+
+	message := fmt.Sprintf("Deploy %s/%s image %s", app.Namespace, app.Name, app.Status.Image)
+	commit, err := committer.CommitFiles(ctx, repo.Owner, repo.Name, branch, message, map[string][]byte{filePath: []byte(manifests)})
+	if err != nil {
+		setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionFalse, reasonCommitFailed, err.Error())
+		return fmt.Errorf("committing manifests: %w", err)
+	}
+	lgr.Info("committed manifests", "branch", branch, "commit", commit)
+
+	status := &appv1alpha1.DeliveryStatus{Commit: commit, Path: filePath, ManifestsHash: hash}
+	if !gitops.PullRequest {
+		app.Status.Delivery = status
+		setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionTrue, reasonCommitted, fmt.Sprintf("committed %d objects to %s/%s@%s", len(objs), repo.Owner, repo.Name, commit))
+		return nil
+	}
+
+	body := fmt.Sprintf("Updates the manifests of the Application %s/%s to the image %s built from commit %s.", app.Namespace, app.Name, app.Status.Image, app.Status.Commit)
+	url, err := provider.CreatePullRequest(ctx, repo.Owner, repo.Name, branch, repo.BranchName, message, body)
+	if err != nil {
+		setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionFalse, reasonCommitFailed, err.Error())
+		return fmt.Errorf("opening manifests pull request: %w", err)
+	}
+	lgr.Info("opened manifests pull request", "pullRequest", url)
+
+	status.PullRequestURL = url
+	app.Status.Delivery = status
+	setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionTrue, reasonPullRequestOpened, fmt.Sprintf("proposed %d objects in pull request %s", len(objs), url))
+	return nil
+}
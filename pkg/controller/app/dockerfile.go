@@ -0,0 +1,175 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"github.com/bfoley13/appcontroller/pkg/dockerfile"
+	"github.com/bfoley13/appcontroller/pkg/source"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// dockerfilePollInterval is how often the branch is resolved again while the pull request adding a Dockerfile is open
+const dockerfilePollInterval = time.Minute
+
+// pullRequestFinder is implemented by the providers that look up the pull request of a branch. A branch that has one
+// was proposed by a reconcile whose status update was lost, it isn't proposed again.
+type pullRequestFinder interface {
+	PullRequestURL(ctx context.Context, owner, repo, head, base string) (string, error)
+}
+
+// dockerfileBranch is the branch the Dockerfile generated for app is pushed to
+func dockerfileBranch(app *appv1alpha1.Application) string {
+	return fmt.Sprintf("appcontroller/%s-dockerfile", app.Name)
+}
+
+// reconcileDockerfile makes sure the Dockerfile app builds exists at commit. A repository without one gets the
+// Dockerfile and .dockerignore draft generates for the language of its build context, pushed to a new branch and
+// proposed in a pull request. It returns false while the build waits for the pull request to be merged.
+func (ar *appReconciler) reconcileDockerfile(ctx context.Context, app *appv1alpha1.Application, provider source.SourceProvider, commit string) (bool, error) {
+	lgr := log.FromContext(ctx).WithValues("commit", commit)
+
+	// a repository that built once has a Dockerfile, checking every commit would download each of them
+	if app.Status.Build != nil {
+		return true, nil
+	}
+	status := app.Status.Dockerfile
+	if status != nil && status.Commit == commit {
+		return false, nil
+	}
+
+	repo := app.Spec.Repository
+	docker := app.Spec.DockerConfig
+	dockerfilePath := path.Join(docker.BuildContext, docker.Dockerfile)
+
+	archive, err := provider.DownloadRepo(ctx, repo.Owner, repo.Name, commit)
+	if errors.Is(err, errors.ErrUnsupported) {
+		// repositories that can't be downloaded are built as they are
+		lgr.Info("provider can't download repositories, skipping dockerfile check")
+		return true, nil
+	}
+	if err != nil {
+		setCondition(app, appv1alpha1.ConditionTypeDockerfileReady, metav1.ConditionFalse, reasonDownloadFailed, err.Error())
+		return false, fmt.Errorf("downloading repository: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "appcontroller-source-")
+	if err != nil {
+		return false, fmt.Errorf("creating source directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	root, err := dockerfile.Extract(archive, dir)
+	if err != nil {
+		setCondition(app, appv1alpha1.ConditionTypeDockerfileReady, metav1.ConditionFalse, reasonDownloadFailed, err.Error())
+		return false, fmt.Errorf("extracting repository: %w", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(dockerfilePath))); err == nil {
+		app.Status.Dockerfile = nil
+		setCondition(app, appv1alpha1.ConditionTypeDockerfileReady, metav1.ConditionTrue, reasonDockerfileFound, fmt.Sprintf("found %s at commit %s", dockerfilePath, commit))
+		return true, nil
+	}
+
+	// commits pushed while the pull request is open don't need another one
+	if status != nil && status.PullRequestURL != "" {
+		lgr.Info("dockerfile still missing, waiting for pull request", "pullRequest", status.PullRequestURL)
+		status.Commit = commit
+		return false, nil
+	}
+
+	lgr.Info("dockerfile missing, generating one", "path", dockerfilePath)
+	generated, err := dockerfile.Generate(filepath.Join(root, filepath.FromSlash(docker.BuildContext)), app.Spec.AppPort)
+	if err != nil {
+		setCondition(app, appv1alpha1.ConditionTypeDockerfileReady, metav1.ConditionFalse, reasonGenerateFailed, fmt.Sprintf("%s is missing and can't be generated: %s", dockerfilePath, err))
+		return false, fmt.Errorf("generating dockerfile: %w", err)
+	}
+
+	branch := dockerfileBranch(app)
+	// the pull request of an attempt whose status update was lost is still open
+	if finder, ok := provider.(pullRequestFinder); ok {
+		url, err := finder.PullRequestURL(ctx, repo.Owner, repo.Name, branch, repo.BranchName)
+		if err != nil {
+			setCondition(app, appv1alpha1.ConditionTypeDockerfileReady, metav1.ConditionFalse, reasonGenerateFailed, err.Error())
+			return false, fmt.Errorf("looking up dockerfile pull request: %w", err)
+		}
+		if url != "" {
+			lgr.Info("dockerfile already proposed", "pullRequest", url)
+			waitForDockerfile(app, commit, generated.Language, branch, url)
+			return false, nil
+		}
+	}
+
+	// the branch of a failed attempt already exists, pushing to it fails if it doesn't
+	if err := provider.CreateBranch(ctx, repo.Owner, repo.Name, branch, repo.BranchName); err != nil {
+		lgr.Info("unable to create dockerfile branch, pushing to it anyway", "branch", branch, "reason", err.Error())
+	}
+	files := map[string][]byte{}
+	for _, name := range generated.Names() {
+		filePath := path.Join(docker.BuildContext, name)
+		if name == "Dockerfile" {
+			filePath = dockerfilePath
+		}
+		files[filePath] = generated.Files[name]
+	}
+	if err := pushFiles(ctx, provider, repo, branch, fmt.Sprintf("Add a generated %s Dockerfile", generated.Language), files); err != nil {
+		setCondition(app, appv1alpha1.ConditionTypeDockerfileReady, metav1.ConditionFalse, reasonGenerateFailed, err.Error())
+		return false, err
+	}
+
+	title := fmt.Sprintf("Add a %s Dockerfile for %s", generated.Language, app.Name)
+	body := fmt.Sprintf("The Application %s/%s builds %s, which doesn't exist in this repository. This Dockerfile was generated by draft for the %s source in %s, the Application is built once it is merged.",
+		app.Namespace, app.Name, dockerfilePath, generated.Language, docker.BuildContext)
+	url, err := provider.CreatePullRequest(ctx, repo.Owner, repo.Name, branch, repo.BranchName, title, body)
+	if err != nil {
+		setCondition(app, appv1alpha1.ConditionTypeDockerfileReady, metav1.ConditionFalse, reasonGenerateFailed, err.Error())
+		return false, fmt.Errorf("opening dockerfile pull request: %w", err)
+	}
+
+	lgr.Info("opened dockerfile pull request", "pullRequest", url, "language", generated.Language)
+	waitForDockerfile(app, commit, generated.Language, branch, url)
+	return false, nil
+}
+
+// waitForDockerfile records the pull request proposing the generated Dockerfile of app in its status
+func waitForDockerfile(app *appv1alpha1.Application, commit, language, branch, url string) {
+	app.Status.Dockerfile = &appv1alpha1.DockerfileStatus{
+		Commit:         commit,
+		Language:       language,
+		Branch:         branch,
+		PullRequestURL: url,
+	}
+	dockerfilePath := path.Join(app.Spec.DockerConfig.BuildContext, app.Spec.DockerConfig.Dockerfile)
+	setCondition(app, appv1alpha1.ConditionTypeDockerfileReady, metav1.ConditionFalse, reasonWaitingForMerge, fmt.Sprintf("%s is missing, waiting for pull request %s to be merged", dockerfilePath, url))
+}
+
+// pushFiles commits files to branch in one commit when provider supports it and in a commit per file otherwise
+func pushFiles(ctx context.Context, provider source.SourceProvider, repo *appv1alpha1.Repository, branch, message string, files map[string][]byte) error {
+	if committer, ok := provider.(fileCommitter); ok {
+		if _, err := committer.CommitFiles(ctx, repo.Owner, repo.Name, branch, message, files); err != nil {
+			return fmt.Errorf("committing files: %w", err)
+		}
+		return nil
+	}
+
+	paths := make([]string, 0, len(files))
+	for filePath := range files {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+	for _, filePath := range paths {
+		if err := provider.CreateFiles(ctx, repo.Owner, repo.Name, branch, filePath, files[filePath]); err != nil {
+			return fmt.Errorf("pushing %s: %w", filePath, err)
+		}
+	}
+
+	return nil
+}
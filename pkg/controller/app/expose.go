@@ -0,0 +1,297 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"github.com/bfoley13/draft/pkg/addons"
+	"github.com/bfoley13/draft/pkg/osutil"
+	"github.com/bfoley13/draft/template"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+// exposeType returns how app is exposed, a LoadBalancer Service as rendered by the deployment template when
+// spec.expose isn't set
+func exposeType(app *appv1alpha1.Application) appv1alpha1.ExposeType {
+	if app.Spec.Expose == nil {
+		return appv1alpha1.ExposeTypeLoadBalancer
+	}
+
+	return app.Spec.Expose.Type
+}
+
+// configureService sets the type and port of the rendered service from spec.expose, Ingress and Gateway routes
+// reach the application through a ClusterIP Service
+func configureService(app *appv1alpha1.Application, service *corev1.Service) {
+	service.Spec.Type = corev1.ServiceTypeClusterIP
+	if exposeType(app) == appv1alpha1.ExposeTypeLoadBalancer {
+		service.Spec.Type = corev1.ServiceTypeLoadBalancer
+	}
+
+	if app.Spec.Expose != nil && app.Spec.Expose.Port != 0 && len(service.Spec.Ports) > 0 {
+		service.Spec.Ports[0].Port = app.Spec.Expose.Port
+	}
+}
+
+// servicePort returns the port of the Service rendered for app
+func servicePort(app *appv1alpha1.Application) (int32, error) {
+	if app.Spec.Expose != nil && app.Spec.Expose.Port != 0 {
+		return app.Spec.Expose.Port, nil
+	}
+
+	var port int32
+	if _, err := fmt.Sscanf(app.Spec.AppPort, "%d", &port); err != nil {
+		return 0, fmt.Errorf("parsing app port %q: %w", app.Spec.AppPort, err)
+	}
+
+	return port, nil
+}
+
+// routeObject returns the Ingress or HTTPRoute routing to the Service of app, nil if it isn't exposed through one
+func routeObject(app *appv1alpha1.Application) (client.Object, error) {
+	e := app.Spec.Expose
+	if e == nil || (e.Type != appv1alpha1.ExposeTypeIngress && e.Type != appv1alpha1.ExposeTypeGateway) {
+		return nil, nil
+	}
+
+	port, err := servicePort(app)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Type == appv1alpha1.ExposeTypeIngress {
+		return renderIngress(app, port)
+	}
+
+	objectMeta := metav1.ObjectMeta{
+		Name:      app.Name,
+		Namespace: app.Spec.Namespace,
+	}
+
+	parent := gatewayv1.ParentReference{Name: gatewayv1.ObjectName(e.Gateway.Name)}
+	if e.Gateway.Namespace != "" {
+		parent.Namespace = toPtr(gatewayv1.Namespace(e.Gateway.Namespace))
+	}
+	if e.Gateway.SectionName != "" {
+		parent.SectionName = toPtr(gatewayv1.SectionName(e.Gateway.SectionName))
+	}
+
+	route := &gatewayv1.HTTPRoute{
+		TypeMeta:   metav1.TypeMeta{APIVersion: gatewayv1.GroupVersion.String(), Kind: "HTTPRoute"},
+		ObjectMeta: objectMeta,
+		Spec: gatewayv1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayv1.CommonRouteSpec{ParentRefs: []gatewayv1.ParentReference{parent}},
+			Rules: []gatewayv1.HTTPRouteRule{{
+				Matches: []gatewayv1.HTTPRouteMatch{{
+					Path: &gatewayv1.HTTPPathMatch{
+						Type:  toPtr(gatewayv1.PathMatchPathPrefix),
+						Value: toPtr(e.Path),
+					},
+				}},
+				BackendRefs: httpBackendRefs(app, port),
+			}},
+		},
+	}
+	if e.Host != "" {
+		route.Spec.Hostnames = []gatewayv1.Hostname{gatewayv1.Hostname(e.Host)}
+	}
+
+	return route, nil
+}
+
+// ingressAddon is the draft addon the Ingress of an Application is rendered from
+const (
+	ingressAddonProvider = "azure"
+	ingressAddon         = "webapp_routing"
+)
+
+// meshIngressAnnotations are set by the ingress addon for backends enrolled in Open Service Mesh, the ingress
+// controller can't reach backends outside the mesh with them
+var meshIngressAnnotations = []string{
+	"kubernetes.azure.com/use-osm-mtls",
+	"nginx.ingress.kubernetes.io/backend-protocol",
+	"nginx.ingress.kubernetes.io/configuration-snippet",
+	"nginx.ingress.kubernetes.io/proxy-ssl-secret",
+	"nginx.ingress.kubernetes.io/proxy-ssl-verify",
+}
+
+// renderIngress renders the draft web app routing Ingress routing to port of the Service of app and configures it
+// from spec.expose
+func renderIngress(app *appv1alpha1.Application, port int32) (*networkingv1.Ingress, error) {
+	addonPath, err := addons.GetAddonPath(template.Addons, ingressAddonProvider, ingressAddon)
+	if err != nil {
+		return nil, fmt.Errorf("getting ingress addon: %w", err)
+	}
+	addonConfig, err := addons.GetAddonConfig(template.Addons, ingressAddonProvider, ingressAddon)
+	if err != nil {
+		return nil, fmt.Errorf("getting ingress addon config: %w", err)
+	}
+
+	e := app.Spec.Expose
+	// every variable is set, the addon has no defaults for most of them
+	addonConfig.SetVariable("GENERATORLABEL", "azure-devx-appcontroller")
+	addonConfig.SetVariable("ingress-host", e.Host)
+	addonConfig.SetVariable("ingress-tls-cert-keyvault-uri", "")
+	addonConfig.SetVariable("ingress-use-osm-mtls", fmt.Sprint(app.Spec.Mesh != nil))
+	addonConfig.SetVariable("service-name", app.Name)
+	addonConfig.SetVariable("service-namespace", app.Spec.Namespace)
+	addonConfig.SetVariable("service-port", fmt.Sprint(port))
+
+	fileWriter := &TemplateFiles{Files: map[string][]byte{}}
+	if err := osutil.CopyDir(template.Addons, addonPath, ".", addonConfig.DraftConfig, fileWriter); err != nil {
+		return nil, fmt.Errorf("rendering ingress addon: %w", err)
+	}
+
+	var ingress *networkingv1.Ingress
+	for fileName, data := range fileWriter.Files {
+		obj, err := deserialize(data)
+		if err != nil {
+			return nil, fmt.Errorf("deserializing %s: %w", fileName, err)
+		}
+		if i, ok := obj.(*networkingv1.Ingress); ok {
+			ingress = i
+		}
+	}
+	if ingress == nil {
+		return nil, fmt.Errorf("ingress addon rendered no ingress")
+	}
+
+	configureIngress(app, ingress)
+	return ingress, nil
+}
+
+// configureIngress sets the class, path and TLS of the rendered ingress from spec.expose. The addon targets the
+// web app routing add-on with Key Vault certificates, the annotations that don't apply to app are removed.
+func configureIngress(app *appv1alpha1.Application, ingress *networkingv1.Ingress) {
+	e := app.Spec.Expose
+	ingress.TypeMeta = metav1.TypeMeta{APIVersion: networkingv1.SchemeGroupVersion.String(), Kind: "Ingress"}
+
+	delete(ingress.Annotations, "kubernetes.azure.com/tls-cert-keyvault-uri")
+	if app.Spec.Mesh == nil {
+		for _, annotation := range meshIngressAnnotations {
+			delete(ingress.Annotations, annotation)
+		}
+	}
+
+	ingress.Spec.IngressClassName = nil
+	if e.IngressClassName != "" {
+		ingress.Spec.IngressClassName = toPtr(e.IngressClassName)
+	}
+
+	for i := range ingress.Spec.Rules {
+		if http := ingress.Spec.Rules[i].HTTP; http != nil {
+			for j := range http.Paths {
+				http.Paths[j].Path = e.Path
+			}
+		}
+	}
+
+	ingress.Spec.TLS = nil
+	if e.TLSSecretName != "" {
+		tls := networkingv1.IngressTLS{SecretName: e.TLSSecretName}
+		if e.Host != "" {
+			tls.Hosts = []string{e.Host}
+		}
+		ingress.Spec.TLS = []networkingv1.IngressTLS{tls}
+	}
+}
+
+// joinURL returns the url of path on host
+func joinURL(scheme, host, path string) string {
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, host, path)
+}
+
+// ingressHost returns the first address assigned to a load balancer
+func ingressHost(ingresses []networkingv1.IngressLoadBalancerIngress) string {
+	for _, ingress := range ingresses {
+		if ingress.Hostname != "" {
+			return ingress.Hostname
+		}
+		if ingress.IP != "" {
+			return ingress.IP
+		}
+	}
+	return ""
+}
+
+// reconcileURL resolves where app is reached from the status of its Service, Ingress or Gateway and records it
+// as the url in its status. The url stays empty until the address is assigned.
+func (ar *appReconciler) reconcileURL(ctx context.Context, app *appv1alpha1.Application) error {
+	app.Status.URL = ""
+	e := app.Spec.Expose
+	key := client.ObjectKey{Namespace: app.Spec.Namespace, Name: app.Name}
+
+	switch exposeType(app) {
+	case appv1alpha1.ExposeTypeClusterIP, appv1alpha1.ExposeTypeLoadBalancer:
+		service := &corev1.Service{}
+		if err := ar.client.Get(ctx, key, service); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return fmt.Errorf("getting service %s: %w", key, err)
+		}
+
+		// serviceEndpoint falls back to the in-cluster address until the load balancer has one
+		if service.Spec.Type != corev1.ServiceTypeLoadBalancer || len(service.Status.LoadBalancer.Ingress) > 0 {
+			app.Status.URL = "http://" + serviceEndpoint(service)
+		}
+	case appv1alpha1.ExposeTypeIngress:
+		scheme := "http"
+		if e.TLSSecretName != "" {
+			scheme = "https"
+		}
+		if e.Host != "" {
+			app.Status.URL = joinURL(scheme, e.Host, e.Path)
+			return nil
+		}
+
+		ingress := &networkingv1.Ingress{}
+		if err := ar.client.Get(ctx, key, ingress); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return fmt.Errorf("getting ingress %s: %w", key, err)
+		}
+
+		if host := ingressHost(ingress.Status.LoadBalancer.Ingress); host != "" {
+			app.Status.URL = joinURL(scheme, host, e.Path)
+		}
+	case appv1alpha1.ExposeTypeGateway:
+		if e.Host != "" {
+			app.Status.URL = joinURL("http", e.Host, e.Path)
+			return nil
+		}
+
+		gatewayKey := client.ObjectKey{Namespace: e.Gateway.Namespace, Name: e.Gateway.Name}
+		if gatewayKey.Namespace == "" {
+			gatewayKey.Namespace = app.Spec.Namespace
+		}
+		gateway := &gatewayv1.Gateway{}
+		if err := ar.client.Get(ctx, gatewayKey, gateway); err != nil {
+			if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+				return nil
+			}
+			return fmt.Errorf("getting gateway %s: %w", gatewayKey, err)
+		}
+
+		for _, address := range gateway.Status.Addresses {
+			if address.Value != "" {
+				app.Status.URL = joinURL("http", strings.TrimSuffix(address.Value, "."), e.Path)
+				break
+			}
+		}
+	}
+
+	return nil
+}
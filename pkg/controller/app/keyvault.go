@@ -0,0 +1,197 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	secv1 "sigs.k8s.io/secrets-store-csi-driver/apis/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	secretsStoreDriver = "secrets-store.csi.k8s.io"
+	keyVaultVolume     = "keyvault-secrets"
+
+	// workloadIdentityLabel makes the workload identity webhook project a service account token the Key Vault
+	// provider exchanges for an Azure token
+	workloadIdentityLabel = "azure.workload.identity/use"
+)
+
+// secretProviderClassName returns the name of the SecretProviderClass generated for app
+func secretProviderClassName(app *appv1alpha1.Application) string {
+	return app.Name
+}
+
+// keyVaultObjects renders the objects parameter of the Azure Key Vault provider, a yaml array of yaml documents
+func keyVaultObjects(kv *appv1alpha1.KeyVault) (string, error) {
+	type object struct {
+		ObjectName    string `json:"objectName"`
+		ObjectType    string `json:"objectType"`
+		ObjectVersion string `json:"objectVersion"`
+	}
+
+	objects := struct {
+		Array []string `json:"array"`
+	}{}
+	for _, o := range kv.Objects {
+		b, err := yaml.Marshal(object{ObjectName: o.Name, ObjectType: string(o.Type), ObjectVersion: o.Version})
+		if err != nil {
+			return "", fmt.Errorf("marshalling key vault object %s: %w", o.Name, err)
+		}
+		objects.Array = append(objects.Array, string(b))
+	}
+
+	b, err := yaml.Marshal(objects)
+	if err != nil {
+		return "", fmt.Errorf("marshalling key vault objects: %w", err)
+	}
+
+	return string(b), nil
+}
+
+// secretProviderClass returns the SecretProviderClass mounting the Key Vault objects of app
+func secretProviderClass(app *appv1alpha1.Application) (*secv1.SecretProviderClass, error) {
+	kv := app.Spec.KeyVault
+	objects, err := keyVaultObjects(kv)
+	if err != nil {
+		return nil, err
+	}
+
+	spc := &secv1.SecretProviderClass{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: secv1.GroupVersion.String(),
+			Kind:       "SecretProviderClass",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretProviderClassName(app),
+			Namespace: app.Spec.Namespace,
+		},
+		Spec: secv1.SecretProviderClassSpec{
+			Provider: "azure",
+			Parameters: map[string]string{
+				"usePodIdentity": "false",
+				"clientID":       kv.ClientID,
+				"keyvaultName":   kv.VaultName,
+				"tenantId":       kv.TenantID,
+				"objects":        objects,
+			},
+		},
+	}
+
+	if kv.SecretName != "" {
+		secret := &secv1.SecretObject{
+			SecretName: kv.SecretName,
+			Type:       string(corev1.SecretTypeOpaque),
+		}
+		for _, o := range kv.Objects {
+			secret.Data = append(secret.Data, &secv1.SecretObjectData{ObjectName: o.Name, Key: o.Key})
+		}
+		spc.Spec.SecretObjects = []*secv1.SecretObject{secret}
+	}
+
+	return spc, nil
+}
+
+// configureKeyVault mounts the SecretProviderClass of app in the application container of deployment and runs
+// its pods with the workload identity that can read the vault
+func configureKeyVault(app *appv1alpha1.Application, deployment *appsv1.Deployment) {
+	kv := app.Spec.KeyVault
+	if kv == nil {
+		return
+	}
+
+	template := &deployment.Spec.Template
+	if template.Labels == nil {
+		template.Labels = map[string]string{}
+	}
+	template.Labels[workloadIdentityLabel] = "true"
+	if kv.ServiceAccountName != "" {
+		template.Spec.ServiceAccountName = kv.ServiceAccountName
+	}
+
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: keyVaultVolume,
+		VolumeSource: corev1.VolumeSource{
+			CSI: &corev1.CSIVolumeSource{
+				Driver:           secretsStoreDriver,
+				ReadOnly:         toPtr(true),
+				VolumeAttributes: map[string]string{"secretProviderClass": secretProviderClassName(app)},
+			},
+		},
+	})
+
+	container := &template.Spec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      keyVaultVolume,
+		MountPath: kv.MountPath,
+		ReadOnly:  true,
+	})
+}
+
+// reconcileSecretsMounted reports whether the pods of app mounted its SecretProviderClass, the driver records a
+// SecretProviderClassPodStatus for every pod it mounted the objects in
+func (ar *appReconciler) reconcileSecretsMounted(ctx context.Context, app *appv1alpha1.Application) error {
+	if app.Spec.KeyVault == nil {
+		meta.RemoveStatusCondition(&app.Status.Conditions, appv1alpha1.ConditionTypeSecretsMounted)
+		return nil
+	}
+
+	var podStatuses secv1.SecretProviderClassPodStatusList
+	if err := ar.client.List(ctx, &podStatuses, client.InNamespace(app.Spec.Namespace)); err != nil {
+		return fmt.Errorf("listing secret provider class pod statuses: %w", err)
+	}
+
+	var pods, mounted int
+	for _, status := range podStatuses.Items {
+		if status.Status.SecretProviderClassName != secretProviderClassName(app) {
+			continue
+		}
+
+		pods++
+		if status.Status.Mounted {
+			mounted++
+		}
+	}
+
+	switch {
+	case pods == 0:
+		setCondition(app, appv1alpha1.ConditionTypeSecretsMounted, metav1.ConditionFalse, reasonMountPending, "no pod has mounted the key vault objects yet")
+	case mounted < pods:
+		setCondition(app, appv1alpha1.ConditionTypeSecretsMounted, metav1.ConditionFalse, reasonMountPending, fmt.Sprintf("%d of %d pods mounted the key vault objects", mounted, pods))
+	default:
+		setCondition(app, appv1alpha1.ConditionTypeSecretsMounted, metav1.ConditionTrue, reasonMounted, fmt.Sprintf("%d pods mounted the key vault objects", pods))
+	}
+
+	return nil
+}
+
+// enqueueMountingApps maps a SecretProviderClassPodStatus to the Application whose SecretProviderClass it reports on
+func (ar *appReconciler) enqueueMountingApps(ctx context.Context, obj client.Object) []reconcile.Request {
+	status, ok := obj.(*secv1.SecretProviderClassPodStatus)
+	if !ok {
+		return nil
+	}
+
+	var apps appv1alpha1.ApplicationList
+	if err := ar.client.List(ctx, &apps); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list apps mounting key vault objects", "name", obj.GetName())
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for _, app := range apps.Items {
+		if app.Spec.KeyVault != nil && app.Spec.Namespace == status.Namespace && secretProviderClassName(&app) == status.Status.SecretProviderClassName {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&app)})
+		}
+	}
+
+	return reqs
+}
@@ -0,0 +1,244 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	policyv1alpha1 "github.com/openservicemesh/osm/pkg/apis/policy/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// meshMonitoredByLabel enrolls a namespace in the mesh named by its value
+	meshMonitoredByLabel = "openservicemesh.io/monitored-by"
+	// sidecarInjectionAnnotation enables sidecar injection for the pods of a namespace or a single pod
+	sidecarInjectionAnnotation = "openservicemesh.io/sidecar-injection"
+)
+
+// podServiceAccount returns the service account the pods of app run as, the mesh identifies them by it
+func podServiceAccount(app *appv1alpha1.Application) string {
+	if app.Spec.KeyVault != nil && app.Spec.KeyVault.ServiceAccountName != "" {
+		return app.Spec.KeyVault.ServiceAccountName
+	}
+
+	return "default"
+}
+
+// meshFieldManager is the field manager app enrolls its target namespace with. Every Application owns its
+// enrollment, the namespace stays enrolled until the last Application enrolling it removes its enrollment.
+func meshFieldManager(app *appv1alpha1.Application) string {
+	sum := sha256.Sum256([]byte(app.Namespace + "/" + app.Name))
+	return fmt.Sprintf("%s-mesh-%x", fieldManager, sum[:8])
+}
+
+// namespaceEnrollment returns the apply configuration of namespace enrolled in mesh, an empty one when mesh is empty
+func namespaceEnrollment(namespace, mesh string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("Namespace")
+	obj.SetName(namespace)
+	if mesh != "" {
+		obj.SetLabels(map[string]string{meshMonitoredByLabel: mesh})
+		obj.SetAnnotations(map[string]string{sidecarInjectionAnnotation: "enabled"})
+	}
+	return obj
+}
+
+// enrollNamespace adds the target namespace of app to its mesh and returns the namespace as a resource of app, so
+// pruning it or deleting app un-enrolls it. The label of a namespace enrolled in another mesh isn't taken over, the
+// conflict is reported in the MeshEnrolled condition and the rest of app is still deployed.
+func (ar *appReconciler) enrollNamespace(ctx context.Context, app *appv1alpha1.Application) ([]appv1alpha1.ResourceStatus, error) {
+	if app.Spec.Mesh == nil {
+		meta.RemoveStatusCondition(&app.Status.Conditions, appv1alpha1.ConditionTypeMeshEnrolled)
+		return nil, nil
+	}
+
+	mesh := app.MeshName()
+	namespace := namespaceEnrollment(app.Spec.Namespace, mesh)
+	status := appv1alpha1.ResourceStatus{APIVersion: "v1", Kind: "Namespace", Name: app.Spec.Namespace}
+	result, err := applyObject(ctx, ar.client, namespace, client.FieldOwner(meshFieldManager(app)))
+	status.Result = result
+	if apierrors.IsConflict(err) {
+		status.Message = err.Error()
+		setCondition(app, appv1alpha1.ConditionTypeMeshEnrolled, metav1.ConditionFalse, reasonMeshConflict, fmt.Sprintf("namespace %s is enrolled in another mesh: %s", app.Spec.Namespace, err))
+		return []appv1alpha1.ResourceStatus{status}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("enrolling namespace %s in mesh %s: %w", app.Spec.Namespace, mesh, err)
+	}
+
+	setCondition(app, appv1alpha1.ConditionTypeMeshEnrolled, metav1.ConditionTrue, reasonEnrolled, fmt.Sprintf("namespace %s is enrolled in mesh %s", app.Spec.Namespace, mesh))
+	return []appv1alpha1.ResourceStatus{status}, nil
+}
+
+// unenrollNamespace removes the enrollment of app from namespace, the namespace stays in the mesh while other
+// Applications or workloads enroll it
+func unenrollNamespace(ctx context.Context, cl client.Client, app *appv1alpha1.Application, namespace string) error {
+	current := &unstructured.Unstructured{}
+	current.SetAPIVersion("v1")
+	current.SetKind("Namespace")
+	// applying to a deleted namespace would create it again
+	if err := cl.Get(ctx, client.ObjectKey{Name: namespace}, current); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if err := cl.Patch(ctx, namespaceEnrollment(namespace, ""), client.Apply, client.FieldOwner(meshFieldManager(app))); err != nil {
+		return fmt.Errorf("removing namespace %s from mesh: %w", namespace, err)
+	}
+
+	return nil
+}
+
+// configureMesh annotates the pod template of deployment for sidecar injection, so pods created before the
+// namespace was enrolled are replaced by pods with a sidecar
+func configureMesh(app *appv1alpha1.Application, deployment *appsv1.Deployment) {
+	if app.Spec.Mesh == nil {
+		return
+	}
+
+	if deployment.Spec.Template.Annotations == nil {
+		deployment.Spec.Template.Annotations = map[string]string{}
+	}
+	deployment.Spec.Template.Annotations[sidecarInjectionAnnotation] = "enabled"
+}
+
+func toUint32Ptr(i *int32) *uint32 {
+	if i == nil {
+		return nil
+	}
+	return toPtr(uint32(*i))
+}
+
+// meshObjects returns the OSM policies generated from spec.mesh
+func meshObjects(app *appv1alpha1.Application) ([]client.Object, error) {
+	m := app.Spec.Mesh
+	if m == nil {
+		return nil, nil
+	}
+
+	objectMeta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: app.Spec.Namespace}
+	}
+	typeMeta := func(kind string) metav1.TypeMeta {
+		return metav1.TypeMeta{APIVersion: policyv1alpha1.SchemeGroupVersion.String(), Kind: kind}
+	}
+
+	var objs []client.Object
+	if backend, err := ingressBackend(app); err != nil {
+		return nil, err
+	} else if backend != nil {
+		backend.TypeMeta = typeMeta("IngressBackend")
+		backend.ObjectMeta = objectMeta(app.Name)
+		objs = append(objs, backend)
+	}
+
+	source := []policyv1alpha1.EgressSourceSpec{{Kind: "ServiceAccount", Name: podServiceAccount(app), Namespace: app.Spec.Namespace}}
+	for _, e := range m.Egress {
+		objs = append(objs, &policyv1alpha1.Egress{
+			TypeMeta:   typeMeta("Egress"),
+			ObjectMeta: objectMeta(fmt.Sprintf("%s-%s", app.Name, e.Name)),
+			Spec: policyv1alpha1.EgressSpec{
+				Sources:     source,
+				Hosts:       e.Hosts,
+				IPAddresses: e.IPAddresses,
+				Ports:       []policyv1alpha1.PortSpec{{Number: int(e.Port), Protocol: string(e.Protocol)}},
+			},
+		})
+	}
+
+	if r := m.Retry; r != nil {
+		retry := &policyv1alpha1.Retry{
+			TypeMeta:   typeMeta("Retry"),
+			ObjectMeta: objectMeta(app.Name),
+			Spec: policyv1alpha1.RetrySpec{
+				Source: policyv1alpha1.RetrySrcDstSpec{Kind: "ServiceAccount", Name: podServiceAccount(app), Namespace: app.Spec.Namespace},
+				RetryPolicy: policyv1alpha1.RetryPolicySpec{
+					RetryOn:                  r.RetryOn,
+					NumRetries:               toUint32Ptr(r.NumRetries),
+					PerTryTimeout:            r.PerTryTimeout,
+					RetryBackoffBaseInterval: r.BackoffBaseInterval,
+				},
+			},
+		}
+		for _, d := range r.Destinations {
+			retry.Spec.Destinations = append(retry.Spec.Destinations, policyv1alpha1.RetrySrcDstSpec{Kind: "Service", Name: d.Name, Namespace: d.Namespace})
+		}
+		objs = append(objs, retry)
+	}
+
+	if m.RateLimit != nil || m.CircuitBreaker != nil {
+		setting := &policyv1alpha1.UpstreamTrafficSetting{
+			TypeMeta:   typeMeta("UpstreamTrafficSetting"),
+			ObjectMeta: objectMeta(app.Name),
+			Spec: policyv1alpha1.UpstreamTrafficSettingSpec{
+				Host: fmt.Sprintf("%s.%s.svc.cluster.local", app.Name, app.Spec.Namespace),
+			},
+		}
+		if rl := m.RateLimit; rl != nil {
+			setting.Spec.RateLimit = &policyv1alpha1.RateLimitSpec{
+				Local: &policyv1alpha1.LocalRateLimitSpec{
+					HTTP: &policyv1alpha1.HTTPLocalRateLimitSpec{
+						Requests: uint32(rl.Requests),
+						Unit:     string(rl.Unit),
+						Burst:    uint32(rl.Burst),
+					},
+				},
+			}
+		}
+		if cb := m.CircuitBreaker; cb != nil {
+			setting.Spec.ConnectionSettings = &policyv1alpha1.ConnectionSettingsSpec{
+				TCP: &policyv1alpha1.TCPConnectionSettings{
+					MaxConnections: toUint32Ptr(cb.MaxConnections),
+				},
+				HTTP: &policyv1alpha1.HTTPConnectionSettings{
+					MaxRequests:        toUint32Ptr(cb.MaxRequests),
+					MaxPendingRequests: toUint32Ptr(cb.MaxPendingRequests),
+					MaxRetries:         toUint32Ptr(cb.MaxRetries),
+				},
+			}
+		}
+		objs = append(objs, setting)
+	}
+
+	return objs, nil
+}
+
+// ingressBackend authorizes traffic from outside the mesh to the pods of app, from the ingress controller when it
+// is exposed through an Ingress or Gateway and from any address, 0.0.0.0/0, when it is exposed on a load balancer
+func ingressBackend(app *appv1alpha1.Application) (*policyv1alpha1.IngressBackend, error) {
+	var sources []policyv1alpha1.IngressSourceSpec
+	switch exposeType(app) {
+	case appv1alpha1.ExposeTypeIngress, appv1alpha1.ExposeTypeGateway:
+		sources = []policyv1alpha1.IngressSourceSpec{{
+			Kind:      policyv1alpha1.KindService,
+			Name:      app.Spec.Mesh.IngressSource.Name,
+			Namespace: app.Spec.Mesh.IngressSource.Namespace,
+		}}
+	case appv1alpha1.ExposeTypeLoadBalancer:
+		sources = []policyv1alpha1.IngressSourceSpec{{Kind: policyv1alpha1.KindIPRange, Name: "0.0.0.0/0"}}
+	default:
+		return nil, nil
+	}
+
+	var port int
+	if _, err := fmt.Sscanf(app.Spec.AppPort, "%d", &port); err != nil {
+		return nil, fmt.Errorf("parsing app port %q: %w", app.Spec.AppPort, err)
+	}
+
+	return &policyv1alpha1.IngressBackend{
+		Spec: policyv1alpha1.IngressBackendSpec{
+			Backends: []policyv1alpha1.BackendSpec{{
+				Name: app.Name,
+				Port: policyv1alpha1.PortSpec{Number: port, Protocol: string(appv1alpha1.MeshProtocolHTTP)},
+			}},
+			Sources: sources,
+		},
+	}, nil
+}
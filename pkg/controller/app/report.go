@@ -0,0 +1,184 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// buildStatusContext is the commit status builds are reported as
+const buildStatusContext = "appcontroller/build"
+
+// maxStatusDescription is the longest commit status description in characters GitHub accepts
+const maxStatusDescription = 140
+
+// GitHub commit status and deployment status states
+const (
+	statePending    = "pending"
+	stateInProgress = "in_progress"
+	stateSuccess    = "success"
+	stateFailure    = "failure"
+)
+
+// statusReporter is implemented by the providers that report builds and deploys back to the repository
+type statusReporter interface {
+	SetCommitStatus(ctx context.Context, owner, repo, sha, statusContext, state, description, targetURL string) error
+	CreateDeployment(ctx context.Context, owner, repo, ref, environment, description string) (int64, error)
+	CreateDeploymentStatus(ctx context.Context, owner, repo string, id int64, state, environmentURL, description string) error
+}
+
+// reporterFor returns the status reporter of the repository of app, nil when app doesn't report its status
+func (ar *appReconciler) reporterFor(ctx context.Context, app *appv1alpha1.Application) (statusReporter, error) {
+	repo := app.Spec.Repository
+	if repo == nil || !repo.ReportStatus {
+		return nil, nil
+	}
+
+	provider, _, err := ar.providers.For(ctx, app.Namespace, repo)
+	if err != nil {
+		return nil, fmt.Errorf("getting repository provider: %w", err)
+	}
+	reporter, ok := provider.(statusReporter)
+	if !ok {
+		return nil, fmt.Errorf("%s repositories can't report status", repo.GetProvider())
+	}
+
+	return reporter, nil
+}
+
+// reportBuild posts the state of run as a commit status on the commit it builds. Reporting is best effort, a
+// failure is logged and doesn't fail the build.
+func (ar *appReconciler) reportBuild(ctx context.Context, app *appv1alpha1.Application, run *appv1alpha1.RunStatus) {
+	lgr := log.FromContext(ctx).WithValues("commit", run.Commit, "runID", run.ID)
+	reporter, err := ar.reporterFor(ctx, app)
+	if err != nil {
+		lgr.Error(err, "unable to report build")
+		return
+	}
+	if reporter == nil {
+		return
+	}
+
+	var state, description string
+	switch run.State {
+	case appv1alpha1.RunStateRunning:
+		state, description = statePending, fmt.Sprintf("building in run %s", run.ID)
+	case appv1alpha1.RunStateSucceeded:
+		state, description = stateSuccess, fmt.Sprintf("built %s", app.Status.Build.Image)
+	default:
+		state, description = stateFailure, buildFailedDescription(run)
+	}
+
+	repo := app.Spec.Repository
+	if err := reporter.SetCommitStatus(ctx, repo.Owner, repo.Name, run.Commit, buildStatusContext, state, description, ""); err != nil {
+		lgr.Error(err, "unable to report build")
+	}
+}
+
+// buildFailedDescription is the commit status description of the failed run, its message followed by the last line of
+// its log. A long log line leaves at least half of the description to the message, which is shortened to fit the rest.
+func buildFailedDescription(run *appv1alpha1.RunStatus) string {
+	description := fmt.Sprintf("run %s failed: %s", run.ID, run.Message)
+	lines := strings.Split(strings.TrimSpace(run.LogTail), "\n")
+	lastLine := strings.TrimSpace(lines[len(lines)-1])
+	if lastLine == "" {
+		return description
+	}
+
+	const separator = ", log ends with: "
+	prefix := fmt.Sprintf("run %s failed: ", run.ID)
+	room := maxStatusDescription - len([]rune(prefix+separator))
+	lastLine = truncateEnd(lastLine, room-min(len([]rune(run.Message)), room/2))
+	message := truncate(run.Message, room-len([]rune(lastLine)))
+	return prefix + message + separator + lastLine
+}
+
+// truncate shortens s to at most n characters, ending it with an ellipsis when it is cut
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	if n <= 3 {
+		return ""
+	}
+	return string(r[:n-3]) + "..."
+}
+
+// truncateEnd shortens s to its last n characters at most, starting it with an ellipsis when it is cut
+func truncateEnd(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	if n <= 3 {
+		return ""
+	}
+	return "..." + string(r[len(r)-n+3:])
+}
+
+// deploymentState returns the GitHub deployment state of the deploy of app and its description
+func deploymentState(app *appv1alpha1.Application) (string, string) {
+	switch {
+	case conditionIs(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionFalse),
+		conditionReason(app, appv1alpha1.ConditionTypeRolledOut) == reasonRolloutAborted:
+		return stateFailure, "deploy failed"
+	case conditionReason(app, appv1alpha1.ConditionTypeRolledOut) == reasonRollingOut:
+		return stateInProgress, "rolling out"
+	case conditionReason(app, appv1alpha1.ConditionTypeDeployed) == reasonCommitted:
+		return stateSuccess, "committed to the config repository"
+	case conditionReason(app, appv1alpha1.ConditionTypeDeployed) == reasonPullRequestOpened:
+		return stateInProgress, "waiting for the manifests pull request to merge"
+	case conditionIs(app, appv1alpha1.ConditionTypeAvailable, metav1.ConditionTrue):
+		return stateSuccess, "available"
+	default:
+		return stateInProgress, "waiting for the deployment to become available"
+	}
+}
+
+// reportDeployment records the deploy of the commit of app as a GitHub Deployment and posts its state whenever it
+// changes. A deploy that succeeded isn't reported again. Reporting is best effort, a failure is logged and retried
+// the next reconcile.
+func (ar *appReconciler) reportDeployment(ctx context.Context, app *appv1alpha1.Application) {
+	if app.Status.Commit == "" {
+		return
+	}
+	lgr := log.FromContext(ctx).WithValues("commit", app.Status.Commit)
+	reporter, err := ar.reporterFor(ctx, app)
+	if err != nil {
+		lgr.Error(err, "unable to report deployment")
+		return
+	}
+	if reporter == nil {
+		app.Status.GitHubDeployment = nil
+		return
+	}
+
+	repo := app.Spec.Repository
+	deployment := app.Status.GitHubDeployment
+	if deployment == nil || deployment.Commit != app.Status.Commit {
+		environment := fmt.Sprintf("%s/%s", app.Spec.Namespace, app.Name)
+		id, err := reporter.CreateDeployment(ctx, repo.Owner, repo.Name, app.Status.Commit, environment, fmt.Sprintf("deploying %s", app.Status.Image))
+		if err != nil {
+			lgr.Error(err, "unable to report deployment")
+			return
+		}
+		lgr.Info("created github deployment", "deploymentID", id)
+		deployment = &appv1alpha1.GitHubDeploymentStatus{ID: id, Commit: app.Status.Commit}
+		app.Status.GitHubDeployment = deployment
+	}
+
+	state, description := deploymentState(app)
+	if deployment.State == state || deployment.State == stateSuccess {
+		return
+	}
+	if err := reporter.CreateDeploymentStatus(ctx, repo.Owner, repo.Name, deployment.ID, state, app.Status.URL, description); err != nil {
+		lgr.Error(err, "unable to report deployment status", "deploymentID", deployment.ID)
+		return
+	}
+	deployment.State = state
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+)
+
+// encodeManifests serializes objs into a multi-document yaml
+func encodeManifests(objs []*unstructured.Unstructured) (string, error) {
+	docs := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		b, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return "", fmt.Errorf("marshalling %s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		docs = append(docs, string(b))
+	}
+
+	return strings.Join(docs, "---\n"), nil
+}
+
+// decodeManifests parses the objects of a multi-document yaml
+func decodeManifests(manifests string) ([]*unstructured.Unstructured, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewBufferString(manifests), 4096)
+
+	var objs []*unstructured.Unstructured
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objs, nil
+			}
+			return nil, fmt.Errorf("decoding manifests: %w", err)
+		}
+		if len(obj.Object) > 0 {
+			objs = append(objs, obj)
+		}
+	}
+}
+
+// listRevisions returns the ApplicationRevisions of app, oldest first
+func (ar *appReconciler) listRevisions(ctx context.Context, app *appv1alpha1.Application) ([]appv1alpha1.ApplicationRevision, error) {
+	var revisions appv1alpha1.ApplicationRevisionList
+	if err := ar.client.List(ctx, &revisions, client.InNamespace(app.Namespace), client.MatchingLabels{appv1alpha1.RevisionApplicationLabel: app.Name}); err != nil {
+		return nil, fmt.Errorf("listing revisions: %w", err)
+	}
+
+	sort.Slice(revisions.Items, func(i, j int) bool {
+		return revisions.Items[i].Spec.Revision < revisions.Items[j].Spec.Revision
+	})
+
+	return revisions.Items, nil
+}
+
+// recordRevision creates an ApplicationRevision for manifests unless they are what the latest revision deployed,
+// and deletes the revisions past the history limit of app
+func (ar *appReconciler) recordRevision(ctx context.Context, app *appv1alpha1.Application, manifests string) error {
+	lgr := log.FromContext(ctx)
+	revisions, err := ar.listRevisions(ctx, app)
+	if err != nil {
+		return err
+	}
+
+	var latest int64
+	if len(revisions) > 0 {
+		latest = revisions[len(revisions)-1].Spec.Revision
+	}
+
+	if len(revisions) == 0 || revisions[len(revisions)-1].Spec.Manifests != manifests {
+		revision := &appv1alpha1.ApplicationRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      appv1alpha1.RevisionName(app.Name, latest+1),
+				Namespace: app.Namespace,
+				Labels:    map[string]string{appv1alpha1.RevisionApplicationLabel: app.Name},
+			},
+			Spec: appv1alpha1.ApplicationRevisionSpec{
+				ApplicationName: app.Name,
+				Revision:        latest + 1,
+				Image:           app.Status.Image,
+				ImageDigest:     app.Status.ImageDigest,
+				Commit:          app.Status.Commit,
+				Manifests:       manifests,
+				DeployTime:      metav1.Now(),
+			},
+		}
+		if err := controllerutil.SetControllerReference(app, revision, ar.client.Scheme()); err != nil {
+			return fmt.Errorf("setting revision owner: %w", err)
+		}
+		// the revision exists when the cache hasn't seen the one an earlier reconcile created yet, the next
+		// reconcile records the manifests again if that one deployed others
+		err := ar.client.Create(ctx, revision)
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("creating revision %s: %w", revision.Name, err)
+		}
+		if err == nil {
+			lgr.Info("recorded revision", "revision", revision.Spec.Revision, "image", revision.Spec.Image)
+		}
+		revisions = append(revisions, *revision)
+	}
+	app.Status.Revision = revisions[len(revisions)-1].Spec.Revision
+
+	// the history limit is at least 1 so the revision just deployed is never deleted
+	limit := int(appv1alpha1.DefaultRevisionHistoryLimit)
+	if app.Spec.RevisionHistoryLimit != nil {
+		limit = int(*app.Spec.RevisionHistoryLimit)
+	}
+	for i := 0; i < len(revisions)-limit; i++ {
+		if err := ar.client.Delete(ctx, &revisions[i]); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting revision %s: %w", revisions[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+// reconcileRollback redeploys the manifests of the revision spec.rollbackTo names. Nothing is built and no
+// revision is recorded until spec.rollbackTo is cleared.
+func (ar *appReconciler) reconcileRollback(ctx context.Context, app *appv1alpha1.Application) error {
+	lgr := log.FromContext(ctx)
+	key := client.ObjectKey{Namespace: app.Namespace, Name: appv1alpha1.RevisionName(app.Name, *app.Spec.RollbackTo)}
+
+	revision := &appv1alpha1.ApplicationRevision{}
+	if err := ar.client.Get(ctx, key, revision); err != nil {
+		if apierrors.IsNotFound(err) {
+			lgr.Info("revision not found, waiting for spec to change", "revision", *app.Spec.RollbackTo)
+			setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionFalse, reasonRevisionNotFound, fmt.Sprintf("revision %d of %s not found", *app.Spec.RollbackTo, app.Name))
+			return nil
+		}
+		return fmt.Errorf("getting revision %s: %w", key, err)
+	}
+
+	objs, err := decodeManifests(revision.Spec.Manifests)
+	if err != nil {
+		lgr.Error(err, "unable to decode revision manifests", "revision", revision.Spec.Revision)
+		setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionFalse, reasonApplyFailed, err.Error())
+		return err
+	}
+
+	app.Status.Image = revision.Spec.Image
+	app.Status.ImageDigest = revision.Spec.ImageDigest
+	app.Status.Commit = revision.Spec.Commit
+	// a rollout resumes from the rolled back image once spec.rollbackTo is cleared
+	app.Status.Rollout = nil
+	meta.RemoveStatusCondition(&app.Status.Conditions, appv1alpha1.ConditionTypeRolledOut)
+
+	if err := ar.deploy(ctx, app, objs); err != nil {
+		return err
+	}
+
+	app.Status.Revision = revision.Spec.Revision
+	setCondition(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionTrue, reasonRolledBack, fmt.Sprintf("rolled back to revision %d", revision.Spec.Revision))
+	return nil
+}
@@ -0,0 +1,394 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+const (
+	// trackLabel tells the pods of the stable and canary Deployments apart, the stable and canary Services
+	// select on it
+	trackLabel  = "devx.kubernetes.azure.com/track"
+	trackStable = "stable"
+	trackCanary = "canary"
+)
+
+// trafficSplitGVK is the SMI TrafficSplit version OSM serves
+var trafficSplitGVK = schema.GroupVersionKind{Group: "split.smi-spec.io", Version: "v1alpha2", Kind: "TrafficSplit"}
+
+func stableName(app *appv1alpha1.Application) string {
+	return app.Name + "-" + trackStable
+}
+
+func canaryName(app *appv1alpha1.Application) string {
+	return app.Name + "-" + trackCanary
+}
+
+// renderedImage returns ref the way the deployment template renders it
+func renderedImage(ref string) string {
+	name, tag := splitImageReference(ref)
+	return name + ":" + tag
+}
+
+// canaryRunning returns whether app has a canary Deployment
+func canaryRunning(app *appv1alpha1.Application) bool {
+	r := app.Status.Rollout
+	return app.Spec.Rollout != nil && r != nil && (r.Phase == appv1alpha1.RolloutPhaseProgressing || r.Phase == appv1alpha1.RolloutPhasePromoting)
+}
+
+// stableImage returns the image the stable Deployment of app runs, the canary image while it is promoted
+func stableImage(app *appv1alpha1.Application) string {
+	r := app.Status.Rollout
+	if app.Spec.Rollout == nil || r == nil {
+		return app.Status.Image
+	}
+	if r.Phase == appv1alpha1.RolloutPhasePromoting {
+		return r.CanaryImage
+	}
+
+	return r.StableImage
+}
+
+// canaryWeight returns the percentage of traffic sent to the canary of app
+func canaryWeight(app *appv1alpha1.Application) int32 {
+	if !canaryRunning(app) {
+		return 0
+	}
+
+	return app.Status.Rollout.Weight
+}
+
+// rolloutWeights returns the canary traffic percentage of every step, step 0 runs the canary without traffic
+func rolloutWeights(app *appv1alpha1.Application) []int32 {
+	weights := []int32{0}
+	if app.Spec.Rollout.Strategy == appv1alpha1.RolloutStrategyCanary {
+		weights = append(weights, app.Spec.Rollout.Steps...)
+	}
+
+	return weights
+}
+
+// recordStep appends the current step of r to its history
+func recordStep(r *appv1alpha1.RolloutStatus, message string) {
+	r.Message = message
+	r.History = append(r.History, appv1alpha1.RolloutStepRecord{
+		Phase:   r.Phase,
+		Step:    r.Step,
+		Weight:  r.Weight,
+		Time:    metav1.Now(),
+		Message: message,
+	})
+}
+
+// getDeployment returns the Deployment key, nil if it doesn't exist
+func (ar *appReconciler) getDeployment(ctx context.Context, key client.ObjectKey) (*appsv1.Deployment, error) {
+	deployment := &appsv1.Deployment{}
+	if err := ar.client.Get(ctx, key, deployment); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting deployment %s: %w", key, err)
+	}
+
+	return deployment, nil
+}
+
+// runsImage returns whether the application container of deployment runs the image ref
+func runsImage(deployment *appsv1.Deployment, ref string) bool {
+	containers := deployment.Spec.Template.Spec.Containers
+	return len(containers) > 0 && containers[0].Image == renderedImage(ref)
+}
+
+// deploymentFailed returns whether deployment exceeded its progress deadline, it won't become available
+func deploymentFailed(deployment *appsv1.Deployment) (bool, string) {
+	for _, c := range deployment.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Status == corev1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded" {
+			return true, c.Message
+		}
+	}
+
+	return false, ""
+}
+
+// reconcileRollout advances the rollout of a new image of app by one step when its canary is healthy and aborts
+// it when the canary fails. The rollout is driven by the status of app, which the objects are rendered from.
+func (ar *appReconciler) reconcileRollout(ctx context.Context, app *appv1alpha1.Application) (ctrl.Result, error) {
+	if app.Spec.Rollout == nil {
+		app.Status.Rollout = nil
+		meta.RemoveStatusCondition(&app.Status.Conditions, appv1alpha1.ConditionTypeRolledOut)
+		return ctrl.Result{}, nil
+	}
+
+	status := app.Status.Rollout
+	if status == nil {
+		// an Application deployed before its rollout was configured rolls out from the image it runs
+		status = &appv1alpha1.RolloutStatus{StableImage: app.Status.Image}
+		stable, err := ar.getDeployment(ctx, client.ObjectKey{Namespace: app.Spec.Namespace, Name: app.Name})
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if stable != nil && len(stable.Spec.Template.Spec.Containers) > 0 {
+			status.StableImage = stable.Spec.Template.Spec.Containers[0].Image
+		}
+		app.Status.Rollout = status
+	}
+
+	image := app.Status.Image
+	switch {
+	case renderedImage(image) == renderedImage(status.StableImage):
+		// the stable image is desired again, a rollout in progress or aborted is stopped
+		if status.Phase != "" && status.Phase != appv1alpha1.RolloutPhaseSucceeded {
+			status.Phase = appv1alpha1.RolloutPhaseSucceeded
+			status.Weight = 0
+			recordStep(status, fmt.Sprintf("rollout of %s stopped, %s is the desired image again", status.CanaryImage, image))
+			status.CanaryImage = ""
+		}
+	case renderedImage(image) != renderedImage(status.CanaryImage):
+		// a new image starts a new rollout, superseding one in progress
+		*status = appv1alpha1.RolloutStatus{
+			Phase:         appv1alpha1.RolloutPhaseProgressing,
+			StableImage:   status.StableImage,
+			CanaryImage:   image,
+			StepStartTime: toPtr(metav1.Now()),
+		}
+		recordStep(status, fmt.Sprintf("rolling out %s without traffic", image))
+	}
+
+	var res ctrl.Result
+	var err error
+	switch status.Phase {
+	case appv1alpha1.RolloutPhaseProgressing:
+		res, err = ar.progressRollout(ctx, app)
+	case appv1alpha1.RolloutPhasePromoting:
+		err = ar.promoteRollout(ctx, app)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	switch status.Phase {
+	case appv1alpha1.RolloutPhaseProgressing, appv1alpha1.RolloutPhasePromoting:
+		setCondition(app, appv1alpha1.ConditionTypeRolledOut, metav1.ConditionFalse, reasonRollingOut, status.Message)
+	case appv1alpha1.RolloutPhaseAborted:
+		setCondition(app, appv1alpha1.ConditionTypeRolledOut, metav1.ConditionFalse, reasonRolloutAborted, status.Message)
+	default:
+		setCondition(app, appv1alpha1.ConditionTypeRolledOut, metav1.ConditionTrue, reasonRolledOut, fmt.Sprintf("%s serves all traffic", status.StableImage))
+	}
+
+	return res, nil
+}
+
+// progressRollout shifts the next step of traffic to the canary once it has been available for the step interval,
+// and starts promoting it after the last step
+func (ar *appReconciler) progressRollout(ctx context.Context, app *appv1alpha1.Application) (ctrl.Result, error) {
+	rollout := app.Spec.Rollout
+	status := app.Status.Rollout
+
+	canary, err := ar.getDeployment(ctx, client.ObjectKey{Namespace: app.Spec.Namespace, Name: canaryName(app)})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	available, message := false, "waiting for the canary deployment"
+	if canary != nil && runsImage(canary, status.CanaryImage) {
+		if failed, reason := deploymentFailed(canary); failed {
+			abortRollout(status, fmt.Sprintf("canary %s failed: %s", status.CanaryImage, reason))
+			return ctrl.Result{}, nil
+		}
+		available, message = deploymentAvailable(canary)
+	}
+
+	elapsed := time.Since(status.StepStartTime.Time)
+	if !available {
+		if elapsed >= rollout.ProgressDeadline.Duration {
+			abortRollout(status, fmt.Sprintf("canary %s not available after %s: %s", status.CanaryImage, rollout.ProgressDeadline.Duration, message))
+			return ctrl.Result{}, nil
+		}
+
+		// the canary Deployment is watched, the requeue only catches the deadline
+		status.Message = fmt.Sprintf("step %d: %s", status.Step, message)
+		return ctrl.Result{RequeueAfter: rollout.ProgressDeadline.Duration - elapsed}, nil
+	}
+
+	if elapsed < rollout.StepInterval.Duration {
+		status.Message = fmt.Sprintf("step %d: canary %s is available with %d%% of traffic", status.Step, status.CanaryImage, status.Weight)
+		return ctrl.Result{RequeueAfter: rollout.StepInterval.Duration - elapsed}, nil
+	}
+
+	weights := rolloutWeights(app)
+	status.Step++
+	status.StepStartTime = toPtr(metav1.Now())
+	if int(status.Step) < len(weights) {
+		status.Weight = weights[status.Step]
+		recordStep(status, fmt.Sprintf("shifted %d%% of traffic to %s", status.Weight, status.CanaryImage))
+		return ctrl.Result{RequeueAfter: rollout.StepInterval.Duration}, nil
+	}
+
+	status.Phase = appv1alpha1.RolloutPhasePromoting
+	status.Weight = 100
+	recordStep(status, fmt.Sprintf("shifted all traffic to %s, promoting it to stable", status.CanaryImage))
+	return ctrl.Result{}, nil
+}
+
+// promoteRollout completes the rollout once the stable Deployment runs the canary image and is available, the
+// canary Deployment is removed and all traffic is shifted back to the stable Deployment
+func (ar *appReconciler) promoteRollout(ctx context.Context, app *appv1alpha1.Application) error {
+	status := app.Status.Rollout
+	stable, err := ar.getDeployment(ctx, client.ObjectKey{Namespace: app.Spec.Namespace, Name: app.Name})
+	if err != nil {
+		return err
+	}
+
+	if stable == nil || !runsImage(stable, status.CanaryImage) {
+		status.Message = fmt.Sprintf("updating the stable deployment to %s", status.CanaryImage)
+		return nil
+	}
+	if available, message := deploymentAvailable(stable); !available {
+		status.Message = fmt.Sprintf("updating the stable deployment to %s: %s", status.CanaryImage, message)
+		return nil
+	}
+
+	status.Phase = appv1alpha1.RolloutPhaseSucceeded
+	status.StableImage = status.CanaryImage
+	status.CanaryImage = ""
+	status.Weight = 0
+	recordStep(status, fmt.Sprintf("promoted %s to stable", status.StableImage))
+	return nil
+}
+
+// abortRollout shifts all traffic back to the stable image, the canary image isn't rolled out again
+func abortRollout(status *appv1alpha1.RolloutStatus, message string) {
+	status.Phase = appv1alpha1.RolloutPhaseAborted
+	status.Weight = 0
+	recordStep(status, message+", rolled back to "+status.StableImage)
+}
+
+// configureRollout labels the pods of the stable deployment so the stable Service selects them
+func configureRollout(app *appv1alpha1.Application, deployment *appsv1.Deployment) {
+	if app.Spec.Rollout == nil {
+		return
+	}
+
+	if deployment.Spec.Template.Labels == nil {
+		deployment.Spec.Template.Labels = map[string]string{}
+	}
+	deployment.Spec.Template.Labels[trackLabel] = trackStable
+}
+
+// configureRolloutService makes the Service of app select only the stable pods unless an SMI TrafficSplit
+// splits its traffic, the canary only gets the traffic its weight sends to the canary Service
+func configureRolloutService(app *appv1alpha1.Application, service *corev1.Service) {
+	if app.Spec.Rollout == nil || app.Spec.Rollout.TrafficRouting == appv1alpha1.TrafficRoutingSMI {
+		return
+	}
+
+	if service.Spec.Selector == nil {
+		service.Spec.Selector = map[string]string{}
+	}
+	service.Spec.Selector[trackLabel] = trackStable
+}
+
+// canaryDeployment returns a copy of the stable deployment running the canary image, nil when no rollout is in
+// progress
+func canaryDeployment(app *appv1alpha1.Application, deployment *appsv1.Deployment) *appsv1.Deployment {
+	if !canaryRunning(app) {
+		return nil
+	}
+
+	canary := deployment.DeepCopy()
+	canary.Name = canaryName(app)
+	canary.Spec.Selector = canary.Spec.Selector.DeepCopy()
+	if canary.Spec.Selector.MatchLabels == nil {
+		canary.Spec.Selector.MatchLabels = map[string]string{}
+	}
+	canary.Spec.Selector.MatchLabels[trackLabel] = trackCanary
+	canary.Spec.Template.Labels[trackLabel] = trackCanary
+	canary.Spec.Template.Spec.Containers[0].Image = renderedImage(app.Status.Rollout.CanaryImage)
+
+	return canary
+}
+
+// rolloutServices returns the Services selecting the stable and canary pods, the backends traffic is split
+// between. They are copies of service with the track added to its selector.
+func rolloutServices(app *appv1alpha1.Application, service *corev1.Service) []*corev1.Service {
+	if app.Spec.Rollout == nil {
+		return nil
+	}
+
+	var services []*corev1.Service
+	for _, track := range []string{trackStable, trackCanary} {
+		s := service.DeepCopy()
+		s.Name = app.Name + "-" + track
+		s.Spec.Type = corev1.ServiceTypeClusterIP
+		s.Spec.Selector = map[string]string{}
+		for k, v := range service.Spec.Selector {
+			s.Spec.Selector[k] = v
+		}
+		s.Spec.Selector[trackLabel] = track
+		for i := range s.Spec.Ports {
+			s.Spec.Ports[i].NodePort = 0
+		}
+		services = append(services, s)
+	}
+
+	return services
+}
+
+// trafficSplit returns the SMI TrafficSplit sending the canary weight of the traffic to the Service of app to
+// the canary Service. It is unstructured as OSM serves TrafficSplit without a go client.
+func trafficSplit(app *appv1alpha1.Application) client.Object {
+	weight := int64(canaryWeight(app))
+
+	split := &unstructured.Unstructured{}
+	split.SetGroupVersionKind(trafficSplitGVK)
+	split.SetName(app.Name)
+	split.SetNamespace(app.Spec.Namespace)
+	split.Object["spec"] = map[string]interface{}{
+		"service": app.Name,
+		"backends": []interface{}{
+			map[string]interface{}{"service": stableName(app), "weight": 100 - weight},
+			map[string]interface{}{"service": canaryName(app), "weight": weight},
+		},
+	}
+
+	return split
+}
+
+// httpBackendRefs returns the backends of the HTTPRoute of app, the stable and canary Services weighted by the
+// canary weight when traffic is split by the Gateway
+func httpBackendRefs(app *appv1alpha1.Application, port int32) []gatewayv1.HTTPBackendRef {
+	ref := func(name string, weight *int32) gatewayv1.HTTPBackendRef {
+		return gatewayv1.HTTPBackendRef{
+			BackendRef: gatewayv1.BackendRef{
+				BackendObjectReference: gatewayv1.BackendObjectReference{
+					Name: gatewayv1.ObjectName(name),
+					Port: toPtr(gatewayv1.PortNumber(port)),
+				},
+				Weight: weight,
+			},
+		}
+	}
+
+	if app.Spec.Rollout == nil || app.Spec.Rollout.TrafficRouting != appv1alpha1.TrafficRoutingGateway {
+		return []gatewayv1.HTTPBackendRef{ref(app.Name, nil)}
+	}
+
+	weight := canaryWeight(app)
+	return []gatewayv1.HTTPBackendRef{
+		ref(stableName(app), toPtr(100-weight)),
+		ref(canaryName(app), toPtr(weight)),
+	}
+}
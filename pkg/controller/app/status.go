@@ -0,0 +1,181 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Condition reasons set by the controller
+const (
+	reasonResolved          = "Resolved"
+	reasonResolveFailed     = "ResolveFailed"
+	reasonCredentialsFailed = "CredentialsFailed"
+	reasonInvalidSpec       = "InvalidSpec"
+	reasonPrebuiltImage     = "PrebuiltImage"
+	reasonDockerfileFound   = "DockerfileFound"
+	reasonDownloadFailed    = "DownloadFailed"
+	reasonGenerateFailed    = "GenerateFailed"
+	reasonWaitingForMerge   = "WaitingForMerge"
+	reasonWaitingForTag     = "WaitingForTag"
+	reasonBuilding          = "Building"
+	reasonBuildSucceeded    = "BuildSucceeded"
+	reasonBuildFailed       = "BuildFailed"
+	reasonScheduleFailed    = "ScheduleFailed"
+	reasonApplied           = "Applied"
+	reasonApplyFailed       = "ApplyFailed"
+	reasonCommitted         = "Committed"
+	reasonPullRequestOpened = "PullRequestOpened"
+	reasonCommitFailed      = "CommitFailed"
+	reasonAvailable         = "Available"
+	reasonProgressing       = "Progressing"
+	reasonWorkloadMissing   = "WorkloadMissing"
+	reasonMounted           = "Mounted"
+	reasonMountPending      = "MountPending"
+	reasonRollingOut        = "RollingOut"
+	reasonRolledOut         = "RolledOut"
+	reasonRolloutAborted    = "RolloutAborted"
+	reasonRolledBack        = "RolledBack"
+	reasonRevisionNotFound  = "RevisionNotFound"
+	reasonEnrolled          = "Enrolled"
+	reasonMeshConflict      = "MeshConflict"
+)
+
+func setCondition(app *appv1alpha1.Application, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	app.SetCondition(metav1.Condition{
+		Type:    conditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+func conditionIs(app *appv1alpha1.Application, conditionType string, status metav1.ConditionStatus) bool {
+	c := app.GetCondition(conditionType)
+	return c != nil && c.Status == status
+}
+
+func conditionReason(app *appv1alpha1.Application, conditionType string) string {
+	if c := app.GetCondition(conditionType); c != nil {
+		return c.Reason
+	}
+	return ""
+}
+
+// setPhase summarizes the conditions of app into its phase
+func setPhase(app *appv1alpha1.Application) {
+	switch {
+	case conditionReason(app, appv1alpha1.ConditionTypeDockerfileReady) == reasonWaitingForMerge:
+		app.Status.Phase = appv1alpha1.ApplicationPhaseWaitingForDockerfile
+	case conditionIs(app, appv1alpha1.ConditionTypeSourceReady, metav1.ConditionFalse),
+		conditionIs(app, appv1alpha1.ConditionTypeDockerfileReady, metav1.ConditionFalse),
+		conditionIs(app, appv1alpha1.ConditionTypeBuildSucceeded, metav1.ConditionFalse),
+		conditionIs(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionFalse),
+		conditionReason(app, appv1alpha1.ConditionTypeRolledOut) == reasonRolloutAborted:
+		app.Status.Phase = appv1alpha1.ApplicationPhaseFailed
+	case app.Status.Run != nil && app.Status.Run.State == appv1alpha1.RunStateRunning:
+		app.Status.Phase = appv1alpha1.ApplicationPhaseBuilding
+	case conditionReason(app, appv1alpha1.ConditionTypeRolledOut) == reasonRollingOut:
+		app.Status.Phase = appv1alpha1.ApplicationPhaseDeploying
+	// the GitOps agent applies committed manifests, their availability isn't observed
+	case conditionIs(app, appv1alpha1.ConditionTypeAvailable, metav1.ConditionTrue),
+		conditionReason(app, appv1alpha1.ConditionTypeDeployed) == reasonCommitted:
+		app.Status.Phase = appv1alpha1.ApplicationPhaseReady
+	case conditionIs(app, appv1alpha1.ConditionTypeDeployed, metav1.ConditionTrue):
+		app.Status.Phase = appv1alpha1.ApplicationPhaseDeploying
+	default:
+		app.Status.Phase = appv1alpha1.ApplicationPhaseBuilding
+	}
+}
+
+// deploymentAvailable returns whether deployment has fully rolled out and is available, and why not if it isn't
+func deploymentAvailable(deployment *appsv1.Deployment) (bool, string) {
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return false, "waiting for deployment spec to be observed"
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	if deployment.Status.UpdatedReplicas < replicas {
+		return false, fmt.Sprintf("%d of %d replicas updated", deployment.Status.UpdatedReplicas, replicas)
+	}
+	if deployment.Status.AvailableReplicas < deployment.Status.UpdatedReplicas {
+		return false, fmt.Sprintf("%d of %d updated replicas available", deployment.Status.AvailableReplicas, deployment.Status.UpdatedReplicas)
+	}
+
+	for _, c := range deployment.Status.Conditions {
+		if c.Type == appsv1.DeploymentAvailable && c.Status != corev1.ConditionTrue {
+			return false, c.Message
+		}
+	}
+
+	return true, ""
+}
+
+// serviceEndpoint returns the address service can be reached at, the load balancer address when it has one
+// and the in-cluster address otherwise
+func serviceEndpoint(service *corev1.Service) string {
+	var port int32
+	if len(service.Spec.Ports) > 0 {
+		port = service.Spec.Ports[0].Port
+	}
+
+	if service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			host := ingress.IP
+			if ingress.Hostname != "" {
+				host = ingress.Hostname
+			}
+			if host != "" {
+				return fmt.Sprintf("%s:%d", host, port)
+			}
+		}
+	}
+
+	return fmt.Sprintf("%s.%s.svc.cluster.local:%d", service.Name, service.Namespace, port)
+}
+
+// reconcileAvailability reports the availability of the rendered Deployment in the status of app
+func (ar *appReconciler) reconcileAvailability(ctx context.Context, app *appv1alpha1.Application) error {
+	foundDeployment := false
+	for _, r := range app.Status.Resources {
+		// the template renders the Deployment named after the app, rollouts add canary copies
+		if r.Name != app.Name {
+			continue
+		}
+
+		key := client.ObjectKey{Namespace: r.Namespace, Name: r.Name}
+		if r.Kind == "Deployment" && r.APIVersion == appsv1.SchemeGroupVersion.String() {
+			deployment := &appsv1.Deployment{}
+			if err := ar.client.Get(ctx, key, deployment); err != nil {
+				// the cache hasn't seen a just created deployment yet, its watch requeues the app
+				if apierrors.IsNotFound(err) {
+					setCondition(app, appv1alpha1.ConditionTypeAvailable, metav1.ConditionFalse, reasonProgressing, "waiting for deployment "+key.String())
+					return nil
+				}
+				return fmt.Errorf("getting deployment %s: %w", key, err)
+			}
+
+			foundDeployment = true
+			if available, message := deploymentAvailable(deployment); available {
+				setCondition(app, appv1alpha1.ConditionTypeAvailable, metav1.ConditionTrue, reasonAvailable, "deployment is available")
+			} else {
+				setCondition(app, appv1alpha1.ConditionTypeAvailable, metav1.ConditionFalse, reasonProgressing, message)
+			}
+		}
+	}
+
+	if !foundDeployment {
+		setCondition(app, appv1alpha1.ConditionTypeAvailable, metav1.ConditionFalse, reasonWorkloadMissing, "no deployment was rendered")
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"github.com/bfoley13/appcontroller/pkg/source"
+	"golang.org/x/mod/semver"
+)
+
+// maxTagLength is the longest tag a registry accepts
+const maxTagLength = 128
+
+// invalidTagChars are the characters an image tag can't hold
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// tagLister is implemented by the providers that list the tags of a repository, semver tagging needs it
+type tagLister interface {
+	CommitTags(ctx context.Context, owner, repo, sha string) ([]string, error)
+}
+
+// tagCacheTTL is how long the listed tags of a commit are reused, a version tagged onto a commit is seen at most
+// this long after it is pushed
+const tagCacheTTL = 5 * time.Minute
+
+// tagCache keeps the tags of commits for tagCacheTTL, so reconciles of a commit that is built or waiting for a tag
+// don't list its tags every time
+type tagCache struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cachedTags
+}
+
+type cachedTags struct {
+	tags    []string
+	expires time.Time
+}
+
+// newTagCache returns an empty tagCache reading the time from now
+func newTagCache(now func() time.Time) *tagCache {
+	return &tagCache{now: now, entries: map[string]cachedTags{}}
+}
+
+// commitTags returns the tags of commit, listed by lister unless they were listed within tagCacheTTL. A nil cache
+// lists them every time.
+func (c *tagCache) commitTags(ctx context.Context, lister tagLister, repo *appv1alpha1.Repository, commit string) ([]string, error) {
+	if c == nil {
+		return lister.CommitTags(ctx, repo.Owner, repo.Name, commit)
+	}
+
+	key := fmt.Sprintf("%s/%s/%s@%s", repo.GetProvider(), repo.Owner, repo.Name, commit)
+	c.mu.Lock()
+	cached, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && c.now().Before(cached.expires) {
+		return cached.tags, nil
+	}
+
+	tags, err := lister.CommitTags(ctx, repo.Owner, repo.Name, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// expired entries are dropped as new ones are added, commits that aren't reconciled anymore don't pile up
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedTags{tags: tags, expires: now.Add(tagCacheTTL)}
+	return tags, nil
+}
+
+// semverTag returns the highest semantic version tag of commit, empty when it has none. Tags are versions with or
+// without a v prefix, shortened versions like v1 aren't. The tags are read through cache.
+func semverTag(ctx context.Context, cache *tagCache, provider source.SourceProvider, repo *appv1alpha1.Repository, commit string) (string, error) {
+	lister, ok := provider.(tagLister)
+	if !ok {
+		return "", fmt.Errorf("tags of %s repositories can't be listed", repo.GetProvider())
+	}
+
+	tags, err := cache.commitTags(ctx, lister, repo, commit)
+	if err != nil {
+		return "", fmt.Errorf("listing tags of commit %s: %w", commit, err)
+	}
+
+	var highest, highestVersion string
+	for _, tag := range tags {
+		version := "v" + strings.TrimPrefix(tag, "v")
+		if semver.Canonical(version) != strings.TrimSuffix(version, semver.Build(version)) {
+			continue
+		}
+		if highest == "" || semver.Compare(version, highestVersion) > 0 {
+			highest, highestVersion = tag, version
+		}
+	}
+
+	return highest, nil
+}
+
+// imageTag returns the tag the image of app built from commit at now is pushed with, version is the semver tag of
+// commit for the semver strategy
+func imageTag(app *appv1alpha1.Application, commit, version string, now time.Time) string {
+	switch app.GetTagStrategy() {
+	case appv1alpha1.TagStrategyCommit:
+		return commit
+	case appv1alpha1.TagStrategyBranchCommitTimestamp:
+		suffix := fmt.Sprintf("-%s-%d", shortCommit(commit), now.Unix())
+		branch := sanitizeTag(app.Spec.Repository.BranchName)
+		if len(branch)+len(suffix) > maxTagLength {
+			branch = branch[:maxTagLength-len(suffix)]
+		}
+		return branch + suffix
+	case appv1alpha1.TagStrategySemver:
+		// tags can't hold the + of build metadata, registries conventionally replace it with _
+		return sanitizeTag(strings.ReplaceAll(version, "+", "_"))
+	default:
+		return app.Spec.DockerConfig.ImageTag
+	}
+}
+
+// shortCommit returns the abbreviated SHA of commit
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+// sanitizeTag replaces the characters of tag an image tag can't hold with - and shortens it to the longest tag
+func sanitizeTag(tag string) string {
+	tag = invalidTagChars.ReplaceAllString(tag, "-")
+	if strings.HasPrefix(tag, ".") || strings.HasPrefix(tag, "-") {
+		tag = "_" + tag[1:]
+	}
+	if len(tag) > maxTagLength {
+		tag = tag[:maxTagLength]
+	}
+	return tag
+}
+
+// pinnedImage returns image referenced by digest, deployments of it don't change when the tag is pushed again.
+// image is returned as is when the digest is unknown or it already has one.
+func pinnedImage(image, digest string) string {
+	if digest == "" || strings.Contains(image, "@") {
+		return image
+	}
+	return image + "@" + digest
+}
@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	appv1apha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"github.com/bfoley13/appcontroller/pkg/azure"
 	"github.com/bfoley13/appcontroller/pkg/controller/app"
+	"github.com/bfoley13/appcontroller/pkg/github"
+	"github.com/bfoley13/appcontroller/pkg/source"
 	"github.com/go-logr/logr"
 	cfgv1alpha2 "github.com/openservicemesh/osm/pkg/apis/config/v1alpha2"
 	policyv1alpha1 "github.com/openservicemesh/osm/pkg/apis/policy/v1alpha1"
@@ -22,12 +26,15 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
 	secv1 "sigs.k8s.io/secrets-store-csi-driver/apis/v1"
 	"sigs.k8s.io/yaml"
 )
 
 const (
 	crdPath = "/crd"
+	// defaultGitHubWebhookAddr is where the github push webhook is served unless GITHUB_WEBHOOK_ADDR is set
+	defaultGitHubWebhookAddr = ":8082"
 )
 
 var scheme = runtime.NewScheme()
@@ -56,6 +63,7 @@ func registerSchemes(s *runtime.Scheme) {
 	utilruntime.Must(cfgv1alpha2.AddToScheme(s))
 	utilruntime.Must(policyv1alpha1.AddToScheme(s))
 	utilruntime.Must(apiextensionsv1.AddToScheme(s))
+	utilruntime.Must(gatewayv1.AddToScheme(s))
 }
 
 func NewManager() (ctrl.Manager, error) {
@@ -84,14 +92,82 @@ func NewManager() (ctrl.Manager, error) {
 		return nil, fmt.Errorf("loading crds: %w", err)
 	}
 
-	if err = app.NewReconciler(mgr); err != nil {
+	azureConfig, err := azure.ConfigFromEnv()
+	if err != nil {
+		setupLog.Error(err, "unable to read azure config")
+		return nil, fmt.Errorf("reading azure config: %w", err)
+	}
+
+	// one factory for the lifetime of the manager so the credential and its token cache are shared
+	azureClients, err := azure.NewClientFactory(azureConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to create azure client factory")
+		return nil, fmt.Errorf("creating azure client factory: %w", err)
+	}
+
+	// installation tokens of GitHub Apps are shared by builds and polls of all repositories they can read
+	providers := source.NewProviders(mgr.GetClient(), github.NewTokenCache(nil), source.DefaultProviders(os.Getenv("GITHUB_TOKEN")))
+	pushes, err := addSourceWatchers(mgr, providers)
+	if err != nil {
+		setupLog.Error(err, "unable to add source watchers")
+		return nil, fmt.Errorf("adding source watchers: %w", err)
+	}
+
+	if err = app.NewReconciler(mgr, azureClients, providers, pushes); err != nil {
 		setupLog.Error(err, "unable to create app reconciler")
 		return nil, fmt.Errorf("creating app reconciler: %w", err)
 	}
 
+	// the webhook server needs serving certificates, clusters without them can run with ENABLE_WEBHOOKS=false
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = appv1apha1.SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create app webhooks")
+			return nil, fmt.Errorf("creating app webhooks: %w", err)
+		}
+	}
+
 	return mgr, nil
 }
 
+// addSourceWatchers adds the push webhook when a secret to validate deliveries with is configured and the branch
+// poller of all providers when a poll interval is configured, both queue rebuilds through the returned events. The
+// events are nil when neither is configured.
+func addSourceWatchers(mgr ctrl.Manager, providers *source.Providers) (*source.Events, error) {
+	events := source.NewEvents()
+	watching := false
+
+	if secret := os.Getenv("GITHUB_WEBHOOK_SECRET"); secret != "" {
+		addr := os.Getenv("GITHUB_WEBHOOK_ADDR")
+		if addr == "" {
+			addr = defaultGitHubWebhookAddr
+		}
+
+		webhook := source.NewGitHubWebhook(mgr.GetClient(), secret, events)
+		if err := mgr.Add(source.NewGitHubWebhookServer(addr, webhook)); err != nil {
+			return nil, fmt.Errorf("adding github webhook server: %w", err)
+		}
+		watching = true
+	}
+
+	if interval := os.Getenv("GITHUB_POLL_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid GITHUB_POLL_INTERVAL %q, expected a positive duration", interval)
+		}
+
+		poller := source.NewPoller(mgr.GetClient(), providers, d, events)
+		if err := mgr.Add(poller); err != nil {
+			return nil, fmt.Errorf("adding branch poller: %w", err)
+		}
+		watching = true
+	}
+
+	if !watching {
+		return nil, nil
+	}
+	return events, nil
+}
+
 // loadCRDs loads the CRDs from the specified path into the cluster
 func loadCRDs(c client.Client, log logr.Logger) error {
 	log = log.WithValues("crdPath", crdPath)
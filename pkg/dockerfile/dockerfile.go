@@ -0,0 +1,281 @@
+// Package dockerfile generates the Dockerfile of a repository that lacks one with the language templates of draft
+package dockerfile
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bfoley13/draft/pkg/languages"
+	"github.com/bfoley13/draft/pkg/linguist"
+	"github.com/bfoley13/draft/pkg/reporeader"
+	"github.com/bfoley13/draft/template"
+)
+
+// ErrNoLanguage is returned when draft has no template for any language detected in a repository
+var ErrNoLanguage = errors.New("no supported language detected")
+
+// linguistMu serializes language detection, linguist keeps the attributes of the directory it processes in package state
+var linguistMu sync.Mutex
+
+// Generated are the files rendered for a repository, named relative to its build context
+type Generated struct {
+	Language string
+	Files    map[string][]byte
+}
+
+// Names returns the names of the generated files in a stable order
+func (g *Generated) Names() []string {
+	names := make([]string, 0, len(g.Files))
+	for name := range g.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Extract writes the files of a repository archive, a gzipped tarball or a zip, to dir. It returns the root of the
+// repository, archives with a single top level directory are rooted in it.
+func Extract(archive []byte, dir string) (string, error) {
+	var err error
+	switch {
+	case bytes.HasPrefix(archive, []byte{0x1f, 0x8b}):
+		err = extractTarball(archive, dir)
+	case bytes.HasPrefix(archive, []byte("PK\x03\x04")):
+		err = extractZip(archive, dir)
+	default:
+		return "", errors.New("unsupported archive format, expected a gzipped tarball or zip")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
+
+func extractTarball(archive []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return fmt.Errorf("reading gzip: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading tarball: %w", err)
+		}
+
+		// links aren't needed to detect a language and could point outside of dir
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := writeFile(dir, header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(archive []byte, dir string) error {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return fmt.Errorf("reading zip: %w", err)
+	}
+
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", f.Name, err)
+		}
+		err = writeFile(dir, f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFile writes r to name in dir, names escaping dir are rejected
+func writeFile(dir, name string, r io.Reader) error {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return fmt.Errorf("archive entry %s is outside of the repository", name)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return nil
+}
+
+// Generate detects the language of the source in dir and renders the Dockerfile and .dockerignore draft has for
+// it, exposing port
+func Generate(dir, port string) (*Generated, error) {
+	supported := languages.CreateLanguagesFromEmbedFS(template.Dockerfiles, ".")
+	lang, err := detectLanguage(dir, supported)
+	if err != nil {
+		return nil, err
+	}
+
+	langConfig := supported.GetConfig(lang)
+	if langConfig == nil {
+		return nil, fmt.Errorf("no template config for language %s", lang)
+	}
+
+	// some languages read defaults like the entrypoint from the source
+	defaults, err := supported.ExtractDefaults(lang, &dirReader{dir: dir})
+	if err != nil {
+		return nil, fmt.Errorf("extracting defaults: %w", err)
+	}
+	for name, value := range defaults {
+		langConfig.SetVariable(name, value)
+	}
+	langConfig.SetVariable("PORT", port)
+
+	files := &memoryWriter{files: map[string][]byte{}}
+	if err := supported.CreateDockerfileForLanguage(lang, langConfig, files); err != nil {
+		return nil, fmt.Errorf("creating dockerfile for language %s: %w", lang, err)
+	}
+
+	return &Generated{Language: lang, Files: files.files}, nil
+}
+
+// detectLanguage returns the most used language in dir that draft has a template for. The build tool questions
+// draft asks interactively are answered by the files in dir.
+func detectLanguage(dir string, supported *languages.Languages) (string, error) {
+	linguistMu.Lock()
+	langs, err := linguist.ProcessDir(dir)
+	linguistMu.Unlock()
+	if err != nil {
+		return "", fmt.Errorf("detecting language: %w", err)
+	}
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	for _, lang := range langs {
+		switch lang.Language {
+		case "Go":
+			if exists("go.mod") {
+				lang.Language = "Go Module"
+			}
+		case "Java":
+			if exists("gradlew") {
+				lang.Language = "Gradlew"
+			} else if exists("build.gradle") || exists("build.gradle.kts") {
+				lang.Language = "Gradle"
+			}
+		}
+
+		name := strings.ToLower(linguist.Alias(lang).Language)
+		if supported.ContainsLanguage(name) {
+			return name, nil
+		}
+	}
+
+	return "", ErrNoLanguage
+}
+
+// memoryWriter collects the files draft renders
+type memoryWriter struct {
+	files map[string][]byte
+}
+
+func (w *memoryWriter) EnsureDirectory(string) error {
+	return nil
+}
+
+func (w *memoryWriter) WriteFile(name string, content []byte) error {
+	w.files[name] = content
+	return nil
+}
+
+// dirReader reads the repository in dir for draft's default extractors, which pass paths relative to its root
+type dirReader struct {
+	dir string
+}
+
+var _ reporeader.RepoReader = &dirReader{}
+
+func (r *dirReader) Exists(path string) bool {
+	_, err := os.Stat(filepath.Join(r.dir, path))
+	return err == nil
+}
+
+func (r *dirReader) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(r.dir, path))
+}
+
+func (r *dirReader) FindFiles(path string, patterns []string, maxDepth int) ([]string, error) {
+	root := filepath.Join(r.dir, path)
+	var found []string
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(r.dir, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && strings.Count(rel, string(os.PathSeparator)) >= maxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		for _, pattern := range patterns {
+			if matched, err := filepath.Match(pattern, d.Name()); err != nil {
+				return err
+			} else if matched {
+				found = append(found, rel)
+				break
+			}
+		}
+		return nil
+	})
+
+	return found, err
+}
+
+func (r *dirReader) GetRepoName() (string, error) {
+	return filepath.Base(r.dir), nil
+}
@@ -0,0 +1,103 @@
+package dockerfile
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var goModule = map[string]string{
+	"go.mod":  "module example.com/echo\n\ngo 1.22\n",
+	"main.go": "package main\n\nimport \"net/http\"\n\nfunc main() {\n\thttp.ListenAndServe(\":8080\", nil)\n}\n",
+}
+
+// tarball returns a gzipped tarball of files under a top level directory like the ones GitHub serves
+func tarball(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "owner-repo-abc123/", Typeflag: tar.TypeDir, Mode: 0o755}))
+	for name, content := range files {
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "owner-repo-abc123/" + name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	t.Run("roots tarballs in their top level directory", func(t *testing.T) {
+		dir := t.TempDir()
+		root, err := Extract(tarball(t, goModule), dir)
+		assert.Nil(t, err)
+		assert.Equal(t, filepath.Join(dir, "owner-repo-abc123"), root)
+
+		content, err := os.ReadFile(filepath.Join(root, "go.mod"))
+		assert.Nil(t, err)
+		assert.Equal(t, goModule["go.mod"], string(content))
+	})
+
+	t.Run("extracts zips", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		zw := zip.NewWriter(buf)
+		for _, name := range []string{"go.mod", "cmd/main.go"} {
+			w, err := zw.Create(name)
+			assert.Nil(t, err)
+			_, err = w.Write([]byte("content"))
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, zw.Close())
+
+		dir := t.TempDir()
+		root, err := Extract(buf.Bytes(), dir)
+		assert.Nil(t, err)
+		assert.Equal(t, dir, root)
+		assert.FileExists(t, filepath.Join(root, "cmd", "main.go"))
+	})
+
+	t.Run("rejects entries outside of the directory", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		tw := tar.NewWriter(gz)
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0o644}))
+		assert.Nil(t, tw.Close())
+		assert.Nil(t, gz.Close())
+
+		_, err := Extract(buf.Bytes(), t.TempDir())
+		assert.ErrorContains(t, err, "outside of the repository")
+	})
+
+	t.Run("rejects other formats", func(t *testing.T) {
+		_, err := Extract([]byte("not an archive"), t.TempDir())
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGenerate(t *testing.T) {
+	t.Run("generates the files of the detected language", func(t *testing.T) {
+		root, err := Extract(tarball(t, goModule), t.TempDir())
+		assert.Nil(t, err)
+
+		generated, err := Generate(root, "8080")
+		assert.Nil(t, err)
+		assert.Equal(t, "gomodule", generated.Language)
+		assert.Equal(t, []string{".dockerignore", "Dockerfile"}, generated.Names())
+		assert.Contains(t, string(generated.Files["Dockerfile"]), "EXPOSE 8080")
+	})
+
+	t.Run("fails without a supported language", func(t *testing.T) {
+		root, err := Extract(tarball(t, map[string]string{"README.md": "# echo\n"}), t.TempDir())
+		assert.Nil(t, err)
+
+		_, err = Generate(root, "80")
+		assert.ErrorIs(t, err, ErrNoLanguage)
+	})
+}
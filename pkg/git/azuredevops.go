@@ -0,0 +1,210 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// DefaultAzureDevOpsURL is the Azure DevOps organization host repositories are on unless they set a url
+	DefaultAzureDevOpsURL = "https://dev.azure.com"
+
+	azureDevOpsAPIVersion = "7.1"
+	// zeroObjectID is the old object id of a ref that doesn't exist yet
+	zeroObjectID = "0000000000000000000000000000000000000000"
+)
+
+// AzureDevOpsService reads and writes Azure Repos with the Azure DevOps REST API, owner is "<organization>/<project>"
+type AzureDevOpsService struct {
+	baseURL string
+	client  restClient
+}
+
+// NewAzureDevOpsService returns an AzureDevOpsService for the server at baseURL authenticating with a personal access token
+func NewAzureDevOpsService(baseURL, token string) *AzureDevOpsService {
+	if baseURL == "" {
+		baseURL = DefaultAzureDevOpsURL
+	}
+
+	return &AzureDevOpsService{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client: newRESTClient(func(req *http.Request) {
+			if token != "" {
+				req.SetBasicAuth("", token)
+			}
+		}),
+	}
+}
+
+type azureRef struct {
+	Name           string `json:"name"`
+	ObjectID       string `json:"objectId"`
+	PeeledObjectID string `json:"peeledObjectId"`
+}
+
+// repositoryURL returns the api url of repo, path and query are appended to it with the api version
+func (a *AzureDevOpsService) repositoryURL(owner, repo, path string, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("api-version", azureDevOpsAPIVersion)
+
+	repoURL := fmt.Sprintf("%s/%s/_apis/git/repositories/%s", a.baseURL, escapeSegments(owner), url.PathEscape(repo))
+	if path != "" {
+		repoURL += "/" + path
+	}
+	return repoURL + "?" + query.Encode()
+}
+
+// ResolveRef returns the SHA of the commit ref points to, ref can be a branch, tag or commit
+func (a *AzureDevOpsService) ResolveRef(ctx context.Context, owner, repo, ref string) (string, error) {
+	if commitPattern.MatchString(ref) {
+		return ref, nil
+	}
+
+	// the filter is a prefix match, the exact name is looked for in the result
+	for _, kind := range []string{"heads", "tags"} {
+		refs := struct {
+			Value []azureRef `json:"value"`
+		}{}
+		query := url.Values{"filter": {kind + "/" + ref}, "peelTags": {"true"}}
+		if err := a.client.doJSON(ctx, http.MethodGet, a.repositoryURL(owner, repo, "refs", query), nil, &refs); err != nil {
+			return "", fmt.Errorf("resolving ref %s: %w", ref, err)
+		}
+
+		for _, r := range refs.Value {
+			if r.Name != "refs/"+kind+"/"+ref {
+				continue
+			}
+			if r.PeeledObjectID != "" {
+				return r.PeeledObjectID, nil
+			}
+			return r.ObjectID, nil
+		}
+	}
+
+	return "", fmt.Errorf("resolving ref %s: no branch or tag found", ref)
+}
+
+// DownloadRepo returns a zip archive of the repository at ref, a branch or commit
+func (a *AzureDevOpsService) DownloadRepo(ctx context.Context, owner, repo, ref string) ([]byte, error) {
+	versionType := "branch"
+	if commitPattern.MatchString(ref) {
+		versionType = "commit"
+	}
+
+	query := url.Values{
+		"path":                          {"/"},
+		"versionDescriptor.version":     {ref},
+		"versionDescriptor.versionType": {versionType},
+		"$format":                       {"zip"},
+		"download":                      {"true"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.repositoryURL(owner, repo, "items", query), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.client.send(req)
+}
+
+// CreateBranch creates branch from the head of base, the default branch of the repository when base is empty
+func (a *AzureDevOpsService) CreateBranch(ctx context.Context, owner, repo, branch, base string) error {
+	if base == "" {
+		repository := struct {
+			DefaultBranch string `json:"defaultBranch"`
+		}{}
+		if err := a.client.doJSON(ctx, http.MethodGet, a.repositoryURL(owner, repo, "", nil), nil, &repository); err != nil {
+			return fmt.Errorf("getting default branch: %w", err)
+		}
+		base = strings.TrimPrefix(repository.DefaultBranch, "refs/heads/")
+	}
+
+	head, err := a.ResolveRef(ctx, owner, repo, base)
+	if err != nil {
+		return fmt.Errorf("creating branch %s: %w", branch, err)
+	}
+
+	updates := []map[string]string{{
+		"name":        "refs/heads/" + branch,
+		"oldObjectId": zeroObjectID,
+		"newObjectId": head,
+	}}
+	results := struct {
+		Value []struct {
+			Success       bool   `json:"success"`
+			CustomMessage string `json:"customMessage"`
+		} `json:"value"`
+	}{}
+	if err := a.client.doJSON(ctx, http.MethodPost, a.repositoryURL(owner, repo, "refs", nil), updates, &results); err != nil {
+		return fmt.Errorf("creating branch %s: %w", branch, err)
+	}
+	for _, r := range results.Value {
+		if !r.Success {
+			return fmt.Errorf("creating branch %s: %s", branch, r.CustomMessage)
+		}
+	}
+
+	return nil
+}
+
+func (a *AzureDevOpsService) CreateFiles(ctx context.Context, owner, repo, branch, filePath string, content []byte) error {
+	head, err := a.ResolveRef(ctx, owner, repo, branch)
+	if err != nil {
+		return fmt.Errorf("creating file %s: %w", filePath, err)
+	}
+
+	push := map[string]any{
+		"refUpdates": []map[string]string{{"name": "refs/heads/" + branch, "oldObjectId": head}},
+		"commits": []map[string]any{{
+			"comment": fmt.Sprintf("creating file: %s", filePath),
+			"changes": []map[string]any{{
+				"changeType": "add",
+				"item":       map[string]string{"path": "/" + strings.TrimPrefix(filePath, "/")},
+				"newContent": map[string]string{"content": encodeBase64(content), "contentType": "base64encoded"},
+			}},
+		}},
+	}
+	if err := a.client.doJSON(ctx, http.MethodPost, a.repositoryURL(owner, repo, "pushes", nil), push, nil); err != nil {
+		return fmt.Errorf("creating file %s: %w", filePath, err)
+	}
+
+	return nil
+}
+
+// CreatePullRequest opens a pull request merging head into base and returns its url
+func (a *AzureDevOpsService) CreatePullRequest(ctx context.Context, owner, repo, head, base, title, body string) (string, error) {
+	request := map[string]string{
+		"sourceRefName": "refs/heads/" + head,
+		"targetRefName": "refs/heads/" + base,
+		"title":         title,
+		"description":   body,
+	}
+	pr := struct {
+		PullRequestID int `json:"pullRequestId"`
+	}{}
+	if err := a.client.doJSON(ctx, http.MethodPost, a.repositoryURL(owner, repo, "pullrequests", nil), request, &pr); err != nil {
+		return "", fmt.Errorf("creating pull request from %s: %w", head, err)
+	}
+
+	// the api returns the url of the api resource, the web url is built like the clone url
+	return fmt.Sprintf("%s/%s/_git/%s/pullrequest/%d", a.baseURL, escapeSegments(owner), url.PathEscape(repo), pr.PullRequestID), nil
+}
+
+// SourceURL returns the build context of contextPath in repo at ref in the form understood by ACR and BuildKit
+func (a *AzureDevOpsService) SourceURL(owner, repo, ref, contextPath string) string {
+	return fmt.Sprintf("%s/%s/_git/%s#%s:%s", a.baseURL, escapeSegments(owner), url.PathEscape(repo), ref, contextPath)
+}
+
+// escapeSegments escapes each segment of a slash separated path, project names can contain spaces
+func escapeSegments(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+
+	return strings.Join(segments, "/")
+}
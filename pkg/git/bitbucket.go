@@ -0,0 +1,149 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	bitbucketAPIURL = "https://api.bitbucket.org/2.0"
+	bitbucketWebURL = "https://bitbucket.org"
+)
+
+// BitbucketService reads and writes Bitbucket Cloud repositories, owner is the workspace of a repository
+type BitbucketService struct {
+	apiURL, webURL string
+	client         restClient
+}
+
+// NewBitbucketService returns a BitbucketService authenticating with a repository, project or workspace access token
+func NewBitbucketService(token string) *BitbucketService {
+	return &BitbucketService{
+		apiURL: bitbucketAPIURL,
+		webURL: bitbucketWebURL,
+		client: newRESTClient(func(req *http.Request) {
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+		}),
+	}
+}
+
+func (b *BitbucketService) repositoryURL(owner, repo string) string {
+	return fmt.Sprintf("%s/repositories/%s/%s", b.apiURL, url.PathEscape(owner), url.PathEscape(repo))
+}
+
+// ResolveRef returns the SHA of the commit ref points to, ref can be a branch, tag or commit
+func (b *BitbucketService) ResolveRef(ctx context.Context, owner, repo, ref string) (string, error) {
+	commit := struct {
+		Hash string `json:"hash"`
+	}{}
+	if err := b.client.doJSON(ctx, http.MethodGet, b.repositoryURL(owner, repo)+"/commit/"+url.PathEscape(ref), nil, &commit); err != nil {
+		return "", fmt.Errorf("resolving ref %s: %w", ref, err)
+	}
+
+	return commit.Hash, nil
+}
+
+// DownloadRepo returns a tarball of the repository at ref
+func (b *BitbucketService) DownloadRepo(ctx context.Context, owner, repo, ref string) ([]byte, error) {
+	archive := fmt.Sprintf("%s/%s/%s/get/%s.tar.gz", b.webURL, url.PathEscape(owner), url.PathEscape(repo), url.PathEscape(ref))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, archive, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.client.send(req)
+}
+
+// CreateBranch creates branch from the head of base, the main branch of the repository when base is empty
+func (b *BitbucketService) CreateBranch(ctx context.Context, owner, repo, branch, base string) error {
+	if base == "" {
+		repository := struct {
+			MainBranch struct {
+				Name string `json:"name"`
+			} `json:"mainbranch"`
+		}{}
+		if err := b.client.doJSON(ctx, http.MethodGet, b.repositoryURL(owner, repo), nil, &repository); err != nil {
+			return fmt.Errorf("getting main branch: %w", err)
+		}
+		base = repository.MainBranch.Name
+	}
+
+	head, err := b.ResolveRef(ctx, owner, repo, base)
+	if err != nil {
+		return fmt.Errorf("creating branch %s: %w", branch, err)
+	}
+
+	body := map[string]any{
+		"name":   branch,
+		"target": map[string]string{"hash": head},
+	}
+	if err := b.client.doJSON(ctx, http.MethodPost, b.repositoryURL(owner, repo)+"/refs/branches", body, nil); err != nil {
+		return fmt.Errorf("creating branch %s: %w", branch, err)
+	}
+
+	return nil
+}
+
+// CreateFiles commits filePath to branch, the src endpoint only accepts form uploads
+func (b *BitbucketService) CreateFiles(ctx context.Context, owner, repo, branch, filePath string, content []byte) error {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	fields := map[string]string{
+		"/" + strings.TrimPrefix(filePath, "/"): string(content),
+		"message":                               fmt.Sprintf("creating file: %s", filePath),
+		"branch":                                branch,
+	}
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			return fmt.Errorf("creating file %s: %w", filePath, err)
+		}
+	}
+	if err := form.Close(); err != nil {
+		return fmt.Errorf("creating file %s: %w", filePath, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.repositoryURL(owner, repo)+"/src", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if _, err := b.client.send(req); err != nil {
+		return fmt.Errorf("creating file %s: %w", filePath, err)
+	}
+
+	return nil
+}
+
+// CreatePullRequest opens a pull request merging head into base and returns its url
+func (b *BitbucketService) CreatePullRequest(ctx context.Context, owner, repo, head, base, title, body string) (string, error) {
+	request := map[string]any{
+		"title":       title,
+		"description": body,
+		"source":      map[string]any{"branch": map[string]string{"name": head}},
+		"destination": map[string]any{"branch": map[string]string{"name": base}},
+	}
+	pr := struct {
+		Links struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+	}{}
+	if err := b.client.doJSON(ctx, http.MethodPost, b.repositoryURL(owner, repo)+"/pullrequests", request, &pr); err != nil {
+		return "", fmt.Errorf("creating pull request from %s: %w", head, err)
+	}
+
+	return pr.Links.HTML.Href, nil
+}
+
+// SourceURL returns the build context of contextPath in repo at ref in the form understood by ACR and BuildKit
+func (b *BitbucketService) SourceURL(owner, repo, ref, contextPath string) string {
+	return fmt.Sprintf("%s/%s/%s.git#%s:%s", b.webURL, owner, repo, ref, contextPath)
+}
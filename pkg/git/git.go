@@ -0,0 +1,354 @@
+package git
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Keys of a repository credentials Secret read by the plain git provider, the token is read from the same key as for GitHub
+const (
+	UsernameKey      = "username"
+	SSHPrivateKeyKey = "sshPrivateKey"
+	KnownHostsKey    = "knownHosts"
+)
+
+// defaultUsername is the user clones authenticate as when the credentials don't name one
+const defaultUsername = "git"
+
+// Credentials authenticate against a plain git server over https with a token or over ssh with a private key
+type Credentials struct {
+	Username      string
+	Token         string
+	SSHPrivateKey []byte
+	// KnownHosts are the host keys ssh servers are verified against, in known_hosts format
+	KnownHosts []byte
+}
+
+// GitService reads a repository at a url with the git protocol. Any git server is supported, but the protocol has
+// no way to download archives or write files without a clone, so only refs can be resolved.
+type GitService struct {
+	url   string
+	creds Credentials
+}
+
+// NewGitService returns a GitService for the repository at rawURL, an https, ssh or scp-like url
+func NewGitService(rawURL string, creds Credentials) *GitService {
+	if creds.Username == "" {
+		creds.Username = defaultUsername
+	}
+
+	return &GitService{url: rawURL, creds: creds}
+}
+
+// ResolveRef returns the SHA of the commit ref points to, ref can be a branch, tag, full ref name or commit
+func (g *GitService) ResolveRef(ctx context.Context, _, _, ref string) (string, error) {
+	if commitPattern.MatchString(ref) {
+		return ref, nil
+	}
+
+	refs, err := g.lsRemote(ctx)
+	if err != nil {
+		return "", fmt.Errorf("resolving ref %s: %w", ref, err)
+	}
+
+	// annotated tags are advertised a second time peeled to the commit they point to
+	for _, name := range []string{"refs/heads/" + ref, "refs/tags/" + ref + "^{}", "refs/tags/" + ref, ref} {
+		if sha, ok := refs[name]; ok {
+			return sha, nil
+		}
+	}
+
+	return "", fmt.Errorf("resolving ref %s: no branch or tag found", ref)
+}
+
+// CommitTags returns the names of the tags pointing at commit sha
+func (g *GitService) CommitTags(ctx context.Context, _, _, sha string) ([]string, error) {
+	refs, err := g.lsRemote(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing tags: %w", err)
+	}
+
+	var tags []string
+	for name, target := range refs {
+		tag, ok := strings.CutPrefix(name, "refs/tags/")
+		if !ok || strings.HasSuffix(tag, "^{}") {
+			continue
+		}
+		// annotated tags point at a tag object, the commit is advertised as the peeled ref
+		if peeled, ok := refs[name+"^{}"]; ok {
+			target = peeled
+		}
+		if target == sha {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+
+	return tags, nil
+}
+
+func (g *GitService) DownloadRepo(context.Context, string, string, string) ([]byte, error) {
+	return nil, fmt.Errorf("downloading git repositories: %w", errors.ErrUnsupported)
+}
+
+func (g *GitService) CreateBranch(context.Context, string, string, string, string) error {
+	return fmt.Errorf("creating branches in git repositories: %w", errors.ErrUnsupported)
+}
+
+func (g *GitService) CreateFiles(context.Context, string, string, string, string, []byte) error {
+	return fmt.Errorf("creating files in git repositories: %w", errors.ErrUnsupported)
+}
+
+func (g *GitService) CreatePullRequest(context.Context, string, string, string, string, string, string) (string, error) {
+	return "", fmt.Errorf("creating pull requests in git repositories: %w", errors.ErrUnsupported)
+}
+
+// SourceURL returns the build context of contextPath in the repository at ref in the form understood by BuildKit
+func (g *GitService) SourceURL(_, _, ref, contextPath string) string {
+	return fmt.Sprintf("%s#%s:%s", g.url, ref, contextPath)
+}
+
+// IsSSHURL returns whether rawURL is an ssh:// or scp-like user@host:path url
+func IsSSHURL(rawURL string) bool {
+	if strings.HasPrefix(rawURL, "ssh://") {
+		return true
+	}
+	if strings.Contains(rawURL, "://") {
+		return false
+	}
+
+	colon := strings.Index(rawURL, ":")
+	return colon > 0 && !strings.Contains(rawURL[:colon], "/")
+}
+
+// lsRemote returns the refs the repository advertises by their name
+func (g *GitService) lsRemote(ctx context.Context) (map[string]string, error) {
+	if IsSSHURL(g.url) {
+		return g.lsRemoteSSH(ctx)
+	}
+
+	return g.lsRemoteHTTP(ctx)
+}
+
+// lsRemoteHTTP requests the ref advertisement of the smart http protocol
+func (g *GitService) lsRemoteHTTP(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(g.url, "/")+"/info/refs?service=git-upload-pack", nil)
+	if err != nil {
+		return nil, err
+	}
+	if g.creds.Token != "" {
+		req.SetBasicAuth(g.creds.Username, g.creds.Token)
+	}
+
+	client := newRESTClient(nil)
+	body, err := client.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return readAdvertisement(strings.NewReader(string(body)), true)
+}
+
+// lsRemoteSSH runs git-upload-pack on the server and hangs up after reading the ref advertisement
+func (g *GitService) lsRemoteSSH(ctx context.Context) (map[string]string, error) {
+	user, addr, path, err := parseSSHURL(g.url)
+	if err != nil {
+		return nil, err
+	}
+	if user == "" {
+		user = g.creds.Username
+	}
+
+	if len(g.creds.SSHPrivateKey) == 0 {
+		return nil, errors.New("ssh repositories need an sshPrivateKey")
+	}
+	signer, err := ssh.ParsePrivateKey(g.creds.SSHPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ssh private key: %w", err)
+	}
+	hostKeys, err := hostKeyCallback(g.creds.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing %s: %w", addr, err)
+	}
+	defer conn.Close()
+	// the handshake and command don't take a context, closing the connection unblocks them
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeys,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("opening ssh session: %w", err)
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.Start(fmt.Sprintf("git-upload-pack '%s'", strings.ReplaceAll(path, "'", `'\''`))); err != nil {
+		return nil, fmt.Errorf("running git-upload-pack: %w", err)
+	}
+
+	refs, err := readAdvertisement(stdout, false)
+	if err != nil {
+		return nil, err
+	}
+	// a flush tells the server no objects are wanted
+	_, _ = io.WriteString(stdin, flushPkt)
+	_ = stdin.Close()
+
+	return refs, nil
+}
+
+// hostKeyCallback verifies host keys against knownHosts, an unverified connection would leak the clone to anyone
+func hostKeyCallback(knownHosts []byte) (ssh.HostKeyCallback, error) {
+	if len(knownHosts) == 0 {
+		return nil, errors.New("ssh repositories need knownHosts")
+	}
+
+	// knownhosts only reads files
+	f, err := os.CreateTemp("", "known_hosts")
+	if err != nil {
+		return nil, fmt.Errorf("creating known hosts file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(knownHosts); err != nil {
+		return nil, fmt.Errorf("writing known hosts file: %w", err)
+	}
+
+	callback, err := knownhosts.New(f.Name())
+	if err != nil {
+		return nil, fmt.Errorf("parsing known hosts: %w", err)
+	}
+
+	return callback, nil
+}
+
+// parseSSHURL returns the user, host:port and repository path of an ssh:// or scp-like url
+func parseSSHURL(rawURL string) (user, addr, path string, err error) {
+	if strings.HasPrefix(rawURL, "ssh://") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return "", "", "", fmt.Errorf("parsing url %s: %w", rawURL, err)
+		}
+
+		port := u.Port()
+		if port == "" {
+			port = "22"
+		}
+		return u.User.Username(), net.JoinHostPort(u.Hostname(), port), u.Path, nil
+	}
+
+	host, path, ok := strings.Cut(rawURL, ":")
+	if !ok {
+		return "", "", "", fmt.Errorf("url %s isn't an ssh url", rawURL)
+	}
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		user, host = host[:at], host[at+1:]
+	}
+
+	return user, net.JoinHostPort(host, "22"), path, nil
+}
+
+const flushPkt = "0000"
+
+// readAdvertisement reads the refs of a git-upload-pack advertisement in pkt-line format. Over smart http it is
+// preceded by a service announcement.
+func readAdvertisement(r io.Reader, smartHTTP bool) (map[string]string, error) {
+	reader := bufio.NewReader(r)
+	if smartHTTP {
+		line, err := readPktLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "# service=") {
+			return nil, fmt.Errorf("unexpected service announcement %q, the server doesn't speak the smart http protocol", line)
+		}
+		if line, err = readPktLine(reader); err != nil || line != "" {
+			return nil, fmt.Errorf("expected flush after service announcement: %w", err)
+		}
+	}
+
+	refs := map[string]string{}
+	for {
+		line, err := readPktLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			return refs, nil
+		}
+
+		// capabilities follow the first ref after a NUL
+		line, _, _ = strings.Cut(line, "\x00")
+		sha, name, ok := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+		if !ok {
+			return nil, fmt.Errorf("malformed ref line %q", line)
+		}
+		// an empty repository advertises only its capabilities
+		if name == "capabilities^{}" {
+			continue
+		}
+		refs[name] = sha
+	}
+}
+
+// readPktLine returns the payload of the next pkt-line, empty for a flush
+func readPktLine(r io.Reader) (string, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return "", fmt.Errorf("reading pkt-line length: %w", err)
+	}
+
+	n, err := strconv.ParseUint(string(length[:]), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("parsing pkt-line length %q: %w", length, err)
+	}
+	if n == 0 {
+		return "", nil
+	}
+	if n < 4 {
+		return "", fmt.Errorf("invalid pkt-line length %d", n)
+	}
+
+	payload := make([]byte, n-4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", fmt.Errorf("reading pkt-line: %w", err)
+	}
+
+	return string(payload), nil
+}
@@ -0,0 +1,441 @@
+package git
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	testCommit = "3a0f86fb8db8eea7ccbb9a95f325ddbedfb25e15"
+	baseCommit = "1111111111111111111111111111111111111111"
+)
+
+// recorded is a request a test server received
+type recorded struct {
+	method, uri, auth string
+	body              []byte
+}
+
+// testServer answers the requests for the paths in responses and records every request
+func testServer(t *testing.T, responses map[string]string) (*httptest.Server, *[]recorded) {
+	var requests []recorded
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		auth := r.Header.Get("Authorization")
+		if token := r.Header.Get("PRIVATE-TOKEN"); token != "" {
+			auth = token
+		}
+		requests = append(requests, recorded{method: r.Method, uri: r.URL.RequestURI(), auth: auth, body: body})
+
+		resp, ok := responses[r.Method+" "+r.URL.EscapedPath()]
+		if !ok {
+			http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &requests
+}
+
+func TestGitLabService(t *testing.T) {
+	ctx := context.Background()
+	srv, requests := testServer(t, map[string]string{
+		"GET /api/v4/projects/group%2Fsub%2Fecho":                                      `{"default_branch":"trunk"}`,
+		"GET /api/v4/projects/group%2Fsub%2Fecho/repository/commits/main":              `{"id":"` + testCommit + `"}`,
+		"GET /api/v4/projects/group%2Fsub%2Fecho/repository/archive.tar.gz":            "tarball",
+		"POST /api/v4/projects/group%2Fsub%2Fecho/repository/branches":                 `{"name":"draft"}`,
+		"POST /api/v4/projects/group%2Fsub%2Fecho/repository/files/build%2FDockerfile": `{"file_path":"build/Dockerfile"}`,
+		"POST /api/v4/projects/group%2Fsub%2Fecho/merge_requests":                      `{"web_url":"https://gitlab.com/group/sub/echo/-/merge_requests/1"}`,
+	})
+	g := NewGitLabService(srv.URL+"/", "glpat")
+
+	t.Run("ResolveRef", func(t *testing.T) {
+		sha, err := g.ResolveRef(ctx, "group/sub", "echo", "main")
+		assert.Nil(t, err)
+		assert.Equal(t, testCommit, sha)
+		assert.Equal(t, "glpat", (*requests)[len(*requests)-1].auth)
+
+		_, err = g.ResolveRef(ctx, "group/sub", "echo", "missing")
+		assert.ErrorContains(t, err, "unexpected status code 404")
+	})
+
+	t.Run("DownloadRepo", func(t *testing.T) {
+		tarball, err := g.DownloadRepo(ctx, "group/sub", "echo", "main")
+		assert.Nil(t, err)
+		assert.Equal(t, "tarball", string(tarball))
+		assert.Equal(t, "/api/v4/projects/group%2Fsub%2Fecho/repository/archive.tar.gz?sha=main", (*requests)[len(*requests)-1].uri)
+	})
+
+	t.Run("CreateBranch", func(t *testing.T) {
+		assert.Nil(t, g.CreateBranch(ctx, "group/sub", "echo", "draft", "main"))
+		assert.Equal(t, "/api/v4/projects/group%2Fsub%2Fecho/repository/branches?branch=draft&ref=main", (*requests)[len(*requests)-1].uri)
+
+		assert.Nil(t, g.CreateBranch(ctx, "group/sub", "echo", "draft", ""))
+		assert.Equal(t, "/api/v4/projects/group%2Fsub%2Fecho/repository/branches?branch=draft&ref=trunk", (*requests)[len(*requests)-1].uri)
+	})
+
+	t.Run("CreateFiles", func(t *testing.T) {
+		assert.Nil(t, g.CreateFiles(ctx, "group/sub", "echo", "draft", "build/Dockerfile", []byte("FROM scratch")))
+
+		body := map[string]string{}
+		assert.Nil(t, json.Unmarshal((*requests)[len(*requests)-1].body, &body))
+		assert.Equal(t, "draft", body["branch"])
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("FROM scratch")), body["content"])
+	})
+
+	t.Run("CreatePullRequest", func(t *testing.T) {
+		url, err := g.CreatePullRequest(ctx, "group/sub", "echo", "draft", "main", "Add a Dockerfile", "")
+		assert.Nil(t, err)
+		assert.Equal(t, "https://gitlab.com/group/sub/echo/-/merge_requests/1", url)
+		assert.JSONEq(t, `{"source_branch":"draft","target_branch":"main","title":"Add a Dockerfile","description":""}`, string((*requests)[len(*requests)-1].body))
+	})
+
+	t.Run("SourceURL", func(t *testing.T) {
+		assert.Equal(t, "https://gitlab.com/group/echo.git#main:src", NewGitLabService("", "").SourceURL("group", "echo", "main", "src"))
+	})
+}
+
+func TestAzureDevOpsService(t *testing.T) {
+	ctx := context.Background()
+	srv, requests := testServer(t, map[string]string{
+		"GET /org/My%20Project/_apis/git/repositories/echo":               `{"defaultBranch":"refs/heads/main"}`,
+		"GET /org/My%20Project/_apis/git/repositories/echo/refs":          "",
+		"POST /org/My%20Project/_apis/git/repositories/echo/refs":         `{"value":[{"success":true}]}`,
+		"POST /org/My%20Project/_apis/git/repositories/echo/pushes":       `{}`,
+		"GET /org/My%20Project/_apis/git/repositories/echo/items":         "zip",
+		"POST /org/My%20Project/_apis/git/repositories/echo/pullrequests": `{"pullRequestId":7}`,
+	})
+	a := NewAzureDevOpsService(srv.URL, "pat")
+	// the refs endpoint answers by filter, the test server only matches paths
+	srv.Config.Handler = withRefs(srv.Config.Handler, map[string]string{
+		"heads/main":  `{"value":[{"name":"refs/heads/main-old","objectId":"` + baseCommit + `"},{"name":"refs/heads/main","objectId":"` + testCommit + `"}]}`,
+		"heads/draft": `{"value":[{"name":"refs/heads/draft","objectId":"` + baseCommit + `"}]}`,
+		"tags/v1":     `{"value":[{"name":"refs/tags/v1","objectId":"` + baseCommit + `","peeledObjectId":"` + testCommit + `"}]}`,
+	})
+
+	t.Run("ResolveRef", func(t *testing.T) {
+		sha, err := a.ResolveRef(ctx, "org/My Project", "echo", "main")
+		assert.Nil(t, err)
+		assert.Equal(t, testCommit, sha)
+		assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte(":pat")), (*requests)[len(*requests)-1].auth)
+
+		sha, err = a.ResolveRef(ctx, "org/My Project", "echo", "v1")
+		assert.Nil(t, err)
+		assert.Equal(t, testCommit, sha)
+
+		sha, err = a.ResolveRef(ctx, "org/My Project", "echo", testCommit)
+		assert.Nil(t, err)
+		assert.Equal(t, testCommit, sha)
+
+		_, err = a.ResolveRef(ctx, "org/My Project", "echo", "missing")
+		assert.ErrorContains(t, err, "no branch or tag found")
+	})
+
+	t.Run("DownloadRepo", func(t *testing.T) {
+		archive, err := a.DownloadRepo(ctx, "org/My Project", "echo", testCommit)
+		assert.Nil(t, err)
+		assert.Equal(t, "zip", string(archive))
+		assert.Contains(t, (*requests)[len(*requests)-1].uri, "versionDescriptor.versionType=commit")
+	})
+
+	t.Run("CreateBranch", func(t *testing.T) {
+		assert.Nil(t, a.CreateBranch(ctx, "org/My Project", "echo", "draft", ""))
+
+		var updates []map[string]string
+		assert.Nil(t, json.Unmarshal((*requests)[len(*requests)-1].body, &updates))
+		assert.Equal(t, []map[string]string{{"name": "refs/heads/draft", "oldObjectId": zeroObjectID, "newObjectId": testCommit}}, updates)
+
+		assert.Nil(t, a.CreateBranch(ctx, "org/My Project", "echo", "feature", "draft"))
+		assert.Nil(t, json.Unmarshal((*requests)[len(*requests)-1].body, &updates))
+		assert.Equal(t, []map[string]string{{"name": "refs/heads/feature", "oldObjectId": zeroObjectID, "newObjectId": baseCommit}}, updates)
+	})
+
+	t.Run("CreateFiles", func(t *testing.T) {
+		assert.Nil(t, a.CreateFiles(ctx, "org/My Project", "echo", "draft", "Dockerfile", []byte("FROM scratch")))
+
+		push := struct {
+			RefUpdates []map[string]string `json:"refUpdates"`
+		}{}
+		assert.Nil(t, json.Unmarshal((*requests)[len(*requests)-1].body, &push))
+		assert.Equal(t, []map[string]string{{"name": "refs/heads/draft", "oldObjectId": baseCommit}}, push.RefUpdates)
+	})
+
+	t.Run("CreatePullRequest", func(t *testing.T) {
+		url, err := a.CreatePullRequest(ctx, "org/My Project", "echo", "draft", "main", "Add a Dockerfile", "")
+		assert.Nil(t, err)
+		assert.Equal(t, srv.URL+"/org/My%20Project/_git/echo/pullrequest/7", url)
+		assert.Contains(t, string((*requests)[len(*requests)-1].body), `"sourceRefName":"refs/heads/draft"`)
+	})
+
+	t.Run("SourceURL", func(t *testing.T) {
+		assert.Equal(t, "https://dev.azure.com/org/My%20Project/_git/echo#main:.", NewAzureDevOpsService("", "").SourceURL("org/My Project", "echo", "main", "."))
+	})
+}
+
+// withRefs answers Azure DevOps refs requests by their filter
+func withRefs(next http.Handler, refs map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/refs") {
+			resp, ok := refs[r.URL.Query().Get("filter")]
+			if !ok {
+				resp = `{"value":[]}`
+			}
+			// the request is still recorded
+			next.ServeHTTP(httptest.NewRecorder(), r)
+			fmt.Fprint(w, resp)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestBitbucketService(t *testing.T) {
+	ctx := context.Background()
+	srv, requests := testServer(t, map[string]string{
+		"GET /repositories/team/echo":                `{"mainbranch":{"name":"main"}}`,
+		"GET /repositories/team/echo/commit/main":    `{"hash":"` + testCommit + `"}`,
+		"GET /team/echo/get/main.tar.gz":             "tarball",
+		"POST /repositories/team/echo/refs/branches": `{"name":"draft"}`,
+		"POST /repositories/team/echo/src":           "",
+		"POST /repositories/team/echo/pullrequests":  `{"links":{"html":{"href":"https://bitbucket.org/team/echo/pull-requests/3"}}}`,
+	})
+	b := NewBitbucketService("bbtoken")
+	b.apiURL, b.webURL = srv.URL, srv.URL
+
+	t.Run("ResolveRef", func(t *testing.T) {
+		sha, err := b.ResolveRef(ctx, "team", "echo", "main")
+		assert.Nil(t, err)
+		assert.Equal(t, testCommit, sha)
+		assert.Equal(t, "Bearer bbtoken", (*requests)[len(*requests)-1].auth)
+	})
+
+	t.Run("DownloadRepo", func(t *testing.T) {
+		tarball, err := b.DownloadRepo(ctx, "team", "echo", "main")
+		assert.Nil(t, err)
+		assert.Equal(t, "tarball", string(tarball))
+	})
+
+	t.Run("CreateBranch", func(t *testing.T) {
+		assert.Nil(t, b.CreateBranch(ctx, "team", "echo", "draft", ""))
+		assert.JSONEq(t, `{"name":"draft","target":{"hash":"`+testCommit+`"}}`, string((*requests)[len(*requests)-1].body))
+	})
+
+	t.Run("CreateFiles", func(t *testing.T) {
+		assert.Nil(t, b.CreateFiles(ctx, "team", "echo", "draft", "Dockerfile", []byte("FROM scratch")))
+		body := string((*requests)[len(*requests)-1].body)
+		assert.Contains(t, body, `name="/Dockerfile"`)
+		assert.Contains(t, body, "FROM scratch")
+	})
+
+	t.Run("CreatePullRequest", func(t *testing.T) {
+		url, err := b.CreatePullRequest(ctx, "team", "echo", "draft", "main", "Add a Dockerfile", "")
+		assert.Nil(t, err)
+		assert.Equal(t, "https://bitbucket.org/team/echo/pull-requests/3", url)
+	})
+
+	t.Run("SourceURL", func(t *testing.T) {
+		assert.Equal(t, "https://bitbucket.org/team/echo.git#main:.", NewBitbucketService("").SourceURL("team", "echo", "main", "."))
+	})
+}
+
+// advertisement returns the git-upload-pack advertisement of refs in pkt-line format
+func advertisement(refs ...string) string {
+	pkt := func(line string) string {
+		return fmt.Sprintf("%04x%s", len(line)+4, line)
+	}
+
+	var b strings.Builder
+	for i, ref := range refs {
+		if i == 0 {
+			ref += "\x00multi_ack side-band-64k"
+		}
+		b.WriteString(pkt(ref + "\n"))
+	}
+	b.WriteString(flushPkt)
+	return b.String()
+}
+
+var testRefs = []string{
+	baseCommit + " HEAD",
+	testCommit + " refs/heads/main",
+	baseCommit + " refs/tags/v1",
+	testCommit + " refs/tags/v1^{}",
+	testCommit + " refs/tags/v1.0.1",
+}
+
+func TestGitService(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("resolves refs over https", func(t *testing.T) {
+		var auth string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/echo.git/info/refs" || r.URL.Query().Get("service") != "git-upload-pack" {
+				http.NotFound(w, r)
+				return
+			}
+			auth = r.Header.Get("Authorization")
+			fmt.Fprint(w, "001e# service=git-upload-pack\n"+flushPkt+advertisement(testRefs...))
+		}))
+		defer srv.Close()
+
+		g := NewGitService(srv.URL+"/echo.git", Credentials{Token: "token"})
+		for ref, want := range map[string]string{"main": testCommit, "v1": testCommit, "HEAD": baseCommit, baseCommit: baseCommit} {
+			sha, err := g.ResolveRef(ctx, "", "", ref)
+			assert.Nil(t, err, ref)
+			assert.Equal(t, want, sha, ref)
+		}
+		assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("git:token")), auth)
+
+		_, err := g.ResolveRef(ctx, "", "", "missing")
+		assert.ErrorContains(t, err, "no branch or tag found")
+
+		tags, err := g.CommitTags(ctx, "", "", testCommit)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"v1", "v1.0.1"}, tags)
+		tags, err = g.CommitTags(ctx, "", "", baseCommit)
+		assert.Nil(t, err)
+		assert.Empty(t, tags)
+	})
+
+	t.Run("resolves refs over ssh", func(t *testing.T) {
+		addr, hostKey, clientKey := sshServer(t, "/srv/echo.git", advertisement(testRefs...))
+		host, port, _ := net.SplitHostPort(addr)
+		knownHosts := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey)
+
+		g := NewGitService(fmt.Sprintf("ssh://git@%s:%s/srv/echo.git", host, port), Credentials{SSHPrivateKey: clientKey, KnownHosts: []byte(knownHosts)})
+		sha, err := g.ResolveRef(ctx, "", "", "main")
+		assert.Nil(t, err)
+		assert.Equal(t, testCommit, sha)
+
+		// an unknown host key is rejected
+		other, _, _ := ed25519.GenerateKey(rand.Reader)
+		otherKey, _ := ssh.NewPublicKey(other)
+		g = NewGitService(fmt.Sprintf("ssh://git@%s:%s/srv/echo.git", host, port), Credentials{
+			SSHPrivateKey: clientKey,
+			KnownHosts:    []byte(knownhosts.Line([]string{knownhosts.Normalize(addr)}, otherKey)),
+		})
+		_, err = g.ResolveRef(ctx, "", "", "main")
+		assert.NotNil(t, err)
+
+		g = NewGitService(fmt.Sprintf("ssh://git@%s:%s/srv/echo.git", host, port), Credentials{SSHPrivateKey: clientKey})
+		_, err = g.ResolveRef(ctx, "", "", "main")
+		assert.ErrorContains(t, err, "knownHosts")
+	})
+
+	t.Run("doesn't support writes", func(t *testing.T) {
+		g := NewGitService("https://git.example.com/echo.git", Credentials{})
+		assert.True(t, errors.Is(g.CreateBranch(ctx, "", "", "draft", ""), errors.ErrUnsupported))
+		assert.True(t, errors.Is(g.CreateFiles(ctx, "", "", "draft", "Dockerfile", nil), errors.ErrUnsupported))
+		_, err := g.DownloadRepo(ctx, "", "", "main")
+		assert.True(t, errors.Is(err, errors.ErrUnsupported))
+		_, err = g.CreatePullRequest(ctx, "", "", "draft", "main", "", "")
+		assert.True(t, errors.Is(err, errors.ErrUnsupported))
+	})
+
+	t.Run("SourceURL", func(t *testing.T) {
+		g := NewGitService("git@git.example.com:team/echo.git", Credentials{})
+		assert.Equal(t, "git@git.example.com:team/echo.git#main:src", g.SourceURL("", "", "main", "src"))
+	})
+}
+
+func TestIsSSHURL(t *testing.T) {
+	for url, want := range map[string]bool{
+		"ssh://git@git.example.com/echo.git":   true,
+		"git@git.example.com:team/echo.git":    true,
+		"git.example.com:echo.git":             true,
+		"https://git.example.com/echo.git":     false,
+		"http://git.example.com:8080/echo.git": false,
+		"./relative/path:with/colon":           false,
+	} {
+		assert.Equal(t, want, IsSSHURL(url), url)
+	}
+}
+
+// sshServer serves advertisement to git-upload-pack commands for path and returns the address, host key and the
+// pem encoded private key clients authenticate with
+func sshServer(t *testing.T, path, advertisement string) (string, ssh.PublicKey, []byte) {
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	assert.Nil(t, err)
+
+	clientPub, clientPriv, _ := ed25519.GenerateKey(rand.Reader)
+	authorized, err := ssh.NewPublicKey(clientPub)
+	assert.Nil(t, err)
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	assert.Nil(t, err)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(authorized.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveUploadPack(conn, config, path, advertisement)
+		}
+	}()
+
+	return listener.Addr().String(), hostSigner.PublicKey(), pem.EncodeToMemory(block)
+}
+
+func serveUploadPack(conn net.Conn, config *ssh.ServerConfig, path, advertisement string) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		for req := range requests {
+			command := struct{ Command string }{}
+			if req.Type != "exec" || ssh.Unmarshal(req.Payload, &command) != nil || command.Command != "git-upload-pack '"+path+"'" {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+
+			_, _ = io.WriteString(channel, advertisement)
+			// the client hangs up with a flush
+			flush := make([]byte, len(flushPkt))
+			_, _ = io.ReadFull(channel, flush)
+			_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			channel.Close()
+		}
+	}
+}
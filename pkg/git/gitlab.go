@@ -0,0 +1,118 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultGitLabURL is the GitLab instance repositories are on unless they set a url
+const DefaultGitLabURL = "https://gitlab.com"
+
+// GitLabService reads and writes repositories with the GitLab REST API, owner is the group path of a project
+type GitLabService struct {
+	baseURL string
+	client  restClient
+}
+
+// NewGitLabService returns a GitLabService for the instance at baseURL, public projects can be read without a token
+func NewGitLabService(baseURL, token string) *GitLabService {
+	if baseURL == "" {
+		baseURL = DefaultGitLabURL
+	}
+
+	return &GitLabService{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client: newRESTClient(func(req *http.Request) {
+			if token != "" {
+				req.Header.Set("PRIVATE-TOKEN", token)
+			}
+		}),
+	}
+}
+
+// projectURL returns the api url of the project owner/repo, the project path is passed as one escaped segment
+func (g *GitLabService) projectURL(owner, repo string) string {
+	return fmt.Sprintf("%s/api/v4/projects/%s", g.baseURL, url.PathEscape(owner+"/"+repo))
+}
+
+// ResolveRef returns the SHA of the commit ref points to, ref can be a branch, tag or commit
+func (g *GitLabService) ResolveRef(ctx context.Context, owner, repo, ref string) (string, error) {
+	commit := struct {
+		ID string `json:"id"`
+	}{}
+	if err := g.client.doJSON(ctx, http.MethodGet, g.projectURL(owner, repo)+"/repository/commits/"+url.PathEscape(ref), nil, &commit); err != nil {
+		return "", fmt.Errorf("resolving ref %s: %w", ref, err)
+	}
+
+	return commit.ID, nil
+}
+
+// DownloadRepo returns a tarball of the repository at ref
+func (g *GitLabService) DownloadRepo(ctx context.Context, owner, repo, ref string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.projectURL(owner, repo)+"/repository/archive.tar.gz?sha="+url.QueryEscape(ref), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.client.send(req)
+}
+
+// CreateBranch creates branch from the head of base, the default branch of the project when base is empty
+func (g *GitLabService) CreateBranch(ctx context.Context, owner, repo, branch, base string) error {
+	if base == "" {
+		project := struct {
+			DefaultBranch string `json:"default_branch"`
+		}{}
+		if err := g.client.doJSON(ctx, http.MethodGet, g.projectURL(owner, repo), nil, &project); err != nil {
+			return fmt.Errorf("getting default branch: %w", err)
+		}
+		base = project.DefaultBranch
+	}
+
+	query := url.Values{"branch": {branch}, "ref": {base}}
+	if err := g.client.doJSON(ctx, http.MethodPost, g.projectURL(owner, repo)+"/repository/branches?"+query.Encode(), nil, nil); err != nil {
+		return fmt.Errorf("creating branch %s: %w", branch, err)
+	}
+
+	return nil
+}
+
+func (g *GitLabService) CreateFiles(ctx context.Context, owner, repo, branch, filePath string, content []byte) error {
+	body := map[string]string{
+		"branch":         branch,
+		"encoding":       "base64",
+		"content":        encodeBase64(content),
+		"commit_message": fmt.Sprintf("creating file: %s", filePath),
+	}
+	if err := g.client.doJSON(ctx, http.MethodPost, g.projectURL(owner, repo)+"/repository/files/"+url.PathEscape(filePath), body, nil); err != nil {
+		return fmt.Errorf("creating file %s: %w", filePath, err)
+	}
+
+	return nil
+}
+
+// CreatePullRequest opens a merge request of head into base and returns its url
+func (g *GitLabService) CreatePullRequest(ctx context.Context, owner, repo, head, base, title, body string) (string, error) {
+	request := map[string]string{
+		"source_branch": head,
+		"target_branch": base,
+		"title":         title,
+		"description":   body,
+	}
+	mr := struct {
+		WebURL string `json:"web_url"`
+	}{}
+	if err := g.client.doJSON(ctx, http.MethodPost, g.projectURL(owner, repo)+"/merge_requests", request, &mr); err != nil {
+		return "", fmt.Errorf("creating merge request from %s: %w", head, err)
+	}
+
+	return mr.WebURL, nil
+}
+
+// SourceURL returns the build context of contextPath in repo at ref in the form understood by ACR and BuildKit
+func (g *GitLabService) SourceURL(owner, repo, ref, contextPath string) string {
+	return fmt.Sprintf("%s/%s/%s.git#%s:%s", g.baseURL, owner, repo, ref, contextPath)
+}
@@ -0,0 +1,95 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// commitPattern matches full commit SHAs, they resolve to themselves
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// restClient sends requests to the REST API of a git provider
+type restClient struct {
+	http *http.Client
+	// authorize adds the credentials of the client to a request
+	authorize func(req *http.Request)
+}
+
+func newRESTClient(authorize func(req *http.Request)) restClient {
+	return restClient{
+		http:      &http.Client{Timeout: 30 * time.Second},
+		authorize: authorize,
+	}
+}
+
+// doJSON sends body as json to url and decodes the json response into out, either can be nil
+func (c *restClient) doJSON(ctx context.Context, method, url string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshalling request: %w", err)
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	respBody, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("decoding response of %s %s: %w", method, req.URL.Path, err)
+	}
+	return nil
+}
+
+// send sends req with the credentials of the client and returns the body of a successful response
+func (c *restClient) send(req *http.Request) ([]byte, error) {
+	if c.authorize != nil {
+		c.authorize(req)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response of %s %s: %w", req.Method, req.URL.Path, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// error bodies can be html pages, only the start is worth logging
+		if len(body) > 200 {
+			body = body[:200]
+		}
+		return nil, fmt.Errorf("%s %s: unexpected status code %d: %s", req.Method, req.URL.Path, resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	return body, nil
+}
+
+func encodeBase64(content []byte) string {
+	return base64.StdEncoding.EncodeToString(content)
+}
@@ -0,0 +1,179 @@
+package github
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-github/v42/github"
+	"golang.org/x/oauth2"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Keys of a repository credentials Secret, it holds either a token or the credentials of a GitHub App installation
+const (
+	TokenKey          = "token"
+	AppIDKey          = "appId"
+	InstallationIDKey = "installationId"
+	PrivateKeyKey     = "privateKey"
+)
+
+const (
+	// appJWTLifetime is how long the JWT an installation token is requested with is valid, GitHub allows 10 minutes
+	appJWTLifetime = 9 * time.Minute
+	// tokenRefreshWindow is how long before it expires an installation token is replaced
+	tokenRefreshWindow = 5 * time.Minute
+)
+
+// Credentials authenticate GitHub API calls and clones of private repositories
+type Credentials struct {
+	// Token is a personal access token, set instead of the GitHub App fields
+	Token          string
+	AppID          int64
+	InstallationID int64
+	PrivateKey     []byte
+}
+
+// CredentialsFromSecret reads the Credentials in secret
+func CredentialsFromSecret(secret *corev1.Secret) (*Credentials, error) {
+	if token := strings.TrimSpace(string(secret.Data[TokenKey])); token != "" {
+		return &Credentials{Token: token}, nil
+	}
+
+	for _, key := range []string{AppIDKey, InstallationIDKey, PrivateKeyKey} {
+		if len(secret.Data[key]) == 0 {
+			return nil, fmt.Errorf("secret %s has neither a %s nor a %s key", secret.Name, TokenKey, key)
+		}
+	}
+
+	appID, err := strconv.ParseInt(strings.TrimSpace(string(secret.Data[AppIDKey])), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s of secret %s: %w", AppIDKey, secret.Name, err)
+	}
+	installationID, err := strconv.ParseInt(strings.TrimSpace(string(secret.Data[InstallationIDKey])), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s of secret %s: %w", InstallationIDKey, secret.Name, err)
+	}
+
+	return &Credentials{AppID: appID, InstallationID: installationID, PrivateKey: secret.Data[PrivateKeyKey]}, nil
+}
+
+// key identifies the credentials in a TokenCache, a rotated private key gets new tokens
+func (c *Credentials) key() string {
+	h := sha256.Sum256(c.PrivateKey)
+	return fmt.Sprintf("%d/%d/%s", c.AppID, c.InstallationID, hex.EncodeToString(h[:]))
+}
+
+// TokenCache mints installation tokens for GitHub App credentials and reuses them until shortly before they expire
+type TokenCache struct {
+	baseURL *url.URL
+
+	mu      sync.Mutex
+	sources map[string]oauth2.TokenSource
+}
+
+// NewTokenCache returns a TokenCache requesting tokens from the GitHub API at baseURL, nil for api.github.com
+func NewTokenCache(baseURL *url.URL) *TokenCache {
+	return &TokenCache{
+		baseURL: baseURL,
+		sources: map[string]oauth2.TokenSource{},
+	}
+}
+
+// Token returns the token creds authenticate with, the personal access token or an installation token
+func (c *TokenCache) Token(creds *Credentials) (string, error) {
+	if creds.Token != "" {
+		return creds.Token, nil
+	}
+
+	key := creds.key()
+	c.mu.Lock()
+	src, ok := c.sources[key]
+	if !ok {
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(creds.PrivateKey)
+		if err != nil {
+			c.mu.Unlock()
+			return "", fmt.Errorf("parsing private key of app %d: %w", creds.AppID, err)
+		}
+
+		src = oauth2.ReuseTokenSourceWithExpiry(nil, &installationTokenSource{
+			baseURL:        c.baseURL,
+			appID:          creds.AppID,
+			installationID: creds.InstallationID,
+			privateKey:     privateKey,
+		}, tokenRefreshWindow)
+		c.sources[key] = src
+	}
+	c.mu.Unlock()
+
+	token, err := src.Token()
+	if err != nil {
+		return "", err
+	}
+
+	return token.AccessToken, nil
+}
+
+// TokenFromSecret returns the token of the credentials in the Secret key
+func (c *TokenCache) TokenFromSecret(ctx context.Context, reader client.Reader, key client.ObjectKey) (string, error) {
+	secret := &corev1.Secret{}
+	if err := reader.Get(ctx, key, secret); err != nil {
+		return "", fmt.Errorf("getting repository credentials secret %s: %w", key, err)
+	}
+
+	creds, err := CredentialsFromSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return c.Token(creds)
+}
+
+// installationTokenSource requests a new installation token of a GitHub App on every call
+type installationTokenSource struct {
+	baseURL        *url.URL
+	appID          int64
+	installationID int64
+	privateKey     *rsa.PrivateKey
+}
+
+func (s *installationTokenSource) Token() (*oauth2.Token, error) {
+	now := time.Now()
+	// the issue time is backdated to allow for clock drift between the cluster and GitHub
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		Issuer:    strconv.FormatInt(s.appID, 10),
+		IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(appJWTLifetime)),
+	}).SignedString(s.privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("signing jwt of app %d: %w", s.appID, err)
+	}
+
+	client := github.NewClient(oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: signed})))
+	if s.baseURL != nil {
+		client.BaseURL = s.baseURL
+	}
+
+	// oauth2 token sources don't take a context, the token is shared by all reconciles anyway
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	token, resp, err := client.Apps.CreateInstallationToken(ctx, s.installationID, nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("installation %d of app %d not found", s.installationID, s.appID)
+		}
+		return nil, fmt.Errorf("creating installation token of app %d: %w", s.appID, err)
+	}
+
+	return &oauth2.Token{AccessToken: token.GetToken(), Expiry: token.GetExpiresAt()}, nil
+}
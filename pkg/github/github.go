@@ -1,15 +1,19 @@
 package github
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/v42/github"
 	retry "github.com/sethvargo/go-retry"
 	"golang.org/x/oauth2"
-	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 type RepoTar struct {
@@ -30,6 +34,13 @@ type GitHubService struct {
 }
 
 func NewGitHubService(token string) *GitHubService {
+	// public repositories can be read without a token, sending an empty bearer token is rejected
+	if token == "" {
+		return &GitHubService{
+			client: github.NewClient(nil),
+		}
+	}
+
 	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
 	tc := oauth2.NewClient(context.Background(), ts)
 	return &GitHubService{
@@ -60,23 +71,86 @@ func (g *GitHubService) DownloadRepo(ctx context.Context, owner, repo, branch st
 	return repoTar.FileBytes, nil
 }
 
-func (g *GitHubService) CreateBranch(ctx context.Context, owner, repo, branch string) error {
-	baseRef := &github.Reference{}
-	err := retry.Do(ctx, retry.WithMaxRetries(3, retry.NewExponential(time.Millisecond*300)), func(ctx context.Context) error {
+// GetBranchHead returns the SHA of the commit at the head of branch
+func (g *GitHubService) GetBranchHead(ctx context.Context, owner, repo, branch string) (string, error) {
+	sha, _, err := g.client.Repositories.GetCommitSHA1(ctx, owner, repo, "refs/heads/"+branch, "")
+	if err != nil {
+		return "", fmt.Errorf("getting head of branch %s: %w", branch, err)
+	}
+
+	return sha, nil
+}
+
+// ResolveRef returns the SHA of the commit ref points to, ref can be a branch, tag or commit
+func (g *GitHubService) ResolveRef(ctx context.Context, owner, repo, ref string) (string, error) {
+	sha, _, err := g.client.Repositories.GetCommitSHA1(ctx, owner, repo, ref, "")
+	if err != nil {
+		return "", fmt.Errorf("resolving ref %s: %w", ref, err)
+	}
+
+	return sha, nil
+}
+
+// SourceURL returns the build context of contextPath in repo at ref in the form understood by ACR and BuildKit
+func (g *GitHubService) SourceURL(owner, repo, ref, contextPath string) string {
+	return fmt.Sprintf("https://github.com/%s/%s.git#%s:%s", owner, repo, ref, contextPath)
+}
+
+// GetBranchHeadIfChanged returns the SHA of the commit at the head of branch and the ETag of the response. The
+// request is conditional on etag, changed is false when the head didn't move since the response etag came from.
+// Unchanged responses don't count against the rate limit.
+func (g *GitHubService) GetBranchHeadIfChanged(ctx context.Context, owner, repo, branch, etag string) (sha, newEtag string, changed bool, err error) {
+	// branch names may contain slashes, only the segments between them are escaped
+	segments := strings.Split("refs/heads/"+branch, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	u := fmt.Sprintf("repos/%s/%s/commits/%s", owner, repo, strings.Join(segments, "/"))
+	req, err := g.client.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github.v3.sha")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	var buf bytes.Buffer
+	resp, err := g.client.Do(ctx, req, &buf)
+	// the github client returns an error for a 304 response
+	if resp != nil && resp.StatusCode == http.StatusNotModified {
+		return "", etag, false, nil
+	}
+	if err != nil {
+		return "", "", false, fmt.Errorf("getting head of branch %s: %w", branch, err)
+	}
+
+	return buf.String(), resp.Header.Get("ETag"), true, nil
+}
+
+// DefaultBranch returns the name of the default branch of the repository
+func (g *GitHubService) DefaultBranch(ctx context.Context, owner, repo string) (string, error) {
+	repository, _, err := g.client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return "", fmt.Errorf("getting repository %s/%s: %w", owner, repo, err)
+	}
+
+	return repository.GetDefaultBranch(), nil
+}
+
+// CreateBranch creates branch from the head of base, the default branch of the repository when base is empty
+func (g *GitHubService) CreateBranch(ctx context.Context, owner, repo, branch, base string) error {
+	if base == "" {
 		var err error
-		resp := &github.Response{}
-		baseRef, resp, err = g.client.Git.GetRef(ctx, owner, repo, "refs/heads/main")
-		if err != nil {
-			if resp != nil && resp.StatusCode == http.StatusNotFound {
-				return retry.RetryableError(fmt.Errorf("getting base branch ref: %w", err))
-			}
+		if base, err = g.DefaultBranch(ctx, owner, repo); err != nil {
 			return err
 		}
-		return nil
-	})
+	}
 
+	baseRef, err := g.branchRef(ctx, owner, repo, base)
 	if err != nil {
-		return err
+		return fmt.Errorf("getting base branch ref: %w", err)
 	}
 
 	newRef := &github.Reference{Ref: github.String("refs/heads/" + branch), Object: &github.GitObject{SHA: baseRef.Object.SHA}}
@@ -88,30 +162,270 @@ func (g *GitHubService) CreateBranch(ctx context.Context, owner, repo, branch st
 	return nil
 }
 
-func (g *GitHubService) CreateFiles(ctx context.Context, owner, repo, branch, filePath string, content []byte) error {
-	lgr := log.FromContext(ctx, "githubservice")
-	opt := &github.RepositoryContentFileOptions{
-		Branch:  github.String(branch),
-		Message: github.String(fmt.Sprintf("creating file: %s", filePath)),
-		Content: content,
-	}
-	err := retry.Do(ctx, retry.WithMaxRetries(3, retry.NewExponential(time.Millisecond*200)), func(ctx context.Context) error {
-		_, resp, err := g.client.Repositories.CreateFile(ctx, owner, repo, filePath, opt)
+// branchRef returns the ref of branch, a branch that was just created can take a moment to be found
+func (g *GitHubService) branchRef(ctx context.Context, owner, repo, branch string) (*github.Reference, error) {
+	var ref *github.Reference
+	err := retry.Do(ctx, retry.WithMaxRetries(3, retry.NewExponential(time.Millisecond*300)), func(ctx context.Context) error {
+		var err error
+		var resp *github.Response
+		ref, resp, err = g.client.Git.GetRef(ctx, owner, repo, "refs/heads/"+branch)
 		if err != nil {
-			// the github client return an error object on 404
 			if resp != nil && resp.StatusCode == http.StatusNotFound {
 				return retry.RetryableError(err)
 			}
-			lgr.Info(fmt.Sprintf("unrecoverable github error: %s", err.Error()))
 			return err
 		}
-
 		return nil
 	})
-	if err != nil {
-		lgr.Info(fmt.Sprintf("failed to create new file: %s", err.Error()))
+
+	return ref, err
+}
+
+// CreateFiles commits filePath to branch, replacing the file if it exists
+func (g *GitHubService) CreateFiles(ctx context.Context, owner, repo, branch, filePath string, content []byte) error {
+	message := fmt.Sprintf("creating file: %s", filePath)
+	if _, err := g.CommitFiles(ctx, owner, repo, branch, message, map[string][]byte{filePath: content}); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// CommitFiles commits files by their path to branch in a single commit and returns the SHA of the commit. Existing
+// files are replaced, the other files of the branch are kept. The commit fails if branch moves while it is created.
+func (g *GitHubService) CommitFiles(ctx context.Context, owner, repo, branch, message string, files map[string][]byte) (string, error) {
+	ref, err := g.branchRef(ctx, owner, repo, branch)
+	if err != nil {
+		return "", fmt.Errorf("getting branch %s: %w", branch, err)
+	}
+	parent, _, err := g.client.Git.GetCommit(ctx, owner, repo, ref.Object.GetSHA())
+	if err != nil {
+		return "", fmt.Errorf("getting head of branch %s: %w", branch, err)
+	}
+
+	paths := make([]string, 0, len(files))
+	for filePath := range files {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	// blobs are uploaded base64 encoded so files don't need to be valid utf-8
+	entries := make([]*github.TreeEntry, 0, len(paths))
+	for _, filePath := range paths {
+		blob, _, err := g.client.Git.CreateBlob(ctx, owner, repo, &github.Blob{
+			Content:  github.String(base64.StdEncoding.EncodeToString(files[filePath])),
+			Encoding: github.String("base64"),
+		})
+		if err != nil {
+			return "", fmt.Errorf("creating blob for %s: %w", filePath, err)
+		}
+		entries = append(entries, &github.TreeEntry{
+			Path: github.String(filePath),
+			Mode: github.String("100644"),
+			Type: github.String("blob"),
+			SHA:  blob.SHA,
+		})
+	}
+
+	tree, _, err := g.client.Git.CreateTree(ctx, owner, repo, parent.GetTree().GetSHA(), entries)
+	if err != nil {
+		return "", fmt.Errorf("creating tree: %w", err)
+	}
+	commit, _, err := g.client.Git.CreateCommit(ctx, owner, repo, &github.Commit{
+		Message: github.String(message),
+		Tree:    tree,
+		Parents: []*github.Commit{{SHA: parent.SHA}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating commit: %w", err)
+	}
+
+	ref.Object.SHA = commit.SHA
+	if _, _, err := g.client.Git.UpdateRef(ctx, owner, repo, ref, false); err != nil {
+		return "", fmt.Errorf("updating branch %s: %w", branch, err)
+	}
+
+	return commit.GetSHA(), nil
+}
+
+// PullRequest is a pull request of a GitHub repository
+type PullRequest struct {
+	Number int
+	URL    string
+	// Head is the SHA of the last commit of the pull request
+	Head   string
+	Merged bool
+}
+
+func toPullRequest(pr *github.PullRequest) *PullRequest {
+	return &PullRequest{
+		Number: pr.GetNumber(),
+		URL:    pr.GetHTMLURL(),
+		Head:   pr.GetHead().GetSHA(),
+		Merged: pr.GetMerged(),
+	}
+}
+
+// CreatePullRequest opens a pull request merging head into base and returns its url
+func (g *GitHubService) CreatePullRequest(ctx context.Context, owner, repo, head, base, title, body string) (string, error) {
+	pr, err := g.OpenPullRequest(ctx, owner, repo, head, base, title, body)
+	if err != nil {
+		return "", err
+	}
+
+	return pr.URL, nil
+}
+
+// OpenPullRequest opens a pull request merging head into base. A pull request of head into base that is already
+// open is updated with title and body instead.
+func (g *GitHubService) OpenPullRequest(ctx context.Context, owner, repo, head, base, title, body string) (*PullRequest, error) {
+	open, _, err := g.client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+		State: "open",
+		Head:  owner + ":" + head,
+		Base:  base,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing pull requests from %s: %w", head, err)
+	}
+	if len(open) > 0 {
+		return g.UpdatePullRequest(ctx, owner, repo, open[0].GetNumber(), title, body)
+	}
+
+	pr, _, err := g.client.PullRequests.Create(ctx, owner, repo, &github.NewPullRequest{
+		Title: github.String(title),
+		Head:  github.String(head),
+		Base:  github.String(base),
+		Body:  github.String(body),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating pull request from %s: %w", head, err)
+	}
+
+	return toPullRequest(pr), nil
+}
+
+// PullRequestURL returns the url of the latest pull request merging head into base, open or closed, and an empty
+// url if there is none
+func (g *GitHubService) PullRequestURL(ctx context.Context, owner, repo, head, base string) (string, error) {
+	prs, _, err := g.client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+		State: "all",
+		Head:  owner + ":" + head,
+		Base:  base,
+	})
+	if err != nil {
+		return "", fmt.Errorf("listing pull requests from %s: %w", head, err)
+	}
+	if len(prs) == 0 {
+		return "", nil
+	}
+
+	return prs[0].GetHTMLURL(), nil
+}
+
+// UpdatePullRequest replaces the title and body of pull request number
+func (g *GitHubService) UpdatePullRequest(ctx context.Context, owner, repo string, number int, title, body string) (*PullRequest, error) {
+	pr, _, err := g.client.PullRequests.Edit(ctx, owner, repo, number, &github.PullRequest{
+		Title: github.String(title),
+		Body:  github.String(body),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("updating pull request %d: %w", number, err)
+	}
+
+	return toPullRequest(pr), nil
+}
+
+// MergePullRequest merges pull request number with method, merge, squash or rebase, and returns the SHA of the
+// merge commit. The merge fails if the head of the pull request isn't sha anymore, sha is only checked when set.
+func (g *GitHubService) MergePullRequest(ctx context.Context, owner, repo string, number int, sha, method string) (string, error) {
+	result, _, err := g.client.PullRequests.Merge(ctx, owner, repo, number, "", &github.PullRequestOptions{
+		SHA:         sha,
+		MergeMethod: method,
+	})
+	if err != nil {
+		return "", fmt.Errorf("merging pull request %d: %w", number, err)
+	}
+	if !result.GetMerged() {
+		return "", fmt.Errorf("merging pull request %d: %s", number, result.GetMessage())
+	}
+
+	return result.GetSHA(), nil
+}
+
+// maxStatusDescription is the longest description in characters GitHub accepts for a commit status
+const maxStatusDescription = 140
+
+// SetCommitStatus posts the state of statusContext on commit sha, the description is truncated to the length GitHub
+// accepts
+func (g *GitHubService) SetCommitStatus(ctx context.Context, owner, repo, sha, statusContext, state, description, targetURL string) error {
+	if r := []rune(description); len(r) > maxStatusDescription {
+		description = string(r[:maxStatusDescription-3]) + "..."
+	}
+	status := &github.RepoStatus{
+		State:       github.String(state),
+		Context:     github.String(statusContext),
+		Description: github.String(description),
+	}
+	if targetURL != "" {
+		status.TargetURL = github.String(targetURL)
+	}
+	if _, _, err := g.client.Repositories.CreateStatus(ctx, owner, repo, sha, status); err != nil {
+		return fmt.Errorf("setting status %s of commit %s: %w", statusContext, sha, err)
+	}
+
+	return nil
+}
+
+// CreateDeployment records a deploy of ref to environment and returns its id. The commit statuses of ref aren't
+// required to pass, the controller only deploys built commits.
+func (g *GitHubService) CreateDeployment(ctx context.Context, owner, repo, ref, environment, description string) (int64, error) {
+	deployment, _, err := g.client.Repositories.CreateDeployment(ctx, owner, repo, &github.DeploymentRequest{
+		Ref:              github.String(ref),
+		Environment:      github.String(environment),
+		Description:      github.String(description),
+		AutoMerge:        github.Bool(false),
+		RequiredContexts: &[]string{},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("creating deployment of %s: %w", ref, err)
+	}
+
+	return deployment.GetID(), nil
+}
+
+// CreateDeploymentStatus posts the state of deployment id, environmentURL is where the deployed app is reachable
+func (g *GitHubService) CreateDeploymentStatus(ctx context.Context, owner, repo string, id int64, state, environmentURL, description string) error {
+	status := &github.DeploymentStatusRequest{
+		State:       github.String(state),
+		Description: github.String(description),
+	}
+	if environmentURL != "" {
+		status.EnvironmentURL = github.String(environmentURL)
+	}
+	if _, _, err := g.client.Repositories.CreateDeploymentStatus(ctx, owner, repo, id, status); err != nil {
+		return fmt.Errorf("setting status of deployment %d: %w", id, err)
+	}
+
+	return nil
+}
+
+// CommitTags returns the names of the tags pointing at commit sha
+func (g *GitHubService) CommitTags(ctx context.Context, owner, repo, sha string) ([]string, error) {
+	var tags []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := g.client.Repositories.ListTags(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing tags: %w", err)
+		}
+		for _, tag := range page {
+			if tag.GetCommit().GetSHA() == sha {
+				tags = append(tags, tag.GetName())
+			}
+		}
+		if resp.NextPage == 0 {
+			return tags, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
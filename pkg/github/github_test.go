@@ -2,12 +2,41 @@ package github
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-github/v42/github"
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
 )
 
+// newTestService returns a GitHubService that sends its requests to a local server using handler
+func newTestService(t *testing.T, handler http.Handler) *GitHubService {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	assert.Nil(t, err)
+	client.BaseURL = baseURL
+
+	return &GitHubService{client: client}
+}
+
 func TestGithubService(t *testing.T) {
 	t.Run("DownloadRepo", func(t *testing.T) {
 		s := NewGitHubService("")
@@ -15,4 +44,350 @@ func TestGithubService(t *testing.T) {
 		assert.Nil(t, err)
 		log.Println(fileBytes)
 	})
+
+	t.Run("GetBranchHead", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/bfoley13/go_echo/commits/refs/heads/main", func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "application/vnd.github.v3.sha", r.Header.Get("Accept"))
+			w.Write([]byte("3a0f86fb8db8eea7ccbb9a95f325ddbedfb25e15"))
+		})
+
+		s := newTestService(t, mux)
+		sha, err := s.GetBranchHead(context.Background(), "bfoley13", "go_echo", "main")
+		assert.Nil(t, err)
+		assert.Equal(t, "3a0f86fb8db8eea7ccbb9a95f325ddbedfb25e15", sha)
+
+		_, err = s.GetBranchHead(context.Background(), "bfoley13", "go_echo", "missing")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("GetBranchHeadIfChanged", func(t *testing.T) {
+		head := "3a0f86fb8db8eea7ccbb9a95f325ddbedfb25e15"
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/bfoley13/go_echo/commits/refs/heads/feature/poll", func(w http.ResponseWriter, r *http.Request) {
+			etag := `"` + head + `"`
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Write([]byte(head))
+		})
+
+		s := newTestService(t, mux)
+		sha, etag, changed, err := s.GetBranchHeadIfChanged(context.Background(), "bfoley13", "go_echo", "feature/poll", "")
+		assert.Nil(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, head, sha)
+
+		_, notModified, changed, err := s.GetBranchHeadIfChanged(context.Background(), "bfoley13", "go_echo", "feature/poll", etag)
+		assert.Nil(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, etag, notModified)
+
+		head = "9c6b8f1e2d4a7b3c5e8f0a1b2c3d4e5f6a7b8c9d"
+		sha, _, changed, err = s.GetBranchHeadIfChanged(context.Background(), "bfoley13", "go_echo", "feature/poll", etag)
+		assert.Nil(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, head, sha)
+
+		_, _, _, err = s.GetBranchHeadIfChanged(context.Background(), "bfoley13", "go_echo", "missing", "")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("CreateBranch", func(t *testing.T) {
+		var created []string
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/bfoley13/config", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"default_branch":"trunk"}`)
+		})
+		mux.HandleFunc("/repos/bfoley13/config/git/ref/heads/", func(w http.ResponseWriter, r *http.Request) {
+			branch := strings.TrimPrefix(r.URL.Path, "/repos/bfoley13/config/git/ref/heads/")
+			fmt.Fprintf(w, `{"ref":"refs/heads/%s","object":{"sha":"%s-head"}}`, branch, branch)
+		})
+		mux.HandleFunc("/repos/bfoley13/config/git/refs", func(w http.ResponseWriter, r *http.Request) {
+			ref := struct {
+				Ref string `json:"ref"`
+				SHA string `json:"sha"`
+			}{}
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&ref))
+			created = append(created, ref.Ref+"@"+ref.SHA)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{}`)
+		})
+
+		s := newTestService(t, mux)
+		assert.Nil(t, s.CreateBranch(context.Background(), "bfoley13", "config", "draft", "release"))
+		assert.Nil(t, s.CreateBranch(context.Background(), "bfoley13", "config", "draft", ""))
+		assert.Equal(t, []string{"refs/heads/draft@release-head", "refs/heads/draft@trunk-head"}, created)
+	})
+
+	t.Run("CommitFiles", func(t *testing.T) {
+		var blobs []string
+		var tree map[string]any
+		var commit map[string]any
+		var update map[string]any
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/bfoley13/config/git/ref/heads/main", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"ref":"refs/heads/main","object":{"sha":"parent"}}`)
+		})
+		mux.HandleFunc("/repos/bfoley13/config/git/commits/parent", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"sha":"parent","tree":{"sha":"base-tree"}}`)
+		})
+		mux.HandleFunc("/repos/bfoley13/config/git/blobs", func(w http.ResponseWriter, r *http.Request) {
+			blob := map[string]string{}
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&blob))
+			assert.Equal(t, "base64", blob["encoding"])
+			blobs = append(blobs, blob["content"])
+			fmt.Fprintf(w, `{"sha":"blob-%d"}`, len(blobs))
+		})
+		mux.HandleFunc("/repos/bfoley13/config/git/trees", func(w http.ResponseWriter, r *http.Request) {
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&tree))
+			fmt.Fprint(w, `{"sha":"new-tree"}`)
+		})
+		mux.HandleFunc("/repos/bfoley13/config/git/commits", func(w http.ResponseWriter, r *http.Request) {
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&commit))
+			fmt.Fprint(w, `{"sha":"new-commit"}`)
+		})
+		mux.HandleFunc("/repos/bfoley13/config/git/refs/heads/main", func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPatch, r.Method)
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&update))
+			fmt.Fprint(w, `{"ref":"refs/heads/main","object":{"sha":"new-commit"}}`)
+		})
+
+		s := newTestService(t, mux)
+		sha, err := s.CommitFiles(context.Background(), "bfoley13", "config", "main", "deploy echo", map[string][]byte{
+			"apps/echo/service.yaml":    []byte("kind: Service\n"),
+			"apps/echo/deployment.yaml": []byte("kind: Deployment\n"),
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "new-commit", sha)
+
+		assert.Equal(t, []string{
+			base64.StdEncoding.EncodeToString([]byte("kind: Deployment\n")),
+			base64.StdEncoding.EncodeToString([]byte("kind: Service\n")),
+		}, blobs)
+		assert.Equal(t, "base-tree", tree["base_tree"])
+		assert.Equal(t, []any{
+			map[string]any{"path": "apps/echo/deployment.yaml", "mode": "100644", "type": "blob", "sha": "blob-1"},
+			map[string]any{"path": "apps/echo/service.yaml", "mode": "100644", "type": "blob", "sha": "blob-2"},
+		}, tree["tree"])
+		assert.Equal(t, "new-tree", commit["tree"])
+		assert.Equal(t, []any{"parent"}, commit["parents"])
+		assert.Equal(t, map[string]any{"sha": "new-commit", "force": false}, update)
+	})
+
+	t.Run("PullRequests", func(t *testing.T) {
+		var open []string
+		var edited []string
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/bfoley13/config/pulls", func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				assert.Equal(t, "bfoley13:draft", r.URL.Query().Get("head"))
+				assert.Equal(t, "main", r.URL.Query().Get("base"))
+				if len(open) == 0 {
+					fmt.Fprint(w, `[]`)
+					return
+				}
+				fmt.Fprint(w, `[{"number":1,"html_url":"https://github.com/bfoley13/config/pull/1"}]`)
+			case http.MethodPost:
+				open = append(open, "draft")
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{"number":1,"html_url":"https://github.com/bfoley13/config/pull/1","head":{"sha":"head-1"}}`)
+			}
+		})
+		mux.HandleFunc("/repos/bfoley13/config/pulls/1", func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPatch, r.Method)
+			pr := map[string]string{}
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&pr))
+			edited = append(edited, pr["title"])
+			fmt.Fprint(w, `{"number":1,"html_url":"https://github.com/bfoley13/config/pull/1","head":{"sha":"head-2"}}`)
+		})
+		mux.HandleFunc("/repos/bfoley13/config/pulls/1/merge", func(w http.ResponseWriter, r *http.Request) {
+			merge := map[string]string{}
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&merge))
+			if merge["sha"] != "head-2" {
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprint(w, `{"message":"Head branch was modified"}`)
+				return
+			}
+			assert.Equal(t, "squash", merge["merge_method"])
+			fmt.Fprint(w, `{"sha":"merge-commit","merged":true}`)
+		})
+
+		s := newTestService(t, mux)
+		url, err := s.PullRequestURL(context.Background(), "bfoley13", "config", "draft", "main")
+		assert.Nil(t, err)
+		assert.Empty(t, url)
+
+		url, err = s.CreatePullRequest(context.Background(), "bfoley13", "config", "draft", "main", "Deploy v1", "")
+		assert.Nil(t, err)
+		assert.Equal(t, "https://github.com/bfoley13/config/pull/1", url)
+
+		url, err = s.PullRequestURL(context.Background(), "bfoley13", "config", "draft", "main")
+		assert.Nil(t, err)
+		assert.Equal(t, "https://github.com/bfoley13/config/pull/1", url)
+
+		// the open pull request of the branch is updated instead of opening another one
+		pr, err := s.OpenPullRequest(context.Background(), "bfoley13", "config", "draft", "main", "Deploy v2", "")
+		assert.Nil(t, err)
+		assert.Equal(t, &PullRequest{Number: 1, URL: "https://github.com/bfoley13/config/pull/1", Head: "head-2"}, pr)
+		assert.Len(t, open, 1)
+		assert.Equal(t, []string{"Deploy v2"}, edited)
+
+		_, err = s.MergePullRequest(context.Background(), "bfoley13", "config", pr.Number, "head-1", "squash")
+		assert.ErrorContains(t, err, "Head branch was modified")
+		sha, err := s.MergePullRequest(context.Background(), "bfoley13", "config", pr.Number, pr.Head, "squash")
+		assert.Nil(t, err)
+		assert.Equal(t, "merge-commit", sha)
+	})
+
+	t.Run("CommitTags", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/bfoley13/go_echo/tags", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("page") == "2" {
+				fmt.Fprint(w, `[{"name":"v1.1.0","commit":{"sha":"abc123"}}]`)
+				return
+			}
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/repos/bfoley13/go_echo/tags?page=2>; rel="next"`, r.Host))
+			fmt.Fprint(w, `[{"name":"v1.2.0","commit":{"sha":"def456"}},{"name":"v1.0.0","commit":{"sha":"abc123"}}]`)
+		})
+
+		s := newTestService(t, mux)
+		tags, err := s.CommitTags(context.Background(), "bfoley13", "go_echo", "abc123")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"v1.0.0", "v1.1.0"}, tags)
+	})
+
+	t.Run("Statuses", func(t *testing.T) {
+		var statuses []map[string]string
+		var deployments []map[string]interface{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/repos/bfoley13/go_echo/statuses/abc123", func(w http.ResponseWriter, r *http.Request) {
+			status := map[string]string{}
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&status))
+			statuses = append(statuses, status)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{}`)
+		})
+		mux.HandleFunc("/repos/bfoley13/go_echo/deployments", func(w http.ResponseWriter, r *http.Request) {
+			deployment := map[string]interface{}{}
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&deployment))
+			deployments = append(deployments, deployment)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"id":42}`)
+		})
+		mux.HandleFunc("/repos/bfoley13/go_echo/deployments/42/statuses", func(w http.ResponseWriter, r *http.Request) {
+			status := map[string]string{}
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&status))
+			statuses = append(statuses, status)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{}`)
+		})
+
+		s := newTestService(t, mux)
+		assert.Nil(t, s.SetCommitStatus(context.Background(), "bfoley13", "go_echo", "abc123", "appcontroller/build", "failure", strings.Repeat("x", 200), ""))
+		assert.Equal(t, "failure", statuses[0]["state"])
+		assert.Equal(t, "appcontroller/build", statuses[0]["context"])
+		assert.Len(t, statuses[0]["description"], maxStatusDescription)
+
+		assert.Nil(t, s.SetCommitStatus(context.Background(), "bfoley13", "go_echo", "abc123", "appcontroller/build", "failure", strings.Repeat("é", 200), ""))
+		assert.True(t, utf8.ValidString(statuses[1]["description"]), "truncated on a character boundary")
+		assert.Equal(t, maxStatusDescription, utf8.RuneCountInString(statuses[1]["description"]))
+		assert.NotContains(t, statuses[0], "target_url")
+		statuses = nil
+
+		id, err := s.CreateDeployment(context.Background(), "bfoley13", "go_echo", "abc123", "target/echo", "deploying")
+		assert.Nil(t, err)
+		assert.Equal(t, int64(42), id)
+		assert.Equal(t, "abc123", deployments[0]["ref"])
+		assert.Equal(t, "target/echo", deployments[0]["environment"])
+		assert.Equal(t, false, deployments[0]["auto_merge"])
+		assert.Equal(t, []interface{}{}, deployments[0]["required_contexts"])
+
+		assert.Nil(t, s.CreateDeploymentStatus(context.Background(), "bfoley13", "go_echo", id, "success", "http://echo.example.com", "deployed"))
+		assert.Equal(t, map[string]string{"state": "success", "environment_url": "http://echo.example.com", "description": "deployed"}, statuses[0])
+	})
+}
+
+func TestCredentialsFromSecret(t *testing.T) {
+	creds, err := CredentialsFromSecret(&corev1.Secret{Data: map[string][]byte{TokenKey: []byte("ghp_token\n")}})
+	assert.Nil(t, err)
+	assert.Equal(t, "ghp_token", creds.Token)
+
+	creds, err = CredentialsFromSecret(&corev1.Secret{Data: map[string][]byte{
+		AppIDKey:          []byte("12345"),
+		InstallationIDKey: []byte("67890"),
+		PrivateKeyKey:     []byte("key"),
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12345), creds.AppID)
+	assert.Equal(t, int64(67890), creds.InstallationID)
+
+	_, err = CredentialsFromSecret(&corev1.Secret{Data: map[string][]byte{AppIDKey: []byte("12345")}})
+	assert.NotNil(t, err)
+	_, err = CredentialsFromSecret(&corev1.Secret{Data: map[string][]byte{
+		AppIDKey:          []byte("app"),
+		InstallationIDKey: []byte("67890"),
+		PrivateKeyKey:     []byte("key"),
+	}})
+	assert.NotNil(t, err)
+}
+
+func TestTokenCache(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	requests := 0
+	expiresAt := time.Now().Add(time.Hour)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app/installations/67890/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		claims := &jwt.RegisteredClaims{}
+		_, err := jwt.ParseWithClaims(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), claims, func(*jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "12345", claims.Issuer)
+
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token":"ghs_%d","expires_at":%q}`, requests, expiresAt.Format(time.RFC3339))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	baseURL, err := url.Parse(server.URL + "/")
+	assert.Nil(t, err)
+
+	tokens := NewTokenCache(baseURL)
+	token, err := tokens.Token(&Credentials{Token: "ghp_token"})
+	assert.Nil(t, err)
+	assert.Equal(t, "ghp_token", token)
+	assert.Zero(t, requests)
+
+	creds := &Credentials{AppID: 12345, InstallationID: 67890, PrivateKey: privateKey}
+	token, err = tokens.Token(creds)
+	assert.Nil(t, err)
+	assert.Equal(t, "ghs_1", token)
+
+	// the token is reused until it is about to expire
+	token, err = tokens.Token(creds)
+	assert.Nil(t, err)
+	assert.Equal(t, "ghs_1", token)
+	assert.Equal(t, 1, requests)
+
+	expiresAt = time.Now().Add(tokenRefreshWindow / 2)
+	delete(tokens.sources, creds.key())
+	token, err = tokens.Token(creds)
+	assert.Nil(t, err)
+	assert.Equal(t, "ghs_2", token)
+	token, err = tokens.Token(creds)
+	assert.Nil(t, err)
+	assert.Equal(t, "ghs_3", token)
+
+	_, err = tokens.Token(&Credentials{AppID: 12345, InstallationID: 67890, PrivateKey: []byte("not a key")})
+	assert.NotNil(t, err)
+	_, err = tokens.Token(&Credentials{AppID: 12345, InstallationID: 1, PrivateKey: privateKey})
+	assert.NotNil(t, err)
 }
@@ -0,0 +1,172 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+type headPoller interface {
+	GetBranchHeadIfChanged(ctx context.Context, owner, repo, branch, etag string) (sha, newEtag string, changed bool, err error)
+}
+
+// branch identifies a branch of a repository, owner and repository names are case insensitive
+type branch struct {
+	provider          appv1alpha1.GitProvider
+	url               string
+	owner, repo, name string
+}
+
+func branchOf(repo *appv1alpha1.Repository) branch {
+	return branch{
+		provider: repo.GetProvider(),
+		url:      repo.URL,
+		owner:    strings.ToLower(repo.Owner),
+		repo:     strings.ToLower(repo.Name),
+		name:     repo.BranchName,
+	}
+}
+
+// polledBranch is a branch read with the credentials Secret of an Application, Applications with different
+// Secrets poll a branch separately so none is read with the credentials of another
+type polledBranch struct {
+	branch
+	credentials types.NamespacedName
+}
+
+func polledBranchOf(app *appv1alpha1.Application) polledBranch {
+	b := polledBranch{branch: branchOf(app.Spec.Repository)}
+	if app.Spec.Repository.CredentialsSecretName != "" {
+		b.credentials = types.NamespacedName{Namespace: app.Namespace, Name: app.Spec.Repository.CredentialsSecretName}
+	}
+
+	return b
+}
+
+type head struct {
+	sha, etag string
+}
+
+// Poller checks the heads of the branches Applications build on an interval and queues the Applications of a
+// branch whose head moved. It is an alternative to the push webhook for clusters that can't receive one.
+type Poller struct {
+	client    client.Reader
+	providers *Providers
+	interval  time.Duration
+	events    *Events
+
+	heads map[polledBranch]head
+}
+
+func NewPoller(cl client.Reader, providers *Providers, interval time.Duration, events *Events) *Poller {
+	return &Poller{
+		client:    cl,
+		providers: providers,
+		interval:  interval,
+		events:    events,
+		heads:     map[polledBranch]head{},
+	}
+}
+
+func (p *Poller) Start(ctx context.Context) error {
+	lgr := log.FromContext(ctx).WithName("source-poller")
+	lgr.Info("polling branches", "interval", p.interval)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := p.poll(ctx); err != nil {
+			lgr.Error(err, "unable to poll branches")
+		}
+	}, p.interval)
+
+	return nil
+}
+
+// NeedLeaderElection is true because the queued Applications are only consumed by the leader's controller
+func (p *Poller) NeedLeaderElection() bool {
+	return true
+}
+
+// poll requests the head of every branch referenced by an Application once. The first head seen of a branch
+// isn't queued, Applications resolve the head themselves when they are first reconciled.
+func (p *Poller) poll(ctx context.Context) error {
+	lgr := log.FromContext(ctx).WithName("source-poller")
+
+	var apps appv1alpha1.ApplicationList
+	if err := p.client.List(ctx, &apps); err != nil {
+		return fmt.Errorf("listing applications: %w", err)
+	}
+
+	// several Applications building the same branch with the same credentials share a request
+	referencing := map[polledBranch][]types.NamespacedName{}
+	repositories := map[polledBranch]*appv1alpha1.Application{}
+	for i, app := range apps.Items {
+		if app.Spec.Repository == nil {
+			continue
+		}
+
+		b := polledBranchOf(&apps.Items[i])
+		referencing[b] = append(referencing[b], types.NamespacedName{Namespace: app.Namespace, Name: app.Name})
+		if repositories[b] == nil {
+			repositories[b] = &apps.Items[i]
+		}
+	}
+
+	for b := range p.heads {
+		if _, ok := referencing[b]; !ok {
+			delete(p.heads, b)
+		}
+	}
+
+	for b, keys := range referencing {
+		app := repositories[b]
+		provider, _, err := p.providers.For(ctx, app.Namespace, app.Spec.Repository)
+		if err != nil {
+			lgr.Error(err, "unable to get source provider", "owner", b.owner, "repository", b.repo)
+			continue
+		}
+
+		last, seen := p.heads[b]
+		current, changed, err := pollHead(ctx, provider, app.Spec.Repository, last)
+		if err != nil {
+			// one missing branch shouldn't stop the others from being polled
+			lgr.Error(err, "unable to get branch head", "owner", b.owner, "repository", b.repo, "branch", b.name)
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		p.heads[b] = current
+		if !seen || current.sha == last.sha {
+			continue
+		}
+
+		lgr.Info("branch head moved", "owner", b.owner, "repository", b.repo, "branch", b.name, "commit", current.sha, "applications", len(keys))
+		for _, key := range keys {
+			p.events.Push(key, current.sha)
+		}
+	}
+
+	return nil
+}
+
+// pollHead returns the head of the branch of repo and whether it may have changed since last. Providers without
+// conditional requests resolve the branch on every poll.
+func pollHead(ctx context.Context, provider SourceProvider, repo *appv1alpha1.Repository, last head) (head, bool, error) {
+	if hp, ok := provider.(headPoller); ok {
+		sha, etag, changed, err := hp.GetBranchHeadIfChanged(ctx, repo.Owner, repo.Name, repo.BranchName, last.etag)
+		return head{sha: sha, etag: etag}, changed, err
+	}
+
+	sha, err := provider.ResolveRef(ctx, repo.Owner, repo.Name, repo.BranchName)
+	if err != nil {
+		return head{}, false, err
+	}
+	return head{sha: sha}, sha != last.sha, nil
+}
@@ -0,0 +1,145 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"github.com/bfoley13/appcontroller/pkg/build"
+	"github.com/bfoley13/appcontroller/pkg/git"
+	"github.com/bfoley13/appcontroller/pkg/github"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SourceProvider reads and writes the repositories of a git hosting provider. Owner and repo are the
+// spec.repository fields, providers that address repositories by url ignore them.
+type SourceProvider interface {
+	// ResolveRef returns the SHA of the commit a branch, tag or commit ref points to
+	ResolveRef(ctx context.Context, owner, repo, ref string) (string, error)
+	DownloadRepo(ctx context.Context, owner, repo, ref string) ([]byte, error)
+	// CreateBranch creates branch from the head of base, the default branch of the repository when base is empty
+	CreateBranch(ctx context.Context, owner, repo, branch, base string) error
+	CreateFiles(ctx context.Context, owner, repo, branch, filePath string, content []byte) error
+	// CreatePullRequest opens a pull request merging head into base and returns its web url
+	CreatePullRequest(ctx context.Context, owner, repo, head, base, title, body string) (string, error)
+	// SourceURL returns the build context of contextPath in the repository at ref in the form understood by ACR
+	// and BuildKit, without credentials
+	SourceURL(owner, repo, ref, contextPath string) string
+}
+
+var (
+	_ SourceProvider = &github.GitHubService{}
+	_ SourceProvider = &git.GitLabService{}
+	_ SourceProvider = &git.AzureDevOpsService{}
+	_ SourceProvider = &git.BitbucketService{}
+	_ SourceProvider = &git.GitService{}
+)
+
+// tokenUsernames are the users clones authenticate as with a token, most providers only check the token
+var tokenUsernames = map[appv1alpha1.GitProvider]string{
+	appv1alpha1.GitProviderGitHub:      "x-access-token",
+	appv1alpha1.GitProviderGitLab:      "oauth2",
+	appv1alpha1.GitProviderAzureDevOps: "pat",
+	appv1alpha1.GitProviderBitbucket:   "x-token-auth",
+	appv1alpha1.GitProviderGit:         "git",
+}
+
+// Credentials are read from the credentials Secret of a repository
+type Credentials struct {
+	build.Credentials
+	// KnownHosts verify the host keys of ssh repositories
+	KnownHosts []byte
+}
+
+// ProviderFunc returns the provider of repo authenticated with creds, which are empty for public repositories
+type ProviderFunc func(repo *appv1alpha1.Repository, creds Credentials) SourceProvider
+
+// DefaultProviders returns the SourceProviders of the hosting services, public GitHub repositories are read with
+// githubToken so reads don't share the anonymous rate limit
+func DefaultProviders(githubToken string) ProviderFunc {
+	return func(repo *appv1alpha1.Repository, creds Credentials) SourceProvider {
+		switch repo.GetProvider() {
+		case appv1alpha1.GitProviderGitLab:
+			return git.NewGitLabService(repo.URL, creds.Token)
+		case appv1alpha1.GitProviderAzureDevOps:
+			return git.NewAzureDevOpsService(repo.URL, creds.Token)
+		case appv1alpha1.GitProviderBitbucket:
+			return git.NewBitbucketService(creds.Token)
+		case appv1alpha1.GitProviderGit:
+			return git.NewGitService(repo.URL, git.Credentials{
+				Username:      creds.Username,
+				Token:         creds.Token,
+				SSHPrivateKey: creds.SSHPrivateKey,
+				KnownHosts:    creds.KnownHosts,
+			})
+		}
+
+		if creds.Token == "" {
+			return github.NewGitHubService(githubToken)
+		}
+		return github.NewGitHubService(creds.Token)
+	}
+}
+
+// Providers returns the SourceProvider and clone credentials of the repository of an Application
+type Providers struct {
+	client client.Reader
+	tokens *github.TokenCache
+	new    ProviderFunc
+}
+
+func NewProviders(cl client.Reader, tokens *github.TokenCache, newProvider ProviderFunc) *Providers {
+	return &Providers{client: cl, tokens: tokens, new: newProvider}
+}
+
+// For returns the provider of repo and the credentials builds clone it with, read from its credentials Secret in
+// namespace
+func (p *Providers) For(ctx context.Context, namespace string, repo *appv1alpha1.Repository) (SourceProvider, build.Credentials, error) {
+	creds, err := p.credentials(ctx, namespace, repo)
+	if err != nil {
+		return nil, build.Credentials{}, err
+	}
+
+	return p.new(repo, creds), creds.Credentials, nil
+}
+
+func (p *Providers) credentials(ctx context.Context, namespace string, repo *appv1alpha1.Repository) (Credentials, error) {
+	if repo.CredentialsSecretName == "" {
+		return Credentials{}, nil
+	}
+
+	key := client.ObjectKey{Namespace: namespace, Name: repo.CredentialsSecretName}
+	provider := repo.GetProvider()
+	// GitHub Apps mint their tokens, the other providers store them
+	if provider == appv1alpha1.GitProviderGitHub {
+		token, err := p.tokens.TokenFromSecret(ctx, p.client, key)
+		if err != nil {
+			return Credentials{}, err
+		}
+		return Credentials{Credentials: build.Credentials{Username: tokenUsernames[provider], Token: token}}, nil
+	}
+
+	secret := &corev1.Secret{}
+	if err := p.client.Get(ctx, key, secret); err != nil {
+		return Credentials{}, fmt.Errorf("getting repository credentials secret %s: %w", key, err)
+	}
+
+	creds := Credentials{Credentials: build.Credentials{
+		Username: tokenUsernames[provider],
+		Token:    strings.TrimSpace(string(secret.Data[github.TokenKey])),
+	}}
+	if provider == appv1alpha1.GitProviderGit {
+		if username := strings.TrimSpace(string(secret.Data[git.UsernameKey])); username != "" {
+			creds.Username = username
+		}
+		creds.SSHPrivateKey = secret.Data[git.SSHPrivateKeyKey]
+		creds.KnownHosts = secret.Data[git.KnownHostsKey]
+	}
+
+	if creds.Token == "" && len(creds.SSHPrivateKey) == 0 {
+		return Credentials{}, fmt.Errorf("secret %s has neither a %s nor a %s key", secret.Name, github.TokenKey, git.SSHPrivateKeyKey)
+	}
+	return creds, nil
+}
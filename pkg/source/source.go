@@ -0,0 +1,56 @@
+package source
+
+import (
+	"sync"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// Events queues Applications whose source moved to a new commit for a rebuild. The commit is kept until the
+// Application's next build takes it, so the build doesn't depend on the branch head the GitHub API reports.
+type Events struct {
+	ch chan event.GenericEvent
+
+	mu      sync.Mutex
+	commits map[types.NamespacedName]string
+}
+
+func NewEvents() *Events {
+	return &Events{
+		ch:      make(chan event.GenericEvent, 1024),
+		commits: map[types.NamespacedName]string{},
+	}
+}
+
+// Source returns the controller source the queued Applications are delivered from
+func (e *Events) Source() source.Source {
+	return &source.Channel{Source: e.ch}
+}
+
+// Push queues app for a rebuild at commit, a later push replaces a commit that wasn't taken yet
+func (e *Events) Push(app types.NamespacedName, commit string) {
+	e.mu.Lock()
+	e.commits[app] = commit
+	e.mu.Unlock()
+
+	e.ch <- event.GenericEvent{Object: &appv1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: app.Name, Namespace: app.Namespace},
+	}}
+}
+
+// TakeCommit returns and forgets the commit pushed for app, false if none was
+func (e *Events) TakeCommit(app types.NamespacedName) (string, bool) {
+	if e == nil {
+		return "", false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	commit, ok := e.commits[app]
+	delete(e.commits, app)
+	return commit, ok
+}
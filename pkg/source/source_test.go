@@ -0,0 +1,364 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"github.com/bfoley13/appcontroller/pkg/build"
+	"github.com/bfoley13/appcontroller/pkg/git"
+	"github.com/bfoley13/appcontroller/pkg/github"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testSecret = "webhook-secret"
+
+func testApp(namespace, name, owner, repo, branch string) *appv1alpha1.Application {
+	return &appv1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: appv1alpha1.ApplicationSpec{
+			Repository: &appv1alpha1.Repository{Owner: owner, Name: repo, BranchName: branch},
+		},
+	}
+}
+
+// deliver posts payload to url as a GitHub webhook delivery of event, signed with secret
+func deliver(t *testing.T, url, event, secret string, payload []byte) *http.Response {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func TestEvents(t *testing.T) {
+	events := NewEvents()
+	key := types.NamespacedName{Namespace: "default", Name: "app"}
+
+	events.Push(key, "commit-1")
+	events.Push(key, "commit-2")
+	assert.Len(t, events.ch, 2)
+	assert.Equal(t, key, client.ObjectKeyFromObject((<-events.ch).Object))
+
+	commit, ok := events.TakeCommit(key)
+	assert.True(t, ok)
+	assert.Equal(t, "commit-2", commit)
+	_, ok = events.TakeCommit(key)
+	assert.False(t, ok)
+
+	var none *Events
+	_, ok = none.TakeCommit(key)
+	assert.False(t, ok)
+}
+
+func TestGitHubWebhook(t *testing.T) {
+	payload, err := os.ReadFile("testdata/push.json")
+	assert.Nil(t, err)
+
+	s := runtime.NewScheme()
+	utilruntime.Must(appv1alpha1.AddToScheme(s))
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(
+		testApp("default", "echo", "bfoley13", "go_echo", "main"),
+		testApp("staging", "echo", "BFoley13", "Go_Echo", "main"),
+		testApp("default", "echo-dev", "bfoley13", "go_echo", "dev"),
+		testApp("default", "other", "bfoley13", "other", "main"),
+		&appv1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "prebuilt", Namespace: "default"}},
+	).Build()
+
+	events := NewEvents()
+	server := httptest.NewServer(NewGitHubWebhook(cl, testSecret, events))
+	t.Cleanup(server.Close)
+
+	t.Run("queues the applications building the pushed branch", func(t *testing.T) {
+		resp := deliver(t, server.URL, "push", testSecret, payload)
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+		assert.Len(t, events.ch, 2)
+
+		for _, key := range []types.NamespacedName{{Namespace: "default", Name: "echo"}, {Namespace: "staging", Name: "echo"}} {
+			commit, ok := events.TakeCommit(key)
+			assert.True(t, ok, key.String())
+			assert.Equal(t, "9c6b8f1e2d4a7b3c5e8f0a1b2c3d4e5f6a7b8c9d", commit)
+		}
+		for len(events.ch) > 0 {
+			<-events.ch
+		}
+	})
+
+	t.Run("rejects invalid signatures", func(t *testing.T) {
+		resp := deliver(t, server.URL, "push", "wrong-secret", payload)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Empty(t, events.ch)
+
+		req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(payload))
+		assert.Nil(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", "push")
+		resp, err = http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("ignores deleted branches and tags", func(t *testing.T) {
+		deleted := bytes.Replace(payload, []byte(`"deleted": false`), []byte(`"deleted": true`), 1)
+		resp := deliver(t, server.URL, "push", testSecret, deleted)
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		tag := bytes.Replace(payload, []byte(`"refs/heads/main"`), []byte(`"refs/tags/main"`), 1)
+		resp = deliver(t, server.URL, "push", testSecret, tag)
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+		assert.Empty(t, events.ch)
+	})
+
+	t.Run("acknowledges pings", func(t *testing.T) {
+		resp := deliver(t, server.URL, "ping", testSecret, []byte(`{"zen":"Keep it logically awesome.","hook_id":1}`))
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+// fakeHeads answers conditional branch head requests, the other provider methods aren't implemented
+type fakeHeads struct {
+	SourceProvider
+	heads    map[string]string
+	requests []string
+}
+
+func (f *fakeHeads) GetBranchHeadIfChanged(_ context.Context, owner, repo, branch, etag string) (string, string, bool, error) {
+	key := owner + "/" + repo + "/" + branch
+	f.requests = append(f.requests, key)
+	sha, ok := f.heads[key]
+	if !ok {
+		return "", "", false, fmt.Errorf("branch %s not found", key)
+	}
+	if etag == `"`+sha+`"` {
+		return "", etag, false, nil
+	}
+	return sha, `"` + sha + `"`, true, nil
+}
+
+// fakeRefs resolves refs on every request like providers without conditional requests
+type fakeRefs struct {
+	SourceProvider
+	heads map[string]string
+}
+
+func (f *fakeRefs) ResolveRef(_ context.Context, owner, repo, ref string) (string, error) {
+	sha, ok := f.heads[owner+"/"+repo+"/"+ref]
+	if !ok {
+		return "", fmt.Errorf("ref %s not found", ref)
+	}
+	return sha, nil
+}
+
+func TestPoller(t *testing.T) {
+	ctx := context.Background()
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	utilruntime.Must(appv1alpha1.AddToScheme(s))
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(
+		testApp("default", "echo", "bfoley13", "go_echo", "main"),
+		testApp("staging", "echo", "BFoley13", "go_echo", "main"),
+		testApp("default", "echo-dev", "bfoley13", "go_echo", "dev"),
+		testApp("default", "missing", "bfoley13", "missing", "main"),
+		&appv1alpha1.Application{ObjectMeta: metav1.ObjectMeta{Name: "prebuilt", Namespace: "default"}},
+	).Build()
+
+	heads := &fakeHeads{heads: map[string]string{
+		"bfoley13/go_echo/main": "commit-1",
+		"bfoley13/go_echo/dev":  "commit-1",
+	}}
+	events := NewEvents()
+	refs := &fakeRefs{heads: map[string]string{}}
+	var tokens []string
+	p := NewPoller(cl, NewProviders(cl, github.NewTokenCache(nil), func(repo *appv1alpha1.Repository, creds Credentials) SourceProvider {
+		if creds.Token != "" {
+			tokens = append(tokens, creds.Token)
+		}
+		if repo.GetProvider() != appv1alpha1.GitProviderGitHub {
+			return refs
+		}
+		return heads
+	}), time.Minute, events)
+
+	t.Run("deduplicates branches and doesn't queue the first head", func(t *testing.T) {
+		assert.Nil(t, p.poll(ctx))
+		assert.ElementsMatch(t, []string{"bfoley13/go_echo/main", "bfoley13/go_echo/dev", "bfoley13/missing/main"}, heads.requests)
+		assert.Empty(t, events.ch)
+	})
+
+	t.Run("doesn't queue unchanged heads", func(t *testing.T) {
+		assert.Nil(t, p.poll(ctx))
+		assert.Empty(t, events.ch)
+	})
+
+	t.Run("queues the applications of a moved head", func(t *testing.T) {
+		heads.heads["bfoley13/go_echo/main"] = "commit-2"
+		assert.Nil(t, p.poll(ctx))
+		assert.Len(t, events.ch, 2)
+
+		for _, key := range []types.NamespacedName{{Namespace: "default", Name: "echo"}, {Namespace: "staging", Name: "echo"}} {
+			commit, ok := events.TakeCommit(key)
+			assert.True(t, ok, key.String())
+			assert.Equal(t, "commit-2", commit)
+		}
+		_, ok := events.TakeCommit(types.NamespacedName{Namespace: "default", Name: "echo-dev"})
+		assert.False(t, ok)
+	})
+
+	t.Run("reads private repositories with the credentials of their applications", func(t *testing.T) {
+		private := testApp("default", "private", "bfoley13", "private", "main")
+		private.Spec.Repository.CredentialsSecretName = "github"
+		assert.Nil(t, cl.Create(ctx, private))
+		assert.Nil(t, cl.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "github", Namespace: "default"},
+			Data:       map[string][]byte{github.TokenKey: []byte("ghp_token")},
+		}))
+
+		heads.heads["bfoley13/private/main"] = "commit-1"
+		assert.Nil(t, p.poll(ctx))
+		assert.Equal(t, []string{"ghp_token"}, tokens)
+		assert.Contains(t, p.heads, polledBranch{
+			branch:      branch{provider: appv1alpha1.GitProviderGitHub, owner: "bfoley13", repo: "private", name: "main"},
+			credentials: types.NamespacedName{Namespace: "default", Name: "github"},
+		})
+	})
+
+	t.Run("doesn't share credentials across namespaces", func(t *testing.T) {
+		private := testApp("staging", "private", "bfoley13", "private", "main")
+		private.Spec.Repository.CredentialsSecretName = "github"
+		assert.Nil(t, cl.Create(ctx, private))
+		staging := polledBranch{
+			branch:      branch{provider: appv1alpha1.GitProviderGitHub, owner: "bfoley13", repo: "private", name: "main"},
+			credentials: types.NamespacedName{Namespace: "staging", Name: "github"},
+		}
+
+		tokens = nil
+		assert.Nil(t, p.poll(ctx))
+		assert.Equal(t, []string{"ghp_token"}, tokens, "only the default Application is polled")
+		assert.NotContains(t, p.heads, staging)
+
+		assert.Nil(t, cl.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "github", Namespace: "staging"},
+			Data:       map[string][]byte{github.TokenKey: []byte("ghp_staging")},
+		}))
+		tokens = nil
+		assert.Nil(t, p.poll(ctx))
+		assert.ElementsMatch(t, []string{"ghp_token", "ghp_staging"}, tokens)
+		assert.Contains(t, p.heads, staging)
+	})
+
+	t.Run("resolves the branches of providers without conditional requests", func(t *testing.T) {
+		gitlab := testApp("default", "gitlab", "group", "go_echo", "main")
+		gitlab.Spec.Repository.Provider = appv1alpha1.GitProviderGitLab
+		assert.Nil(t, cl.Create(ctx, gitlab))
+		refs.heads["group/go_echo/main"] = "commit-1"
+		queued := len(events.ch)
+		assert.Nil(t, p.poll(ctx))
+		assert.Len(t, events.ch, queued)
+
+		refs.heads["group/go_echo/main"] = "commit-2"
+		assert.Nil(t, p.poll(ctx))
+		commit, ok := events.TakeCommit(types.NamespacedName{Namespace: "default", Name: "gitlab"})
+		assert.True(t, ok)
+		assert.Equal(t, "commit-2", commit)
+		assert.Len(t, events.ch, queued+1)
+	})
+
+	t.Run("forgets branches no application references", func(t *testing.T) {
+		assert.Nil(t, cl.Delete(ctx, testApp("default", "echo-dev", "", "", "")))
+		assert.Nil(t, p.poll(ctx))
+		assert.NotContains(t, p.heads, polledBranch{branch: branch{provider: appv1alpha1.GitProviderGitHub, owner: "bfoley13", repo: "go_echo", name: "dev"}})
+	})
+}
+
+func TestProviders(t *testing.T) {
+	ctx := context.Background()
+	s := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(s))
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "gitlab", Namespace: "default"},
+			Data:       map[string][]byte{github.TokenKey: []byte("glpat\n")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "ssh", Namespace: "default"},
+			Data: map[string][]byte{
+				git.UsernameKey:      []byte("deploy"),
+				git.SSHPrivateKeyKey: []byte("private key"),
+				git.KnownHostsKey:    []byte("known hosts"),
+			},
+		},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: "default"}},
+	).Build()
+
+	var got Credentials
+	providers := NewProviders(cl, github.NewTokenCache(nil), func(_ *appv1alpha1.Repository, creds Credentials) SourceProvider {
+		got = creds
+		return nil
+	})
+
+	t.Run("public repositories have no credentials", func(t *testing.T) {
+		_, creds, err := providers.For(ctx, "default", &appv1alpha1.Repository{Owner: "bfoley13", Name: "go_echo"})
+		assert.Nil(t, err)
+		assert.Equal(t, build.Credentials{}, creds)
+	})
+
+	t.Run("reads provider tokens", func(t *testing.T) {
+		repo := &appv1alpha1.Repository{Provider: appv1alpha1.GitProviderGitLab, Owner: "group", Name: "go_echo", CredentialsSecretName: "gitlab"}
+		_, creds, err := providers.For(ctx, "default", repo)
+		assert.Nil(t, err)
+		assert.Equal(t, build.Credentials{Username: "oauth2", Token: "glpat"}, creds)
+	})
+
+	t.Run("reads ssh keys of git repositories", func(t *testing.T) {
+		repo := &appv1alpha1.Repository{Provider: appv1alpha1.GitProviderGit, URL: "git@git.example.com:team/echo.git", CredentialsSecretName: "ssh"}
+		_, creds, err := providers.For(ctx, "default", repo)
+		assert.Nil(t, err)
+		assert.Equal(t, build.Credentials{Username: "deploy", SSHPrivateKey: []byte("private key")}, creds)
+		assert.Equal(t, []byte("known hosts"), got.KnownHosts)
+	})
+
+	t.Run("rejects secrets without credentials", func(t *testing.T) {
+		repo := &appv1alpha1.Repository{Provider: appv1alpha1.GitProviderBitbucket, Owner: "team", Name: "echo", CredentialsSecretName: "empty"}
+		_, _, err := providers.For(ctx, "default", repo)
+		assert.ErrorContains(t, err, "neither")
+
+		repo.CredentialsSecretName = "missing"
+		_, _, err = providers.For(ctx, "default", repo)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("defaults to GitHub", func(t *testing.T) {
+		provider := DefaultProviders("")(&appv1alpha1.Repository{Owner: "bfoley13", Name: "go_echo"}, Credentials{})
+		assert.IsType(t, &github.GitHubService{}, provider)
+
+		provider = DefaultProviders("")(&appv1alpha1.Repository{Provider: appv1alpha1.GitProviderAzureDevOps}, Credentials{})
+		assert.IsType(t, &git.AzureDevOpsService{}, provider)
+	})
+}
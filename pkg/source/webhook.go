@@ -0,0 +1,148 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	appv1alpha1 "github.com/bfoley13/appcontroller/api/v1alpha1"
+	"github.com/google/go-github/v42/github"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// GitHubWebhookPath is the path the GitHub push webhook is served at
+const GitHubWebhookPath = "/github"
+
+// GitHubWebhook receives GitHub push events and queues the Applications building the pushed branch
+type GitHubWebhook struct {
+	client client.Reader
+	secret []byte
+	events *Events
+}
+
+func NewGitHubWebhook(cl client.Reader, secret string, events *Events) *GitHubWebhook {
+	return &GitHubWebhook{
+		client: cl,
+		secret: []byte(secret),
+		events: events,
+	}
+}
+
+func (g *GitHubWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lgr := log.FromContext(r.Context()).WithName("github-webhook")
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// ValidatePayload falls back to the sha1 signature, only the sha256 one is accepted
+	if r.Header.Get(github.SHA256SignatureHeader) == "" {
+		http.Error(w, "missing signature", http.StatusUnauthorized)
+		return
+	}
+	payload, err := github.ValidatePayload(r, g.secret)
+	if err != nil {
+		lgr.Info("rejected webhook delivery", "delivery", github.DeliveryID(r), "error", err.Error())
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := github.ParseWebHook(github.WebHookType(r), payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch event := event.(type) {
+	case *github.PingEvent:
+		w.WriteHeader(http.StatusOK)
+	case *github.PushEvent:
+		queued, err := g.push(r.Context(), event)
+		if err != nil {
+			lgr.Error(err, "unable to queue applications for push", "delivery", github.DeliveryID(r))
+			http.Error(w, "unable to queue applications", http.StatusInternalServerError)
+			return
+		}
+
+		lgr.Info("received push", "delivery", github.DeliveryID(r), "repository", event.GetRepo().GetFullName(), "ref", event.GetRef(), "commit", event.GetAfter(), "applications", queued)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		// other events the hook is subscribed to are acknowledged and ignored
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// push queues the Applications that build the branch event pushed to and returns how many were queued
+func (g *GitHubWebhook) push(ctx context.Context, event *github.PushEvent) (int, error) {
+	name, ok := strings.CutPrefix(event.GetRef(), "refs/heads/")
+	if !ok || event.GetDeleted() {
+		return 0, nil
+	}
+
+	owner := event.GetRepo().GetOwner().GetLogin()
+	if owner == "" {
+		owner = event.GetRepo().GetOwner().GetName()
+	}
+	pushed := branchOf(&appv1alpha1.Repository{Owner: owner, Name: event.GetRepo().GetName(), BranchName: name})
+
+	var apps appv1alpha1.ApplicationList
+	if err := g.client.List(ctx, &apps); err != nil {
+		return 0, fmt.Errorf("listing applications: %w", err)
+	}
+
+	queued := 0
+	for _, app := range apps.Items {
+		if app.Spec.Repository == nil || branchOf(app.Spec.Repository) != pushed {
+			continue
+		}
+
+		g.events.Push(types.NamespacedName{Namespace: app.Namespace, Name: app.Name}, event.GetAfter())
+		queued++
+	}
+
+	return queued, nil
+}
+
+// NewGitHubWebhookServer returns a manager runnable serving webhook at addr
+func NewGitHubWebhookServer(addr string, webhook *GitHubWebhook) manager.Runnable {
+	mux := http.NewServeMux()
+	mux.Handle(GitHubWebhookPath, webhook)
+	return &server{srv: &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}}
+}
+
+type server struct {
+	srv *http.Server
+}
+
+func (s *server) Start(ctx context.Context) error {
+	lgr := log.FromContext(ctx).WithName("github-webhook")
+	errs := make(chan error, 1)
+	go func() {
+		lgr.Info("serving github webhook", "addr", s.srv.Addr, "path", GitHubWebhookPath)
+		if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errs <- fmt.Errorf("serving github webhook: %w", err)
+		}
+		close(errs)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.srv.Shutdown(shutdownCtx)
+}
+
+// NeedLeaderElection is true because the queued Applications are only consumed by the leader's controller
+func (s *server) NeedLeaderElection() bool {
+	return true
+}